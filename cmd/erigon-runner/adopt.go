@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// Files kept in the datadir of a node run with -adopt. The child writes its
+// output to files rather than pipes, so it survives the runner and a new
+// runner can pick up reading them.
+const (
+	pidFile    = "erigon-runner.pid"
+	stdoutFile = "erigon-runner-stdout.log"
+	stderrFile = "erigon-runner-stderr.log"
+)
+
+// followInterval is how often followed output files are polled for more.
+const followInterval = 200 * time.Millisecond
+
+// errAdoptedExited is returned for an adopted cdk-erigon, whose exit status
+// can't be learned, once it is gone.
+var errAdoptedExited = errors.New("exited, status unknown as it was adopted")
+
+// errDetached is returned once the runner left its child running on
+// Shutdown.
+var errDetached = errors.New("left running for the next runner to adopt")
+
+// pidRecord is the content of the pidfile.
+type pidRecord struct {
+	PID    int `json:"pid"`
+	Runner int `json:"runner"`
+}
+
+func writePidFile(datadir string, pid int) error {
+	content, err := json.Marshal(pidRecord{PID: pid, Runner: os.Getpid()})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(datadir, pidFile), content, 0644)
+}
+
+func removePidFile(datadir string) {
+	os.Remove(filepath.Join(datadir, pidFile))
+}
+
+// adoptablePID returns the node a previous runner left running on datadir,
+// or 0 if there is none. The node must run the binary named name, the one
+// this runner would launch. It fails when that runner is still alive.
+func adoptablePID(datadir, name string) (int, error) {
+	content, err := os.ReadFile(filepath.Join(datadir, pidFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read pidfile: %w", err)
+	}
+	var record pidRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return 0, fmt.Errorf("failed to parse pidfile %s: %w", filepath.Join(datadir, pidFile), err)
+	}
+	if record.PID <= 0 || !processAlive(record.PID) {
+		return 0, nil
+	}
+	// A reused PID belongs to something else.
+	if cmdline, err := processCmdline(record.PID); err == nil && !strings.Contains(cmdline, name) {
+		return 0, nil
+	}
+	if record.Runner != os.Getpid() && record.Runner > 0 && processAlive(record.Runner) {
+		return 0, fmt.Errorf("cdk-erigon %d on %s is still supervised by runner %d", record.PID, datadir, record.Runner)
+	}
+	return record.PID, nil
+}
+
+// outputCheckInterval is how often the output files of a child are checked
+// for rotation.
+const outputCheckInterval = time.Minute
+
+// openOutput opens the output files of a child on datadir in append mode,
+// rotated by the runner as the child writes them directly.
+func openOutput(datadir string, rotation alerting.LogRotationConfig) ([]*alerting.RotatingFile, error) {
+	var files []*alerting.RotatingFile
+	for _, name := range []string{stdoutFile, stderrFile} {
+		rf, err := alerting.NewSharedRotatingFile(filepath.Join(datadir, name), rotation)
+		if err != nil {
+			closeOutput(files)
+			return nil, fmt.Errorf("failed to open output file: %w", err)
+		}
+		files = append(files, rf)
+	}
+	return files, nil
+}
+
+func closeOutput(files []*alerting.RotatingFile) {
+	for _, rf := range files {
+		rf.Close()
+	}
+}
+
+// rotateOutput rotates the output files whenever they exceed their limits,
+// until done is closed.
+func (s *supervisor) rotateOutput(files []*alerting.RotatingFile, done <-chan struct{}) {
+	ticker := time.NewTicker(outputCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		for _, rf := range files {
+			if err := rf.Check(); err != nil {
+				s.logf("Error rotating output of cdk-erigon: %v\n", err)
+			}
+		}
+	}
+}
+
+// followOutput opens the output files on datadir for reading, from their
+// end when the child has been running already.
+func followOutput(datadir string, fromEnd bool, done <-chan struct{}) (*followReader, *followReader, error) {
+	var readers []*followReader
+	for _, name := range []string{stdoutFile, stderrFile} {
+		f, err := os.Open(filepath.Join(datadir, name))
+		if err == nil && fromEnd {
+			_, err = f.Seek(0, io.SeekEnd)
+		}
+		if err != nil {
+			for _, r := range readers {
+				r.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to open output file: %w", err)
+		}
+		readers = append(readers, &followReader{File: f, done: done})
+	}
+	return readers[0], readers[1], nil
+}
+
+// followReader reads a file that is still being written like tail -f, until
+// done is closed and the rest is read.
+type followReader struct {
+	*os.File
+	done <-chan struct{}
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.File.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		if r.truncated() {
+			continue
+		}
+		select {
+		case <-r.done:
+			return r.File.Read(p)
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+// truncated seeks back to the start of a file a rotation truncated.
+func (r *followReader) truncated() bool {
+	info, err := r.File.Stat()
+	if err != nil {
+		return false
+	}
+	offset, err := r.File.Seek(0, io.SeekCurrent)
+	if err != nil || info.Size() >= offset {
+		return false
+	}
+	_, err = r.File.Seek(0, io.SeekStart)
+	return err == nil
+}
+
+// watchAdopted supervises the cdk-erigon a previous runner left running:
+// its output files are followed and stops are forwarded to it until it is
+// gone.
+func (s *supervisor) watchAdopted(pid int) error {
+	gone := make(chan struct{})
+	following := s.untilDetached(gone)
+	stdout, stderr, err := followOutput(s.adoptDir, true, following)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	defer stderr.Close()
+	files, err := openOutput(s.adoptDir, s.outputRotation)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(files)
+	go s.rotateOutput(files, following)
+	if err := writePidFile(s.adoptDir, pid); err != nil {
+		s.logf("Error writing pidfile: %v\n", err)
+	}
+
+	s.setStatus(func(st *childStatus) {
+		st.State = "running"
+		st.PID = pid
+		if info, err := os.Stat(filepath.Join(s.adoptDir, stdoutFile)); err == nil {
+			started := info.ModTime()
+			st.Started = &started
+		}
+	})
+	s.logf("Adopted running cdk-erigon %d\n", pid)
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	exited := make(chan struct{})
+	defer close(exited)
+	go s.forwardStop(&exec.Cmd{Process: process}, exited)
+	go func() {
+		for processAlive(pid) {
+			time.Sleep(time.Second)
+		}
+		close(gone)
+	}()
+	s.processOutput(stdout, stderr)
+	if s.detached() {
+		return errDetached
+	}
+	removePidFile(s.adoptDir)
+	return errAdoptedExited
+}
+
+// untilDetached returns a channel closed once done is or the runner detached
+// from its child.
+func (s *supervisor) untilDetached(done <-chan struct{}) <-chan struct{} {
+	until := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-s.detach:
+		}
+		close(until)
+	}()
+	return until
+}
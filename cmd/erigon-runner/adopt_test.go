@@ -0,0 +1,160 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// newAdoptSupervisor returns a supervisor running script with -adopt on a
+// temporary datadir.
+func newAdoptSupervisor(t *testing.T, script string) *supervisor {
+	t.Helper()
+	pipeline, err := alerting.NewPipeline(&alerting.Config{}, alerting.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	return &supervisor{
+		dir:      dir,
+		binary:   "/bin/sh",
+		args:     []string{"-c", script},
+		pipeline: pipeline,
+		adoptDir: dir,
+		grace:    time.Second,
+		stop:     make(chan struct{}),
+		kill:     make(chan struct{}),
+		detach:   make(chan struct{}),
+		restart:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		tail:     newLogTail(10),
+	}
+}
+
+// runSupervisor runs s until it is running, returning run's result.
+func runSupervisor(t *testing.T, s *supervisor) <-chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() { result <- s.run() }()
+	for deadline := time.Now().Add(5 * time.Second); s.Status().State != "running"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("child not running, status %+v", s.Status())
+		}
+	}
+	return result
+}
+
+func waitRun(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return")
+		return nil
+	}
+}
+
+func TestShutdownLeavesAdoptedChildRunning(t *testing.T) {
+	s := newAdoptSupervisor(t, "exec sleep 30")
+	result := runSupervisor(t, s)
+	pid := s.Status().PID
+	process, _ := os.FindProcess(pid)
+	defer process.Kill()
+
+	s.Shutdown(os.Interrupt)
+	if err := waitRun(t, result); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !processAlive(pid) {
+		t.Fatal("child stopped on shutdown")
+	}
+	if state := s.Status().State; state != "detached" {
+		t.Errorf("state %q, want detached", state)
+	}
+	if _, err := os.Stat(filepath.Join(s.adoptDir, pidFile)); err != nil {
+		t.Errorf("pidfile removed: %v", err)
+	}
+}
+
+func TestStopEndsAdoptedChild(t *testing.T) {
+	s := newAdoptSupervisor(t, "exec sleep 30")
+	result := runSupervisor(t, s)
+	pid := s.Status().PID
+
+	s.Stop(syscall.SIGTERM)
+	waitRun(t, result)
+	if processAlive(pid) {
+		process, _ := os.FindProcess(pid)
+		process.Kill()
+		t.Fatal("child still running after Stop")
+	}
+	if _, err := os.Stat(filepath.Join(s.adoptDir, pidFile)); !os.IsNotExist(err) {
+		t.Errorf("pidfile kept: %v", err)
+	}
+}
+
+func TestRunDetachedStartsNewOutputFiles(t *testing.T) {
+	s := newAdoptSupervisor(t, "echo second")
+	stdoutPath := filepath.Join(s.adoptDir, stdoutFile)
+	if err := os.WriteFile(stdoutPath, []byte("first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.runDetached(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(stdoutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "second\n" {
+		t.Errorf("output file = %q, want only this run's output", content)
+	}
+	backups, err := filepath.Glob(stdoutPath + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want the previous run's", backups)
+	}
+	if lines := s.tail.Lines(10); len(lines) != 1 || !strings.Contains(lines[0], "second") {
+		t.Errorf("followed lines = %q, want only this run's", lines)
+	}
+}
+
+func TestFollowReaderRestartsAfterTruncation(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := os.OpenFile(filepath.Join(dir, stdoutFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err := os.WriteFile(filepath.Join(dir, stderrFile), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writer.WriteString("before rotation\n")
+	stdout, stderr, err := followOutput(dir, false, make(chan struct{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	defer stderr.Close()
+
+	buf := make([]byte, 64)
+	if n, err := stdout.Read(buf); err != nil || string(buf[:n]) != "before rotation\n" {
+		t.Fatalf("read %q, %v", buf[:n], err)
+	}
+	writer.Truncate(0)
+	writer.WriteString("after\n")
+	if n, err := stdout.Read(buf); err != nil || string(buf[:n]) != "after\n" {
+		t.Fatalf("read %q, %v after the truncation", buf[:n], err)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var benchHeader = []string{"time", "node", "version", "elapsed_seconds", "block", "batch", "blocks_per_sec", "batches_per_sec"}
+
+// benchmark samples the sync progress of the nodes into a CSV file, one row
+// per node and interval, and prints a summary once stopped. Rows are appended
+// with the running version, so runs of different builds or hardware can be
+// compared from one file.
+type benchmark struct {
+	nodes    []*node
+	interval time.Duration
+	path     string
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// benchProgress is the progress of one node over the benchmark.
+type benchProgress struct {
+	first, last      benchSample
+	sampled          bool
+	peakBlocksPerSec float64
+}
+
+type benchSample struct {
+	at           time.Time
+	block, batch uint64
+}
+
+func newBenchmark(nodes []*node, interval time.Duration, path string) *benchmark {
+	return &benchmark{nodes: nodes, interval: interval, path: path, quit: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (b *benchmark) run() {
+	defer close(b.done)
+	file, err := os.OpenFile(b.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening benchmark file: %v\n", err)
+		return
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		w.Write(benchHeader)
+	}
+
+	start := time.Now()
+	progress := make([]benchProgress, len(b.nodes))
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.quit:
+			b.printSummary(progress)
+			return
+		case <-ticker.C:
+		}
+		for i, n := range b.nodes {
+			if row := progress[i].sample(n, start); row != nil {
+				w.Write(row)
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing benchmark file: %v\n", err)
+		}
+	}
+}
+
+// Stop ends the benchmark and waits for its summary.
+func (b *benchmark) Stop() {
+	close(b.quit)
+	<-b.done
+}
+
+// sample records the progress of n and returns its CSV row, or nil if the
+// node didn't answer.
+func (p *benchProgress) sample(n *node, start time.Time) []string {
+	if n.rpc == nil {
+		return nil
+	}
+	block, err := n.rpc.callUint64("eth_blockNumber")
+	if err != nil {
+		return nil
+	}
+	// Plain erigon has no batches, which are left at 0.
+	batch, _ := n.rpc.callUint64("zkevm_batchNumber")
+	s := benchSample{at: time.Now(), block: block, batch: batch}
+	var blockRate, batchRate float64
+	if p.sampled {
+		blockRate = rate(p.last.block, s.block, s.at.Sub(p.last.at))
+		batchRate = rate(p.last.batch, s.batch, s.at.Sub(p.last.at))
+		if blockRate > p.peakBlocksPerSec {
+			p.peakBlocksPerSec = blockRate
+		}
+	} else {
+		p.first, p.sampled = s, true
+	}
+	p.last = s
+	return []string{
+		s.at.UTC().Format(time.RFC3339),
+		n.name,
+		n.supervisor.Status().Version,
+		strconv.FormatFloat(s.at.Sub(start).Seconds(), 'f', 0, 64),
+		strconv.FormatUint(block, 10),
+		strconv.FormatUint(batch, 10),
+		strconv.FormatFloat(blockRate, 'f', 2, 64),
+		strconv.FormatFloat(batchRate, 'f', 2, 64),
+	}
+}
+
+// rate is the progress per second from a to b, 0 when it went backwards as
+// after an unwind.
+func rate(a, b uint64, elapsed time.Duration) float64 {
+	if b < a || elapsed <= 0 {
+		return 0
+	}
+	return float64(b-a) / elapsed.Seconds()
+}
+
+func (b *benchmark) printSummary(progress []benchProgress) {
+	fmt.Println("Benchmark summary, samples in", b.path)
+	for i, n := range b.nodes {
+		p := progress[i]
+		prefix := "  "
+		if n.name != "" {
+			prefix += n.name + ": "
+		}
+		if !p.sampled || !p.last.at.After(p.first.at) {
+			fmt.Printf("%snot enough samples\n", prefix)
+			continue
+		}
+		elapsed := p.last.at.Sub(p.first.at)
+		fmt.Printf("%sblocks %d to %d and batches %d to %d in %s: %.2f blocks/s (peak %.2f), %.2f batches/s\n",
+			prefix, p.first.block, p.last.block, p.first.batch, p.last.batch, elapsed.Round(time.Second),
+			rate(p.first.block, p.last.block, elapsed), p.peakBlocksPerSec, rate(p.first.batch, p.last.batch, elapsed))
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// buildStamp records the commit of the last successful build, relative to the
+// repository.
+const buildStamp = "build/bin/.erigon-runner-build"
+
+// git runs a git command in repo and returns its trimmed output.
+func git(repo string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitHead returns the commit checked out in repo and whether the working tree
+// has uncommitted changes.
+func gitHead(repo string) (string, bool, error) {
+	head, err := git(repo, "rev-parse", "HEAD")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read git HEAD: %w", err)
+	}
+	status, err := git(repo, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read git status: %w", err)
+	}
+	return head, status != "", nil
+}
+
+// checkoutRef fetches the remote and checks out ref, a branch, tag or commit,
+// as a detached HEAD. Branches resolve to their state on origin so a stale
+// local branch isn't deployed. A dirty worktree is only discarded with force.
+func checkoutRef(repo, ref string, force bool) (string, error) {
+	_, dirty, err := gitHead(repo)
+	if err != nil {
+		return "", err
+	}
+	if dirty && !force {
+		return "", fmt.Errorf("worktree of %s has uncommitted changes, use -force to discard them", repo)
+	}
+	if _, err := git(repo, "fetch", "--tags", "--force", "origin"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error fetching, using local refs: %v\n", err)
+	}
+	commit, err := git(repo, "rev-parse", "--verify", "--quiet", "origin/"+ref+"^{commit}")
+	if err != nil {
+		commit, err = git(repo, "rev-parse", "--verify", ref+"^{commit}")
+		if err != nil {
+			return "", fmt.Errorf("unknown ref %s: %w", ref, err)
+		}
+	}
+	args := []string{"checkout", "--quiet", "--detach"}
+	if force {
+		args = append(args, "--force")
+	}
+	if _, err := git(repo, append(args, commit)...); err != nil {
+		return "", fmt.Errorf("failed to check out %s: %w", ref, err)
+	}
+	return commit, nil
+}
+
+// worktreePath is where the worktree of a node running its own revision is
+// kept: next to repo, so it doesn't show up in repo's status.
+func worktreePath(repo, name string) (string, error) {
+	abs, err := filepath.Abs(repo)
+	if err != nil {
+		return "", err
+	}
+	return abs + "-" + name, nil
+}
+
+// checkoutWorktree checks out ref like checkoutRef in the worktree of repo at
+// path, adding the worktree if it doesn't exist yet.
+func checkoutWorktree(repo, path, ref string, force bool) (string, error) {
+	if _, err := os.Stat(filepath.Join(path, ".git")); os.IsNotExist(err) {
+		if _, err := git(repo, "worktree", "add", "--detach", path); err != nil {
+			return "", fmt.Errorf("failed to add worktree %s: %w", path, err)
+		}
+	}
+	return checkoutRef(path, ref, force)
+}
+
+// buildNeeded reports whether the binary has to be rebuilt, i.e. it is
+// missing, the working tree is dirty or HEAD moved since the last build. It
+// also returns the HEAD to stamp after a successful build.
+func buildNeeded(repo, binary string) (bool, string, error) {
+	head, dirty, err := gitHead(repo)
+	if err != nil {
+		return true, "", err
+	}
+	if _, err := os.Stat(filepath.Join(repo, binary)); err != nil {
+		return true, head, nil
+	}
+	if dirty {
+		return true, "", nil
+	}
+	stamp, err := os.ReadFile(filepath.Join(repo, buildStamp))
+	if err != nil {
+		return true, head, nil
+	}
+	return strings.TrimSpace(string(stamp)) != head, head, nil
+}
+
+// buildTailLines is how much of the build output a build failure alert
+// carries.
+const buildTailLines = 30
+
+// buildOptions configure how cdk-erigon is built.
+type buildOptions struct {
+	// timeout bounds the build; 0 disables it.
+	timeout  time.Duration
+	pipeline *alerting.Pipeline
+	hooks    hookSet
+	// cleanRebuild retries a failed build once after make clean with a
+	// fresh build cache, which fixes most builds broken by stale objects.
+	cleanRebuild bool
+}
+
+// errBuildTimeout is the error of a build killed for running too long, which
+// isn't retried.
+var errBuildTimeout = errors.New("build timed out")
+
+// build runs make cdk-erigon in repo and, given a clean HEAD, records it. The
+// output is printed and fed to the pipeline; a failure or a build running
+// longer than the timeout is alerted with the tail of the output, after a
+// clean rebuild if enabled. The build hooks run around it.
+func build(repo, head string, opts buildOptions) error {
+	hookEnv := map[string]string{"REPO": repo, "COMMIT": head}
+	if err := opts.hooks.run(hookPreBuild, hookEnv); err != nil {
+		opts.pipeline.Event("hook-failed", "CRITICAL", fmt.Sprintf("Not building cdk-erigon: %v", err))
+		return err
+	}
+	tail, err := runMake(repo, "cdk-erigon", nil, opts.timeout, opts.pipeline)
+	if err != nil && opts.cleanRebuild && !errors.Is(err, errBuildTimeout) {
+		fmt.Fprintf(os.Stderr, "Error building cdk-erigon: %v, retrying once after make clean with a fresh build cache\n", err)
+		firstErr, firstTail := err, tail
+		if tail, err = cleanRebuild(repo, opts); err == nil {
+			fmt.Println("Clean rebuild of cdk-erigon succeeded")
+			opts.pipeline.Event("build-recovered", "WARNING", fmt.Sprintf("Building cdk-erigon at %s: %v; a clean rebuild succeeded\n\n%s",
+				shortCommit(head), firstErr, strings.Join(firstTail, "\n")))
+		} else {
+			fmt.Fprintf(os.Stderr, "Error in clean rebuild of cdk-erigon: %v\n", err)
+			err = fmt.Errorf("%w, and so did a clean rebuild", firstErr)
+		}
+	}
+	if err != nil {
+		opts.pipeline.Event("build-failed", "CRITICAL", fmt.Sprintf("Building cdk-erigon at %s: %v\n\n%s", shortCommit(head), err, strings.Join(tail, "\n")))
+		return err
+	}
+	writeStamp(repo, head)
+	if err := opts.hooks.run(hookPostBuild, hookEnv); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running hook: %v\n", err)
+		opts.pipeline.Event("hook-failed", "WARNING", err.Error())
+	}
+	return nil
+}
+
+// cleanRebuild runs make clean, then builds with a build cache of its own that
+// is removed afterwards.
+func cleanRebuild(repo string, opts buildOptions) ([]string, error) {
+	if tail, err := runMake(repo, "clean", nil, opts.timeout, opts.pipeline); err != nil {
+		return tail, fmt.Errorf("make clean failed: %w", err)
+	}
+	cache, err := os.MkdirTemp("", "erigon-runner-gocache-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build cache: %w", err)
+	}
+	defer os.RemoveAll(cache)
+	return runMake(repo, "cdk-erigon", []string{"GOCACHE=" + cache}, opts.timeout, opts.pipeline)
+}
+
+// runMake runs make target in repo with env added to the environment, printing
+// its output and feeding it to the pipeline, and returns the tail of the
+// output.
+func runMake(repo, target string, env []string, timeout time.Duration, pipeline *alerting.Pipeline) ([]string, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	buildCmd := exec.CommandContext(ctx, "make", target)
+	buildCmd.Dir = repo
+	if len(env) > 0 {
+		buildCmd.Env = append(os.Environ(), env...)
+	}
+	// make leaves the compiler running when only it is killed.
+	isolateChild(buildCmd)
+	buildCmd.Cancel = func() error {
+		killChild(buildCmd)
+		return nil
+	}
+	output, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output pipe: %w", err)
+	}
+	defer output.Close()
+	buildCmd.Stdout = w
+	buildCmd.Stderr = w
+	err = buildCmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start build: %w", err)
+	}
+
+	tail := newLogTail(buildTailLines)
+	scanner := alerting.NewLineScanner(output, 0)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Println("[build]", line)
+		tail.Add(line)
+		pipeline.Process(line)
+	}
+	err = buildCmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("%w after %s", errBuildTimeout, timeout)
+	} else if err != nil {
+		err = fmt.Errorf("build failed: %w", err)
+	}
+	return tail.Lines(buildTailLines), err
+}
+
+// shortCommit names the commit a build is of in alerts.
+func shortCommit(head string) string {
+	if head == "" {
+		return "a dirty worktree"
+	}
+	if len(head) > 12 {
+		return head[:12]
+	}
+	return head
+}
+
+// writeStamp records head as the commit the binary was built from; an empty
+// head clears the record.
+func writeStamp(repo, head string) {
+	stamp := filepath.Join(repo, buildStamp)
+	if head == "" {
+		os.Remove(stamp)
+		return
+	}
+	if err := os.WriteFile(stamp, []byte(head+"\n"), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error recording build commit: %v\n", err)
+	}
+}
+
+// prepareBinary builds cdk-erigon unless skipped or, with auto, up to date.
+func prepareBinary(repo, binary string, skip, auto bool, opts buildOptions) error {
+	switch {
+	case skip:
+		fmt.Println("Skipping build")
+		return nil
+	case auto:
+		needed, head, err := buildNeeded(repo, binary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error detecting changes, building anyway: %v\n", err)
+		}
+		if !needed {
+			fmt.Println("Skipping build, cdk-erigon is up to date with", head)
+			return nil
+		}
+		return build(repo, head, opts)
+	default:
+		head, dirty, err := gitHead(repo)
+		if err != nil || dirty {
+			head = ""
+		}
+		return build(repo, head, opts)
+	}
+}
+
+// printBuild prints what prepareBinary would do.
+func printBuild(repo, binary string, skip, auto bool) {
+	if skip {
+		fmt.Println("Would skip the build")
+		return
+	}
+	if auto {
+		needed, head, err := buildNeeded(repo, binary)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error detecting changes: %v\n", err)
+		}
+		if !needed {
+			fmt.Println("Would skip the build, cdk-erigon is up to date with", head)
+			return
+		}
+	}
+	fmt.Println("Would build:")
+	fmt.Printf("  cd %s && make cdk-erigon\n", shellQuote(repo))
+}
+
+// shellCommand formats a command line that can be pasted into a shell.
+func shellCommand(name string, args []string) string {
+	quoted := []string{shellQuote(name)}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:,@+%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// splitCommand splits a command line into its words like a shell would,
+// honoring single and double quotes and backslash escapes, but without any
+// expansion.
+func splitCommand(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return words, nil
+}
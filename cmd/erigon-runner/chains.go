@@ -0,0 +1,46 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// chainPresets are base erigon configs of the public chains, selected with
+// -chain instead of an erigon config from the repository.
+//
+//go:embed chains/*.yaml
+var chainPresets embed.FS
+
+// chainNames returns the names of the bundled presets.
+func chainNames() []string {
+	entries, _ := chainPresets.ReadDir("chains")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadErigonConfig reads the erigon config at path or, if chain is set, the
+// preset of that chain. Presets are placed in repo under a name of their own,
+// next to which the config copy with the rewritten ports is written; the path
+// used is returned along with the expanded content and its settings.
+func loadErigonConfig(repo, path, chain string, overrides yaml.MapSlice) (string, string, map[string]interface{}, error) {
+	if chain == "" {
+		content, settings, err := readErigonConfig(path, overrides)
+		return path, content, settings, err
+	}
+	content, err := chainPresets.ReadFile("chains/" + chain + ".yaml")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("unknown chain %s, want one of %s", chain, strings.Join(chainNames(), ", "))
+	}
+	path = filepath.Join(repo, "erigon-runner-"+chain+".yaml")
+	expanded, settings, err := parseErigonConfig(path, content, overrides)
+	return path, expanded, settings, err
+}
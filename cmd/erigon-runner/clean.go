@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// Names of the crash bundles collectCrash writes and of the directories
+// profiler.capture fills, either optionally prefixed with the node name.
+var (
+	crashBundleName = regexp.MustCompile(`^(.+-)?(crash|bundle)-\d{8}T\d{6}Z-\d+\.tar\.gz$`)
+	profileDirName  = regexp.MustCompile(`^(.+-)?\d{8}T\d{6}Z$`)
+)
+
+// runClean implements "erigon-runner clean": it removes what runs leave
+// behind, listing it instead with -dry-run. Restarts reread the config copies,
+// so it must not run while a runner uses the repository.
+func runClean(args []string) error {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	configFile := fs.String("config", "config.json", "Path to the configuration file, whose nodes and log files are cleaned up; optional")
+	erigonRepo := fs.String("repo", ".", "Path to the cdk-erigon repository")
+	erigonConfig := fs.String("erigon-config", "hermezconfig-bali.yaml", "Path to the erigon configuration file")
+	chain := fs.String("chain", "", "Bundled chain config used instead of -erigon-config")
+	datadir := fs.String("datadir", "", "Datadir of the node; defaults to datadir of the erigon config")
+	crashDir := fs.String("crash-dir", "crashes", "Directory of the crash bundles, relative to the directory of -config like the runner run from there; empty skips them")
+	pprofDir := fs.String("pprof-dir", "profiles", "Directory of the captured profiles, relative to the directory of -config like the runner run from there; empty skips them")
+	maxAge := fs.Duration("max-age", 7*24*time.Hour, "Age beyond which rotated log files, crash bundles and profiles are removed")
+	pruneTemp := fs.Bool("prune-temp", false, "Also empty the datadir's temp directory and remove partial snapshot and torrent downloads; only while the node is stopped")
+	pruneSnapshots := fs.Bool("prune-snapshots", false, "Also remove the datadir snapshots taken before updates and the datadirs set aside by rollbacks")
+	dryRun := fs.Bool("dry-run", false, "List what would be removed without removing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	nodes := []nodeConfig{{}}
+	var logFiles []string
+	if _, err := os.Stat(*configFile); err == nil {
+		runner, err := readRunnerConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		if len(runner.Nodes) > 0 {
+			nodes = runner.Nodes
+		}
+		config, err := alerting.ReadConfig(*configFile)
+		if err != nil {
+			return err
+		}
+		logFiles = configLogFiles(config)
+	}
+
+	var garbage []string
+	cutoff := time.Now().Add(-*maxAge)
+	for _, nc := range nodes {
+		configPath := filepath.Join(*erigonRepo, *erigonConfig)
+		nodeChain := *chain
+		if nc.ErigonConfig != "" {
+			configPath = filepath.Join(*erigonRepo, nc.ErigonConfig)
+			nodeChain = ""
+		}
+		if nc.Chain != "" {
+			nodeChain = nc.Chain
+		}
+		path, _, settings, configErr := loadErigonConfig(*erigonRepo, configPath, nodeChain, nil)
+		if path != "" {
+			garbage = append(garbage, tempConfigs(path)...)
+		}
+
+		dir := *datadir
+		if nc.Datadir != "" {
+			dir = nc.Datadir
+		}
+		if dir == "" && configErr == nil {
+			dir = configString(settings, "datadir")
+		}
+		if dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(*erigonRepo, dir)
+		}
+		if *pruneTemp {
+			garbage = append(garbage, datadirTemp(dir)...)
+		}
+		if *pruneSnapshots {
+			for _, pattern := range []string{snapshotPrefix(dir, "") + "*", dir + ".failed-*"} {
+				matches, _ := filepath.Glob(pattern)
+				garbage = append(garbage, matches...)
+			}
+		}
+	}
+	for _, logFile := range logFiles {
+		backups, _ := filepath.Glob(logFile + ".*")
+		garbage = append(garbage, olderThan(backups, cutoff)...)
+	}
+	// The runner saves them relative to its working directory, which holds
+	// its config.
+	for _, artifacts := range []struct {
+		dir  string
+		name *regexp.Regexp
+	}{{*crashDir, crashBundleName}, {*pprofDir, profileDirName}} {
+		if artifacts.dir == "" {
+			continue
+		}
+		dir := artifacts.dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(*configFile), dir)
+		}
+		entries, _ := os.ReadDir(dir)
+		var paths []string
+		for _, entry := range entries {
+			if artifacts.name.MatchString(entry.Name()) {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+		garbage = append(garbage, olderThan(paths, cutoff)...)
+	}
+	garbage = unique(garbage)
+
+	if len(garbage) == 0 {
+		fmt.Println("Nothing to clean up")
+		return nil
+	}
+	var errs []error
+	for _, path := range garbage {
+		if *dryRun {
+			fmt.Println("Would remove", path)
+			continue
+		}
+		fmt.Println("Removing", path)
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tempConfigs returns the copies updateConfig wrote of configPath.
+func tempConfigs(configPath string) []string {
+	ext := filepath.Ext(configPath)
+	base := configPath[:len(configPath)-len(ext)]
+	var copies []string
+	for _, pattern := range []string{base + "_new" + ext, base + "_*_new" + ext} {
+		matches, _ := filepath.Glob(pattern)
+		copies = append(copies, matches...)
+	}
+	return copies
+}
+
+// datadirTemp returns erigon's scratch files in datadir: the contents of its
+// temp directory and partial downloads of segment files and torrents.
+func datadirTemp(datadir string) []string {
+	temp, _ := filepath.Glob(filepath.Join(datadir, "temp", "*"))
+	for _, dir := range []string{"snapshots", "downloader"} {
+		filepath.WalkDir(filepath.Join(datadir, dir), func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && (strings.HasSuffix(path, ".tmp") || strings.HasSuffix(path, ".part")) {
+				temp = append(temp, path)
+			}
+			return nil
+		})
+	}
+	return temp
+}
+
+// configLogFiles returns the log and output files of every service in config.
+func configLogFiles(config *alerting.Config) []string {
+	configs := []alerting.Config{*config}
+	for _, sc := range config.Services {
+		configs = append(configs, sc.Config)
+	}
+	var files []string
+	for _, c := range configs {
+		if c.LogFile != "" {
+			files = append(files, c.LogFile)
+		}
+		for _, pc := range c.Patterns {
+			if pc.OutputFile != "" {
+				files = append(files, pc.OutputFile)
+			}
+		}
+	}
+	return files
+}
+
+func olderThan(paths []string, cutoff time.Time) []string {
+	var old []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.ModTime().Before(cutoff) {
+			old = append(old, path)
+		}
+	}
+	return old
+}
+
+func unique(paths []string) []string {
+	sort.Strings(paths)
+	var out []string
+	for i, path := range paths {
+		if i == 0 || path != paths[i-1] {
+			out = append(out, path)
+		}
+	}
+	return out
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// ANSI escape sequences of the console colors.
+const (
+	colorReset = "\x1b[0m"
+	colorDim   = "\x1b[2m"
+	colorRed   = "\x1b[31m"
+	colorBold  = "\x1b[1m"
+	// colorAlert highlights lines that matched an alert pattern.
+	colorAlert = "\x1b[1;7m"
+)
+
+var levelColors = map[alerting.Level]string{
+	alerting.LevelTrace: colorDim,
+	alerting.LevelDebug: colorDim,
+	alerting.LevelWarn:  "\x1b[33m",
+	alerting.LevelError: colorRed,
+	alerting.LevelCrit:  colorBold + colorRed,
+}
+
+// useColor decides whether output to f is colored: always, never or, with
+// auto, when f is a terminal and NO_COLOR isn't set.
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if _, ok := os.LookupEnv("NO_COLOR"); ok {
+			return false, nil
+		}
+		info, err := f.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid color mode %q, want auto, always or never", mode)
+}
+
+// colorize colors line by its level, highlighting it if it matched an alert
+// pattern.
+func colorize(line string, matched bool) string {
+	color := levelColors[alerting.ExtractLevel(line)]
+	if matched {
+		color += colorAlert
+	}
+	if color == "" {
+		return line
+	}
+	return color + line + colorReset
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// oomLine matches the kernel log lines of the OOM killer.
+var oomLine = regexp.MustCompile(`(?i)out of memory|oom-kill|killed process`)
+
+// maxOOMLines caps the kernel log lines kept as OOM evidence.
+const maxOOMLines = 50
+
+// crashReport is what is known about an exit of the child.
+type crashReport struct {
+	name     string
+	exit     error
+	pid      int
+	started  time.Time
+	exited   time.Time
+	binary   string
+	args     []string
+	logLines []string
+	// redact, set when the bundle is uploaded, leaves the secrets and URLs
+	// out of the config and command like the issue reports do.
+	redact bool
+}
+
+type bundleFile struct {
+	name    string
+	content []byte
+}
+
+// collectCrash bundles the report, the kernel's OOM evidence and the erigon
+// config of the run into a tar.gz in dir, and returns its path and a summary
+// for the crash alert. The bundle is only readable by the owner as the config may hold
+// secrets. A report without exit is of a running child, bundled on demand.
+func collectCrash(dir string, report crashReport) (string, string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	kind := "crash"
+	if report.exit == nil {
+		kind = "bundle"
+	}
+	// The PID tells apart crashes within the same second.
+	name := fmt.Sprintf("%s-%s-%d.tar.gz", kind, report.exited.UTC().Format("20060102T150405Z"), report.pid)
+	if report.name != "" {
+		name = report.name + "-" + name
+	}
+	path := filepath.Join(dir, name)
+
+	var exit interface{} = "still running"
+	if report.exit != nil {
+		exit = report.exit
+	}
+	command := shellCommand(report.binary, report.args)
+	if report.redact {
+		command = urlValue.ReplaceAllStringFunc(command, redactURL)
+	}
+	files := []bundleFile{
+		{"exit.txt", []byte(fmt.Sprintf("Exit: %v\nPID: %d\nStarted: %s\nExited: %s\nCommand: %s\n",
+			exit, report.pid, report.started.Format(time.RFC3339), report.exited.Format(time.RFC3339), command))},
+		{"log.txt", []byte(strings.Join(report.logLines, "\n") + "\n")},
+	}
+	oom := oomEvidence(report.pid)
+	if len(oom) > 0 {
+		files = append(files, bundleFile{"dmesg.txt", []byte(strings.Join(oom, "\n") + "\n")})
+	}
+	for _, arg := range report.args {
+		configFile := strings.TrimPrefix(arg, "--config=")
+		if configFile == arg {
+			continue
+		}
+		if content, err := os.ReadFile(configFile); err == nil {
+			if report.redact {
+				content = []byte(redactConfig(string(content)))
+			}
+			files = append(files, bundleFile{filepath.Base(configFile), content})
+		}
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", "", err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		header := &tar.Header{Name: f.name, Mode: 0600, Size: int64(len(f.content)), ModTime: report.exited}
+		if err = tw.WriteHeader(header); err == nil {
+			_, err = tw.Write(f.content)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", "", fmt.Errorf("failed to write crash bundle: %w", err)
+	}
+
+	summary := "Crash bundle: " + path
+	if report.exit == nil {
+		summary = "Bundle: " + path
+	}
+	if len(oom) > 0 {
+		summary += "\nOOM killer: " + oom[len(oom)-1]
+	}
+	return path, summary, nil
+}
+
+// collectBundle bundles the running child's artifacts like a crash and
+// uploads them if configured, returning the bundle's path and URL.
+func (s *supervisor) collectBundle() (string, string, error) {
+	if s.crashDir == "" {
+		return "", "", fmt.Errorf("bundles are disabled, set -crash-dir")
+	}
+	status := s.Status()
+	report := crashReport{
+		name:     s.name,
+		pid:      status.PID,
+		exited:   time.Now(),
+		binary:   s.binary,
+		args:     s.args,
+		logLines: s.tail.Lines(s.crashLogLines),
+		redact:   s.crashUpload != nil,
+	}
+	if status.Started != nil {
+		report.started = *status.Started
+	}
+	path, _, err := collectCrash(s.crashDir, report)
+	if err != nil {
+		return "", "", err
+	}
+	url, err := s.uploadBundle(path)
+	return path, url, err
+}
+
+// uploadBundle uploads the bundle at path if a bucket is configured and
+// returns its URL.
+func (s *supervisor) uploadBundle(path string) (string, error) {
+	if s.crashUpload == nil {
+		return "", nil
+	}
+	return s.crashUpload.upload(path)
+}
+
+// oomEvidence returns the kernel log lines of the OOM killer naming pid. It
+// returns nil when the kernel log can't be read.
+func oomEvidence(pid int) []string {
+	output, err := exec.Command("dmesg").Output()
+	if err != nil {
+		return nil
+	}
+	pidMention := regexp.MustCompile(fmt.Sprintf(`\b%d\b`, pid))
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if oomLine.MatchString(line) && pidMention.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > maxOOMLines {
+		lines = lines[len(lines)-maxOOMLines:]
+	}
+	return lines
+}
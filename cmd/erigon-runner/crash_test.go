@@ -0,0 +1,67 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectCrashRedactsUploads(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "erigon.yaml")
+	config := "datadir: /data\nzkevm.l1-rpc-url: https://l1.example/v2/abc123key\nzkevm.pool-manager-secret: hunter2\n"
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, redact := range []bool{false, true} {
+		path, _, err := collectCrash(filepath.Join(dir, "crashes"), crashReport{
+			pid:    i + 1,
+			exited: time.Now(),
+			binary: "cdk-erigon",
+			args:   []string{"--config=" + configPath, "--zkevm.l1-rpc-url=https://l1.example/v2/abc123key"},
+			redact: redact,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle := readBundle(t, path)
+		leaked := strings.Contains(bundle, "abc123key") || strings.Contains(bundle, "hunter2")
+		if leaked == redact {
+			t.Errorf("redact %v: bundle leaks secrets %v:\n%s", redact, leaked, bundle)
+		}
+		if !strings.Contains(bundle, "datadir: /data") {
+			t.Errorf("redact %v: bundle lacks the config:\n%s", redact, bundle)
+		}
+	}
+}
+
+// readBundle returns the concatenated files of the crash bundle at path.
+func readBundle(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var b strings.Builder
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(&b, tr)
+	}
+	return b.String()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// datastreamURLKey is the cdk-erigon config key of the L2 datastream endpoint.
+const datastreamURLKey = "zkevm.l2-datastreamer-url"
+
+var (
+	backoffRegex  = regexp.MustCompile(`(?i)backoff[=: ]+(\d+(?:\.\d+)?)(ms|s|m|h)?\b`)
+	durationRegex = regexp.MustCompile(`\b\d+(?:\.\d+)?(?:ms|s|m|h)\b`)
+)
+
+// datastreamWatcher checks that the datastream endpoint is reachable and
+// alerts when the node reconnects to it repeatedly.
+type datastreamWatcher struct {
+	addr     string
+	pipeline *alerting.Pipeline
+	timeout  time.Duration
+
+	// More than maxReconnects log lines matching reconnect within window
+	// alert; 0 disables the check.
+	reconnect     *regexp.Regexp
+	maxReconnects int
+	window        time.Duration
+
+	unreachable bool
+	reconnects  []time.Time
+	backoffs    []time.Duration
+	flapping    bool
+	mu          sync.Mutex
+}
+
+// datastreamAddr turns the configured datastream URL into a dialable
+// host:port.
+func datastreamAddr(url string) string {
+	if i := strings.Index(url, "://"); i >= 0 {
+		url = url[i+3:]
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// check dials the endpoint and alerts when it became unreachable, and again
+// once it is reachable.
+func (d *datastreamWatcher) check() error {
+	conn, err := net.DialTimeout("tcp", d.addr, d.timeout)
+	if err == nil {
+		conn.Close()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case err != nil && !d.unreachable:
+		d.unreachable = true
+		message := fmt.Sprintf("Datastream at %s is unreachable: %v", d.addr, err)
+		fmt.Fprintln(os.Stderr, message)
+		d.pipeline.Event("datastream-unreachable", "CRITICAL", message)
+	case err == nil && d.unreachable:
+		d.unreachable = false
+		message := fmt.Sprintf("Datastream at %s is reachable again", d.addr)
+		fmt.Fprintln(os.Stderr, message)
+		d.pipeline.Event("datastream-reachable", "INFO", message)
+	}
+	return err
+}
+
+func (d *datastreamWatcher) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			d.check()
+		}
+	}
+}
+
+// observe counts the reconnects logged by the node.
+func (d *datastreamWatcher) observe(line string) {
+	if d.maxReconnects <= 0 || !d.reconnect.MatchString(line) {
+		return
+	}
+	backoff, hasBackoff := parseBackoff(line)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for len(d.reconnects) > 0 && now.Sub(d.reconnects[0]) > d.window {
+		d.reconnects = d.reconnects[1:]
+	}
+	if len(d.reconnects) == 0 {
+		// A quiet window ends the previous episode.
+		d.flapping = false
+		d.backoffs = nil
+	}
+	d.reconnects = append(d.reconnects, now)
+	if hasBackoff {
+		d.backoffs = append(d.backoffs, backoff)
+	}
+	if d.flapping || len(d.reconnects) <= d.maxReconnects {
+		return
+	}
+
+	d.flapping = true
+	message := fmt.Sprintf("Datastream reconnected %d times within %s", len(d.reconnects), d.window)
+	if len(d.backoffs) > 0 {
+		max := d.backoffs[0]
+		for _, b := range d.backoffs {
+			if b > max {
+				max = b
+			}
+		}
+		message += fmt.Sprintf(", backoff now %s (max %s)", d.backoffs[len(d.backoffs)-1], max)
+	}
+	message += "\nLast reconnect: " + line
+	fmt.Fprintln(os.Stderr, message)
+	d.pipeline.Event("datastream-reconnects", "WARNING", message)
+}
+
+// parseBackoff extracts the retry delay from a reconnect log line, preferring
+// an explicit backoff field over the first duration in the line. Plain numbers
+// are seconds.
+func parseBackoff(line string) (time.Duration, bool) {
+	if m := backoffRegex.FindStringSubmatch(line); m != nil {
+		unit := m[2]
+		if unit == "" {
+			unit = "s"
+		}
+		if d, err := time.ParseDuration(m[1] + unit); err == nil {
+			return d, true
+		}
+	}
+	if m := durationRegex.FindString(line); m != "" {
+		if d, err := time.ParseDuration(m); err == nil {
+			return d, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// differ compares nodes running different revisions or configs, e.g. a
+// release candidate and the current release, with the first node: how far
+// their heads are apart, and the responses of calls at the highest block
+// both have.
+type differ struct {
+	nodes    []*node
+	calls    []string
+	maxLag   uint64
+	interval time.Duration
+	stop     <-chan struct{}
+
+	// diverged holds the node|call pairs that differ, lagging the nodes
+	// whose head is too far apart.
+	diverged map[string]bool
+	lagging  map[string]bool
+}
+
+func newDiffer(nodes []*node, calls []string, maxLag uint64, interval time.Duration, stop <-chan struct{}) *differ {
+	return &differ{
+		nodes:    nodes,
+		calls:    calls,
+		maxLag:   maxLag,
+		interval: interval,
+		stop:     stop,
+		diverged: make(map[string]bool),
+		lagging:  make(map[string]bool),
+	}
+}
+
+func (d *differ) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.check()
+		}
+	}
+}
+
+func (d *differ) check() {
+	base := d.nodes[0]
+	baseHead, err := base.rpc.callUint64("eth_blockNumber")
+	if err != nil {
+		return
+	}
+	for _, n := range d.nodes[1:] {
+		head, err := n.rpc.callUint64("eth_blockNumber")
+		if err != nil {
+			continue
+		}
+		d.checkLag(base, n, baseHead, head)
+		height := head
+		if baseHead < height {
+			height = baseHead
+		}
+		if height == 0 {
+			continue
+		}
+		for _, call := range d.calls {
+			d.compare(base, n, call, height)
+		}
+	}
+}
+
+func (d *differ) checkLag(base, n *node, baseHead, head uint64) {
+	if d.maxLag == 0 {
+		return
+	}
+	apart, behind, ahead, at := baseHead-head, n.name, base.name, head
+	if head > baseHead {
+		apart, behind, ahead, at = head-baseHead, base.name, n.name, baseHead
+	}
+	switch {
+	case apart > d.maxLag && !d.lagging[n.name]:
+		d.lagging[n.name] = true
+		message := fmt.Sprintf("Node %s is %d blocks behind node %s, at %d", behind, apart, ahead, at)
+		fmt.Fprintln(os.Stderr, message)
+		n.pipeline.Event("node-lag", "WARNING", message)
+	case apart <= d.maxLag && d.lagging[n.name]:
+		d.lagging[n.name] = false
+		message := fmt.Sprintf("Nodes %s and %s are within %d blocks again, at %d and %d", base.name, n.name, d.maxLag, baseHead, head)
+		fmt.Fprintln(os.Stderr, message)
+		n.pipeline.Event("node-lag-resolved", "INFO", message)
+	}
+}
+
+// compare calls method at height on both nodes and alerts when the responses
+// differ, and again once they match.
+func (d *differ) compare(base, n *node, method string, height uint64) {
+	want, err := callAt(base.rpc, method, height)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError comparing %s: %v\n", nodePrefix(base.name), method, err)
+		return
+	}
+	got, err := callAt(n.rpc, method, height)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError comparing %s: %v\n", nodePrefix(n.name), method, err)
+		return
+	}
+	if want == nil || got == nil {
+		return
+	}
+
+	key := n.name + "|" + method
+	differs := diffPaths(want, got, "")
+	switch {
+	case len(differs) > 0 && !d.diverged[key]:
+		d.diverged[key] = true
+		if len(differs) > 10 {
+			differs = append(differs[:10], "...")
+		}
+		message := fmt.Sprintf("Node %s differs from node %s in %s at block %d: %s", n.name, base.name, method, height, strings.Join(differs, ", "))
+		fmt.Fprintln(os.Stderr, message)
+		n.pipeline.Event("node-divergence", "CRITICAL", message)
+	case len(differs) == 0 && d.diverged[key]:
+		d.diverged[key] = false
+		message := fmt.Sprintf("Node %s matches node %s in %s again at block %d", n.name, base.name, method, height)
+		fmt.Fprintln(os.Stderr, message)
+		n.pipeline.Event("node-divergence-resolved", "INFO", message)
+	}
+}
+
+// callAt calls method with height as its block parameter and returns the
+// decoded result, nil if the node doesn't have the block.
+func callAt(c *rpcClient, method string, height uint64) (interface{}, error) {
+	params := []interface{}{fmt.Sprintf("0x%x", height)}
+	if method == "eth_getBlockByNumber" {
+		params = append(params, false)
+	}
+	var raw json.RawMessage
+	if err := c.call(method, &raw, params...); err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("%s returned an unexpected result: %w", method, err)
+	}
+	return result, nil
+}
+
+// diffPaths returns the paths, like "hash" or "[3].logs", at which the decoded
+// JSON values a and b differ.
+func diffPaths(a, b interface{}, path string) []string {
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool, len(a)+len(b))
+		for key := range a {
+			keys[key] = true
+		}
+		for key := range b {
+			keys[key] = true
+		}
+		var paths []string
+		for key := range keys {
+			sub := key
+			if path != "" {
+				sub = path + "." + key
+			}
+			paths = append(paths, diffPaths(a[key], b[key], sub)...)
+		}
+		sort.Strings(paths)
+		return paths
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			break
+		}
+		var paths []string
+		for i := range a {
+			paths = append(paths, diffPaths(a[i], b[i], fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return paths
+	}
+	if path == "" {
+		return []string{"result"}
+	}
+	return []string{path}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+const gb = 1 << 30
+
+// existingDir returns path or its closest existing parent, so the volume of a
+// datadir can be checked before the node created it.
+func existingDir(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// diskMonitor alerts when free space on the datadir volume falls below the
+// warning or critical threshold, and again once it recovered.
+type diskMonitor struct {
+	path       string
+	pipeline   *alerting.Pipeline
+	supervisor *supervisor
+	interval   time.Duration
+	warning    uint64
+	critical   uint64
+
+	level string
+}
+
+func (d *diskMonitor) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		d.check()
+		select {
+		case <-d.supervisor.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *diskMonitor) check() {
+	free, err := freeDiskSpace(existingDir(d.path))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking free disk space: %v\n", err)
+		return
+	}
+	d.supervisor.setStatus(func(st *childStatus) { st.DiskFree = free })
+
+	level := "ok"
+	switch {
+	case d.critical > 0 && free < d.critical:
+		level = "critical"
+	case d.warning > 0 && free < d.warning:
+		level = "warning"
+	}
+	if level == d.level {
+		return
+	}
+	previous := d.level
+	d.level = level
+
+	message := fmt.Sprintf("%.1f GB free on the volume of %s", float64(free)/gb, d.path)
+	switch {
+	case level == "critical":
+		message += fmt.Sprintf(", below the critical threshold of %.1f GB", float64(d.critical)/gb)
+		d.pipeline.Event("disk-space", "CRITICAL", message)
+	case level == "warning" && previous != "critical":
+		message += fmt.Sprintf(", below the warning threshold of %.1f GB", float64(d.warning)/gb)
+		d.pipeline.Event("disk-space", "WARNING", message)
+	case level == "ok" && previous != "":
+		message += ", back above the thresholds"
+		d.pipeline.Event("disk-space-resolved", "INFO", message)
+	default:
+		return
+	}
+	fmt.Fprintln(os.Stderr, message)
+}
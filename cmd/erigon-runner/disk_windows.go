@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("disk space checks are not supported on Windows")
+}
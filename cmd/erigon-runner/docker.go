@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Paths the datadir and the generated config are mounted at in the container.
+const (
+	containerDatadir   = "/datadir"
+	containerConfigDir = "/etc/cdk-erigon"
+)
+
+// listenAddrKeys are the settings holding the address the server of a
+// configured port listens on. Inside the container it has to listen on all
+// interfaces for the published port to reach it, so the configured address,
+// loopback by default, becomes the host address the port is published on.
+var listenAddrKeys = map[string]string{
+	"http.port":    "http.addr",
+	"ws.port":      "http.addr",
+	"authrpc.port": "authrpc.addr",
+	"metrics.port": "metrics.addr",
+	"pprof.port":   "pprof.addr",
+}
+
+// p2pPortKeys are published over UDP as well, for discovery.
+var p2pPortKeys = map[string]bool{
+	"port":              true,
+	"p2p.allowed-ports": true,
+	"torrent.port":      true,
+}
+
+var containerNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// containerName derives the container of a node from its datadir, which no
+// two nodes on a host share.
+func containerName(datadir string) string {
+	return "erigon-runner-" + containerNameInvalid.ReplaceAllString(filepath.Base(datadir), "-")
+}
+
+// dockerArgs returns the docker arguments running image as container with
+// the datadir and the generated config mounted and the rewritten ports
+// published on the same port numbers. erigonArgs are passed to cdk-erigon
+// after the config and datadir flags.
+func dockerArgs(image, container, configFile, datadir string, ports map[string][]int, settings map[string]interface{}, erigonArgs []string) ([]string, error) {
+	absConfig, err := filepath.Abs(configFile)
+	if err != nil {
+		return nil, err
+	}
+	absDatadir, err := filepath.Abs(datadir)
+	if err != nil {
+		return nil, err
+	}
+	mountedConfig := containerConfigDir + "/" + filepath.Base(configFile)
+
+	args := []string{"run", "--rm", "--name", container,
+		"-v", absDatadir + ":" + containerDatadir,
+		"-v", absConfig + ":" + mountedConfig + ":ro",
+	}
+	// Files in the datadir keep belonging to the runner's user.
+	if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	var overrides []string
+	published := make(map[string]bool)
+	for _, key := range portKeys {
+		hostIP := ""
+		if addrKey, ok := listenAddrKeys[key]; ok && len(ports[key]) > 0 {
+			hostIP = configString(settings, addrKey)
+			if hostIP == "" || hostIP == "localhost" {
+				hostIP = "127.0.0.1"
+			}
+			if hostIP == "0.0.0.0" {
+				hostIP = ""
+			}
+			overrides = append(overrides, "--"+addrKey+"=0.0.0.0")
+		}
+		for _, port := range ports[key] {
+			mapping := strconv.Itoa(port) + ":" + strconv.Itoa(port)
+			if hostIP != "" {
+				mapping = hostIP + ":" + mapping
+			}
+			mappings := []string{mapping}
+			if p2pPortKeys[key] {
+				mappings = append(mappings, mapping+"/udp")
+			}
+			for _, m := range mappings {
+				if !published[m] {
+					published[m] = true
+					args = append(args, "-p", m)
+				}
+			}
+		}
+	}
+	args = append(args, image, "--config="+mountedConfig, "--datadir="+containerDatadir)
+	args = append(args, unique(overrides)...)
+	return append(args, erigonArgs...), nil
+}
+
+// removeContainer force-removes container, which is left behind when the
+// docker client running it is killed.
+func removeContainer(container string) {
+	exec.Command("docker", "rm", "-f", container).Run()
+}
@@ -2,4 +2,15 @@ module github.com/revitteth/scripts/cmd/erigon-runner/v2
 
 go 1.20
 
-require gopkg.in/yaml.v2 v2.4.0 // indirect
+require (
+	github.com/revitteth/scripts/internal v0.0.0-00010101000000-000000000000
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/redis/go-redis/v9 v9.7.3 // indirect
+)
+
+replace github.com/revitteth/scripts/internal => ../../internal
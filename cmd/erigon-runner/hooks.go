@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hook events. Failing pre hooks fail the step they precede; failures of the
+// others are only reported.
+const (
+	hookPreBuild  = "preBuild"
+	hookPostBuild = "postBuild"
+	hookPreStart  = "preStart"
+	hookPostStop  = "postStop"
+	hookOnCrash   = "onCrash"
+)
+
+var hookEvents = []string{hookPreBuild, hookPostBuild, hookPreStart, hookPostStop, hookOnCrash}
+
+const defaultHookTimeout = 10 * time.Minute
+
+// hookConfig is a command run on a lifecycle event of cdk-erigon.
+type hookConfig struct {
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+}
+
+// hookSet maps events to their hooks.
+type hookSet map[string]hookConfig
+
+func (h hookSet) validate() error {
+	for event, hook := range h {
+		known := false
+		for _, e := range hookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return fmt.Errorf("unknown hook %s, want one of %s", event, strings.Join(hookEvents, ", "))
+		}
+		if len(hook.Command) == 0 {
+			return fmt.Errorf("hook %s has no command", event)
+		}
+	}
+	return nil
+}
+
+// merge returns h with the hooks of override replacing those of the same
+// event.
+func (h hookSet) merge(override hookSet) hookSet {
+	merged := make(hookSet, len(h)+len(override))
+	for event, hook := range h {
+		merged[event] = hook
+	}
+	for event, hook := range override {
+		merged[event] = hook
+	}
+	return merged
+}
+
+// run runs the hook of event, if any, with env, ERIGON_RUNNER_ variables
+// without the prefix, added to its environment. Its output goes to the
+// runner's.
+func (h hookSet) run(event string, env map[string]string) error {
+	hook, ok := h[event]
+	if !ok {
+		return nil
+	}
+	timeout := defaultHookTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "ERIGON_RUNNER_HOOK="+event)
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, "ERIGON_RUNNER_"+key+"="+env[key])
+	}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s hook timed out after %s", event, timeout)
+		}
+		return fmt.Errorf("%s hook failed: %w", event, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// jwtSecretKey is the setting of the file with the secret authenticating the
+// engine API.
+const jwtSecretKey = "authrpc.jwtsecret"
+
+// prepareJWTSecret generates the JWT secret at path unless it exists, as 32
+// random bytes in hex like erigon writes them, readable by the owner only.
+func prepareJWTSecret(path string, dryRun bool) error {
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		fmt.Println("Using the JWT secret", path)
+		return nil
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("failed to check JWT secret: %w", err)
+	case dryRun:
+		fmt.Println("Would generate the JWT secret", path)
+		return nil
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate JWT secret: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory of JWT secret: %w", err)
+	}
+	// O_EXCL keeps a secret another node wrote meanwhile.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write JWT secret: %w", err)
+	}
+	if _, err := f.WriteString("0x" + hex.EncodeToString(secret)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write JWT secret: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write JWT secret: %w", err)
+	}
+	fmt.Println("Generated the JWT secret", path)
+	return nil
+}
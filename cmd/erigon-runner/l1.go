@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The settings of the L1 endpoint cdk-erigon syncs from and its chain ID.
+const (
+	l1RPCKey     = "zkevm.l1-rpc-url"
+	l1ChainIDKey = "zkevm.l1-chain-id"
+)
+
+// checkL1 verifies that the L1 endpoint responds and serves the chain want,
+// if not 0, so a dead or wrong endpoint fails the start rather than the sync.
+func checkL1(endpoint string, want uint64) error {
+	c := &rpcClient{url: endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+	got, err := c.callUint64("eth_chainId")
+	// The error of a failed request has the whole URL.
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = fmt.Errorf("eth_chainId failed: %w", urlErr.Err)
+	}
+	if err != nil {
+		return fmt.Errorf("L1 RPC %s is unreachable: %w", redactURL(endpoint), err)
+	}
+	if want != 0 && got != want {
+		return fmt.Errorf("L1 RPC %s serves chain %d, but %s is %d", redactURL(endpoint), got, l1ChainIDKey, want)
+	}
+	fmt.Printf("L1 RPC %s reachable, chain %d\n", redactURL(endpoint), got)
+	return nil
+}
+
+// redactURL drops everything but the scheme and host of a URL, as providers
+// put API keys in the path or query.
+func redactURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return "(invalid URL)"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// rlimitNproc is RLIMIT_NPROC, which the syscall package doesn't define.
+const rlimitNproc = 6
+
+// kernelSettings are sysctls whose common defaults are too low for erigon,
+// which maps every snapshot segment, with their recommended minimums.
+var kernelSettings = []struct {
+	name string
+	min  uint64
+}{
+	{"vm.max_map_count", 262144},
+	{"fs.file-max", 262144},
+}
+
+// preflightLimits raises the soft limits of open files and processes that
+// cdk-erigon inherits to minFiles and minProcs, as far as the hard limits
+// allow, and returns warnings about the limits and kernel settings that stay
+// below the recommended values. A minimum of 0 skips its limit.
+func preflightLimits(minFiles, minProcs uint64) []string {
+	var warnings []string
+	if minFiles > 0 {
+		if warning := raiseLimit(syscall.RLIMIT_NOFILE, "open files", "ulimit -n or LimitNOFILE", minFiles); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	if minProcs > 0 {
+		if warning := raiseLimit(rlimitNproc, "processes", "ulimit -u or LimitNPROC", minProcs); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+	for _, setting := range kernelSettings {
+		path := "/proc/sys/" + strings.ReplaceAll(setting.name, ".", "/")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err == nil && value < setting.min {
+			warnings = append(warnings, fmt.Sprintf("%s is %d, erigon needs at least %d; raise it with sysctl -w %s=%d", setting.name, value, setting.min, setting.name, setting.min))
+		}
+	}
+	return warnings
+}
+
+func raiseLimit(resource int, name, how string, min uint64) string {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &limit); err != nil {
+		return fmt.Sprintf("failed to read the %s limit: %v", name, err)
+	}
+	if limit.Cur >= min {
+		return ""
+	}
+	target := min
+	if limit.Max < target {
+		target = limit.Max
+	}
+	if target > limit.Cur {
+		raised := syscall.Rlimit{Cur: target, Max: limit.Max}
+		if err := syscall.Setrlimit(resource, &raised); err != nil {
+			return fmt.Sprintf("failed to raise the %s limit from %d to %d: %v", name, limit.Cur, target, err)
+		}
+		fmt.Printf("Raised the %s limit from %d to %d\n", name, limit.Cur, target)
+		limit.Cur = target
+	}
+	if limit.Cur < min {
+		return fmt.Sprintf("The %s limit is %d, capped by the hard limit, erigon needs at least %d; raise it with %s", name, limit.Cur, min, how)
+	}
+	return ""
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// preflightLimits is only implemented on Linux.
+func preflightLimits(minFiles, minProcs uint64) []string {
+	return nil
+}
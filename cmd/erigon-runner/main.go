@@ -1,339 +1,941 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/revitteth/scripts/internal/alerting"
 	"gopkg.in/yaml.v2"
 )
 
-// Alerting configuration and logic
+// defaultHTTPPort is erigon's HTTP RPC port when http.port isn't configured.
+const defaultHTTPPort = "8545"
 
-const LogLevelThreshold = "ERROR"
-
-type PatternConfig struct {
-	Pattern        string `json:"pattern"`
-	TimeoutMinutes int    `json:"timeoutMinutes"`
-}
-
-type Config struct {
-	WebhookURL            string          `json:"webhookURL"`
-	Patterns              []PatternConfig `json:"patterns"`
-	LogFile               string          `json:"logFile"`
-	AlertCooldownMinutes  int             `json:"alertCooldownMinutes"`
-	DefaultTimeoutMinutes int             `json:"defaultTimeoutMinutes"`
+// readErigonConfig reads a cdk-erigon config file with environment variables
+// expanded and the overrides, if any, merged in. It returns the resulting
+// content along with its settings.
+func readErigonConfig(path string, overrides yaml.MapSlice) (string, map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return parseErigonConfig(path, content, overrides)
 }
 
-type AlertManager struct {
-	sentAlerts        map[string]time.Time
-	suppressionCounts map[string]int
-	mu                sync.Mutex
-	defaultCooldown   time.Duration
-	patternCooldowns  map[string]time.Duration
+// parseErigonConfig is readErigonConfig for the content of path.
+func parseErigonConfig(path string, content []byte, overrides yaml.MapSlice) (string, map[string]interface{}, error) {
+	expanded, err := expandEnv(string(content))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	if len(overrides) > 0 && isTOML(path) {
+		return "", nil, fmt.Errorf("overrides can't be applied to the TOML config %s", path)
+	}
+	if len(overrides) > 0 {
+		if expanded, err = mergeOverrides(expanded, overrides); err != nil {
+			return "", nil, fmt.Errorf("failed to apply overrides to %s: %w", path, err)
+		}
+	}
+	config, err := unmarshalConfig(path, expanded)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return expanded, config, nil
 }
 
-func NewAlertManager(defaultCooldown time.Duration, patternCooldowns map[string]time.Duration) *AlertManager {
-	return &AlertManager{
-		sentAlerts:        make(map[string]time.Time),
-		suppressionCounts: make(map[string]int),
-		defaultCooldown:   defaultCooldown,
-		patternCooldowns:  patternCooldowns,
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references with the environment variable, so
+// secrets don't have to be stored in the config file. Unset variables are an
+// error rather than silently empty.
+func expandEnv(content string) (string, error) {
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(content, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
 	}
+	return expanded, nil
 }
 
-func (am *AlertManager) ShouldSendAlert(pattern string) (bool, int) {
-	am.mu.Lock()
-	defer am.mu.Unlock()
-
-	now := time.Now()
-	key := pattern
-
-	cooldown, exists := am.patternCooldowns[pattern]
-	if !exists {
-		cooldown = am.defaultCooldown
+// configString returns the value of key in config as a string.
+func configString(config map[string]interface{}, key string) string {
+	switch v := config[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return fmt.Sprint(v)
 	}
+}
 
-	if lastSent, exists := am.sentAlerts[key]; exists {
-		if now.Sub(lastSent) < cooldown {
-			am.suppressionCounts[key]++
-			return false, am.suppressionCounts[key]
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "clean" {
+		if err := runClean(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error cleaning up: %v\n", err)
+			os.Exit(1)
 		}
+		return
+	}
+	// install-service takes the flags of a run, which the unit runs with.
+	install := len(os.Args) > 1 && os.Args[1] == "install-service"
+	args := os.Args[1:]
+	if install {
+		args = os.Args[2:]
+	}
+	if err := run(args, install); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-
-	suppressionCount := am.suppressionCounts[key]
-	am.sentAlerts[key] = now
-	am.suppressionCounts[key] = 0
-	return true, suppressionCount
 }
 
-func (am *AlertManager) GetSuppressionCount(pattern string) int {
-	am.mu.Lock()
-	defer am.mu.Unlock()
-	return am.suppressionCounts[pattern]
-}
+func run(args []string, install bool) error {
+	// Command-line arguments
+	configFile := flag.String("config", "config.json", "Path to the configuration file")
+	msgPrefix := flag.String("msg", "", "Chat message prefix")
+	erigonRepo := flag.String("repo", ".", "Path to the cdk-erigon repository")
+	erigonConfig := flag.String("erigon-config", "hermezconfig-bali.yaml", "Path to the erigon configuration file, YAML or, by its .toml extension, TOML")
+	chain := flag.String("chain", "", "Use the bundled base config of a chain instead of -erigon-config: "+strings.Join(chainNames(), ", "))
+	portOffset := flag.Int("port-offset", 0, "Shift every port of the erigon config by this amount instead of scanning for free ones; the n-th node is shifted n times as far")
+	portRange := flag.String("port-range", "", "Range LOW-HIGH the rewritten ports must lie in, e.g. 30000-40000")
+	override := flag.String("override", "", "YAML file merged onto the erigon config, e.g. to change the log level or pruning per environment; YAML configs only")
+	ref := flag.String("ref", "", "Branch, tag or commit of cdk-erigon to fetch and check out before building")
+	force := flag.Bool("force", false, "Discard uncommitted changes in the repository when checking out -ref")
+	autoUpdate := flag.Duration("auto-update", 0, "Interval between checks for new commits on -update-branch, which are built and restarted into; 0 disables auto-update")
+	updateBranch := flag.String("update-branch", "", "Upstream branch to follow with -auto-update; defaults to -ref or the checked out branch")
+	updateVerify := flag.Duration("update-verify", 5*time.Minute, "How long an updated cdk-erigon has to run healthily before the update is kept")
+	snapshotBeforeUpdate := flag.Bool("snapshot-before-update", false, "Snapshot the datadir while cdk-erigon is stopped for an -auto-update, and restore it if the update is rolled back; segment files are hardlinked, the databases copied")
+	snapshotDir := flag.String("snapshot-dir", "", "Directory datadir snapshots are kept in; defaults to next to the datadir, which hardlinks need to be on the same volume")
+	snapshotKeep := flag.Int("snapshot-keep", 1, "Number of datadir snapshots to keep per node")
+	dockerImage := flag.String("docker-image", "", "Run cdk-erigon as a container of this image, e.g. hermeznetwork/cdk-erigon:v2.1.0, with the datadir and generated config mounted and the rewritten ports published, instead of building it in -repo")
+	adopt := flag.Bool("adopt", false, "Let cdk-erigon outlive the runner, writing its output and a pidfile to the datadir, and adopt one left running by a previous runner instead of starting another")
+	execCommand := flag.String("exec", "", "Supervise this command, e.g. \"./geth --config x\", instead of building and running cdk-erigon; arguments after -- are appended, and RPC checks need -rpc-url")
+	skipL1Check := flag.Bool("skip-l1-check", false, "Start without checking that the L1 RPC of the erigon config responds with its L1 chain ID")
+	skipBuild := flag.Bool("skip-build", false, "Run the existing binary without running make cdk-erigon")
+	cleanRebuild := flag.Bool("clean-rebuild", true, "Retry a failed build once after make clean with a fresh build cache")
+	buildTimeout := flag.Duration("build-timeout", time.Hour, "How long make cdk-erigon may run before it is killed and alerted as failed; 0 disables the timeout")
+	autoBuild := flag.Bool("auto-build", false, "Only run make cdk-erigon when HEAD changed since the last successful build or the tree is dirty")
+	color := flag.String("color", "auto", "Color cdk-erigon's log lines by level and highlight the ones matching an alert pattern: auto (on terminals unless NO_COLOR is set), always or never")
+	dryRun := flag.Bool("dry-run", false, "Discover ports and write the erigon config, print the build and run commands and exit without building or starting anything")
+	dryRunAlerts := flag.Bool("dry-run-alerts", false, "Log alerts to stderr instead of sending them or running their actions")
+	maxRestarts := flag.Int("max-restarts", 5, "Restarts of cdk-erigon in a row before giving up; 0 disables restarts")
+	backoff := flag.Duration("restart-backoff", 5*time.Second, "Delay before the first restart, doubled on every further restart")
+	maxBackoff := flag.Duration("max-restart-backoff", 5*time.Minute, "Upper bound of the restart delay; runs lasting longer reset the backoff")
+	crashLoopRestarts := flag.Int("crash-loop-restarts", 0, "Stop restarting cdk-erigon after more than this many restarts within -crash-loop-window; 0 disables the breaker")
+	crashLoopWindow := flag.Duration("crash-loop-window", 10*time.Minute, "Window the crash loop breaker counts restarts in")
+	crashDir := flag.String("crash-dir", "crashes", "Directory a tar.gz of the last log lines, exit status, OOM evidence and config is saved in whenever cdk-erigon exits non-zero; empty disables it")
+	crashUpload := flag.String("crash-upload", "", "Bucket crash bundles are uploaded to with the aws or gcloud CLI, e.g. s3://bucket/prefix or gs://bucket/prefix; the alert links the object")
+	crashBundleLines := flag.Int("crash-log-lines", tailLines, fmt.Sprintf("Log lines kept in a crash bundle, up to %d", tailLines))
+	restartSchedule := flag.String("restart-schedule", "", "Cron expression in local time of planned restarts of cdk-erigon, e.g. \"0 4 * * 0\" for Sundays at 04:00")
+	restartNotice := flag.Duration("restart-notice", 10*time.Minute, "How long before a scheduled restart it is announced; 0 disables the notice")
+	restartVerify := flag.Duration("restart-verify", 15*time.Minute, "How long the RPC of a node has to answer again after a scheduled restart before alerting")
+	grace := flag.Duration("shutdown-grace", 2*time.Minute, "How long cdk-erigon gets to shut down after SIGINT/SIGTERM before it is killed")
+	rpcURL := flag.String("rpc-url", "", "HTTP RPC endpoint of the node; defaults to localhost on the rewritten http.port")
+	healthInterval := flag.Duration("health-interval", 30*time.Second, "Interval between RPC health checks; 0 disables them")
+	healthStartDelay := flag.Duration("health-start-delay", 2*time.Minute, "Time a started node gets before its RPC is checked")
+	healthFailures := flag.Int("health-failures", 3, "Failed RPC health checks in a row before alerting")
+	watchdogFailures := flag.Int("watchdog-failures", 0, "Failed RPC health checks in a row after which the still running cdk-erigon is considered wedged and restarted; 0 disables the watchdog")
+	benchFile := flag.String("bench-file", "", "CSV file the blocks/sec and batches/sec of the nodes are appended to every -bench-interval, summarized at exit; empty disables benchmarking")
+	benchInterval := flag.Duration("bench-interval", 30*time.Second, "Interval between benchmark samples")
+	stallAfter := flag.Duration("stall-after", 15*time.Minute, "How long the node's head may stay put before alerting; 0 disables stall detection")
+	diffInterval := flag.Duration("diff-interval", 0, "Interval between comparisons of every node with the first, e.g. a release candidate running its own ref against the release; 0 disables them")
+	diffCalls := flag.String("diff-calls", "eth_getBlockByNumber,eth_getBlockReceipts", "Comma separated RPC methods taking a block number whose responses at the highest block both nodes have must match")
+	diffMaxLag := flag.Uint64("diff-max-lag", 100, "Alert when the heads of compared nodes are more than this many blocks apart; 0 disables the check")
+	stageStallAfter := flag.Duration("stage-stall-after", 30*time.Minute, "How long the stage being run may keep logging the same progress before alerting; 0 disables stage stall detection")
+	minPeers := flag.Int("min-peers", 3, "Alert when the node has fewer peers than this for -low-peers-after; 0 disables peer monitoring")
+	lowPeersAfter := flag.Duration("low-peers-after", 5*time.Minute, "How long the peer count may stay below -min-peers before alerting")
+	maxVirtualLag := flag.Uint64("max-virtual-batch-lag", 0, "Alert when the virtual batch falls more than this many batches behind the latest; 0 disables the check")
+	maxVerifiedLag := flag.Uint64("max-verified-batch-lag", 0, "Alert when the verified batch falls more than this many batches behind the latest; 0 disables the check")
+	maxPendingTxs := flag.Uint64("max-pending-txs", 0, "Alert when more transactions than this are pending in the txpool of a sequencer; 0 disables the check")
+	pendingGrowthAfter := flag.Duration("pending-growth-after", 0, "Alert when the pending transactions in the txpool of a sequencer keep growing for this long, as when it stopped building batches; 0 disables the check")
+	feeMethods := flag.String("fee-methods", "", "Comma separated RPC methods returning a fee in wei, e.g. eth_gasPrice, alerted when zero or outside -min-fee and -max-fee; empty disables fee monitoring")
+	minFee := flag.Uint64("min-fee", 0, "Alert when a fee of -fee-methods drops below this many wei")
+	maxFee := flag.Uint64("max-fee", 0, "Alert when a fee of -fee-methods exceeds this many wei; 0 disables the bound")
+	syncedWebhook := flag.String("synced-webhook", "", "URL to POST a JSON notification to when the node reached the chain tip, e.g. of a deploy system")
+	referenceRPC := flag.String("reference-rpc", "", "Trusted RPC endpoint whose block hashes the node's must match")
+	datastream := flag.String("datastream", "", "Datastream endpoint to check; defaults to "+datastreamURLKey+" of the erigon config")
+	datastreamInterval := flag.Duration("datastream-interval", time.Minute, "Interval between datastream reachability checks; 0 disables them")
+	reconnectPattern := flag.String("datastream-reconnect-pattern", `(?i)datastream.*reconnect`, "Regex matching the log lines of datastream reconnects")
+	maxReconnects := flag.Int("datastream-reconnects", 5, "Alert when the datastream reconnects more often than this within -datastream-window; 0 disables the check")
+	reconnectWindow := flag.Duration("datastream-window", 10*time.Minute, "Window datastream reconnects are counted in")
+	resourceInterval := flag.Duration("resource-interval", 30*time.Second, "Interval between samples of cdk-erigon's CPU, memory and open files; 0 disables them")
+	maxRSSPercent := flag.Float64("max-rss-percent", 90, "Alert when cdk-erigon's resident memory exceeds this share of RAM; 0 disables the check")
+	maxCPUPercent := flag.Float64("max-cpu-percent", 0, "Alert when cdk-erigon's CPU usage exceeds this percentage of one core; 0 disables the check")
+	maxFDPercent := flag.Float64("max-fd-percent", 90, "Alert when cdk-erigon's open files exceed this share of its limit; 0 disables the check")
+	datadir := flag.String("datadir", "", "Datadir of the node; defaults to datadir of the erigon config")
+	minOpenFiles := flag.Uint64("min-open-files", 65536, "Open files limit cdk-erigon needs; the runner raises its soft limit up to the hard limit and warns if that isn't enough; 0 skips the check")
+	minProcesses := flag.Uint64("min-processes", 4096, "Processes limit cdk-erigon needs, raised and warned about like -min-open-files; 0 skips the check")
+	minFreeGB := flag.Float64("min-free-gb", 20, "Free space the datadir volume needs before cdk-erigon is started; 0 disables the check")
+	diskWarningGB := flag.Float64("disk-warning-gb", 100, "Alert when the datadir volume has less free space than this; 0 disables the warning")
+	diskCriticalGB := flag.Float64("disk-critical-gb", 20, "Alert critically when the datadir volume has less free space than this; 0 disables it")
+	diskInterval := flag.Duration("disk-interval", time.Minute, "Interval between disk space checks; 0 disables them")
+	smokeTests := flag.String("smoke-tests", "chainId,syncing,batchNumber", "Comma separated RPC checks every start of cdk-erigon must pass within -smoke-deadline: chainId, syncing, batchNumber")
+	smokeDeadline := flag.Duration("smoke-deadline", 10*time.Minute, "How long a started cdk-erigon has to pass its smoke tests before alerting; 0 disables them")
+	smokeAbort := flag.Bool("smoke-abort", false, "Stop cdk-erigon when it fails its smoke tests")
+	chainID := flag.Uint64("chain-id", 0, "Chain ID eth_chainId must return in the smoke tests; 0 accepts any")
+	pprofErrors := flag.Int("pprof-errors", 0, "Capture profiles from the node's pprof endpoint when more than this many ERROR lines occur within -pprof-window; 0 disables it. Needs pprof enabled in the erigon config")
+	pprofWindow := flag.Duration("pprof-window", time.Minute, "Window ERROR lines are counted in for -pprof-errors")
+	pprofCooldown := flag.Duration("pprof-cooldown", 30*time.Minute, "Minimum time between two profile captures of a node")
+	pprofCPUSeconds := flag.Int("pprof-cpu-seconds", 30, "Duration of the captured CPU profile in seconds")
+	pprofDir := flag.String("pprof-dir", "profiles", "Directory captured profiles are saved in, one timestamped directory per capture")
+	statusAddr := flag.String("status-addr", "", "Address to serve the runner status API and Prometheus metrics on, e.g. localhost:8090")
+	issueReportDir := flag.String("issue-report-dir", "", "Directory a markdown bug report with the version, redacted config, last log lines and host is written to when a pattern of -issue-report-severities first matches; empty disables reports")
+	issueSeverities := flag.String("issue-report-severities", "FATAL,CRITICAL", "Comma separated severities of the patterns that are reported as issues")
+	issueRepo := flag.String("issue-repo", "", "GitHub repository, as owner/name, to open the issue reports in with -github-token")
+	githubToken := flag.String("github-token", "", "GitHub token opening the issues of -issue-repo; env:NAME and file:PATH read it from there")
+	controlToken := flag.String("control-token", "", "Bearer token enabling POST /restart and /stop on the status API; env:NAME and file:PATH read it from there")
+	var service *serviceOptions
+	if install {
+		service = registerServiceFlags(flag.CommandLine)
+	}
+	flag.CommandLine.Parse(args)
+	// Arguments after the flags, usually separated by --, are passed on to
+	// every cdk-erigon.
+	extraArgs := flag.Args()
+	if *autoUpdate > 0 && *snapshotKeep < 1 {
+		return fmt.Errorf("-snapshot-keep must be at least 1")
+	}
+	if *benchFile != "" && *benchInterval <= 0 {
+		return fmt.Errorf("-bench-interval must be positive")
+	}
+	if install {
+		runArgs := serviceArgs(flag.CommandLine)
+		if len(extraArgs) > 0 {
+			runArgs = append(append(runArgs, "--"), extraArgs...)
+		}
+		service.adopt = *adopt
+		return runInstallService(service, runArgs, *grace)
+	}
 
-func readConfig(filePath string) (*Config, error) {
-	content, err := os.ReadFile(filePath)
+	// Read config for alerts
+	config, err := alerting.ReadConfig(*configFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %w", filePath, err)
+		return fmt.Errorf("failed to read config file: %w", err)
 	}
-	var config Config
-	err = json.Unmarshal(content, &config)
+	runner, err := readRunnerConfig(*configFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse config file %s: %w", filePath, err)
+		return err
 	}
-	return &config, nil
-}
-
-func sendGoogleChatAlert(webhookURL, msgPrefix, log string, suppressionCount int) {
-	msgContent := fmt.Sprintf("%s\n%s", msgPrefix, log)
-	if suppressionCount > 0 {
-		msgContent = fmt.Sprintf("%s\nSuppressed %d duplicate(s)", msgContent, suppressionCount)
+	// Without node sections the flags describe a single node.
+	nodeConfigs := runner.Nodes
+	if len(nodeConfigs) == 0 {
+		nodeConfigs = []nodeConfig{{}}
 	}
-	message := map[string]string{"text": msgContent}
-	messageBytes, err := json.Marshal(message)
+
+	hostname, err := os.Hostname()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating JSON message: %v\n", err)
-		return
+		return fmt.Errorf("failed to get hostname: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(messageBytes))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
-		return
+	var execArgs []string
+	if *execCommand != "" {
+		if *dockerImage != "" || *autoUpdate > 0 || *chain != "" || *ref != "" {
+			return fmt.Errorf("-exec can't be used with -docker-image, -auto-update, -chain or -ref")
+		}
+		if execArgs, err = splitCommand(*execCommand); err != nil {
+			return fmt.Errorf("invalid -exec: %w", err)
+		}
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending alert: %v\n", err)
-		return
+	// Check out the requested revision before its config is read
+	if *ref != "" && *dryRun {
+		fmt.Printf("Would check out %s; reading the configs of the current checkout\n", *ref)
+	} else if *ref != "" {
+		commit, err := checkoutRef(*erigonRepo, *ref, *force)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Checked out %s at %s\n", *ref, commit)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode > 299 {
-		fmt.Println("Alert sent to Google Chat, response status:", resp.Status)
+
+	var overrides yaml.MapSlice
+	if *override != "" {
+		if overrides, err = readOverrides(*override); err != nil {
+			return err
+		}
 	}
-}
 
-func logToFile(log, logFile, msgPrefix string) {
-	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
-		return
+	if *dockerImage != "" && *autoUpdate > 0 {
+		return fmt.Errorf("-auto-update builds cdk-erigon and can't be used with -docker-image")
 	}
-	defer file.Close()
-	l := fmt.Sprintf("%s %s \n", msgPrefix, log)
-	_, err = file.WriteString(l)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing to file: %v\n", err)
+	if *dockerImage != "" && *adopt {
+		return fmt.Errorf("-adopt can't be used with -docker-image")
 	}
-}
-
-func searchLog(log string, patterns []*regexp.Regexp) (bool, string) {
-	for _, pattern := range patterns {
-		if pattern.MatchString(log) {
-			return true, pattern.String()
+	var calls []string
+	if *diffInterval > 0 {
+		if *execCommand != "" && *rpcURL == "" {
+			return fmt.Errorf("-diff-interval needs -rpc-url with -exec")
+		}
+		if len(nodeConfigs) < 2 {
+			return fmt.Errorf("-diff-interval needs at least two nodes in %s", *configFile)
+		}
+		for _, call := range strings.Split(*diffCalls, ",") {
+			if call = strings.TrimSpace(call); call != "" {
+				calls = append(calls, call)
+			}
 		}
 	}
-	return false, ""
-}
-
-// Port scanning and configuration updating
 
-func findAvailablePort(port int) (int, error) {
-	for {
-		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-		if err == nil {
-			listener.Close()
-			return port, nil
+	var fees []string
+	for _, method := range strings.Split(*feeMethods, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			fees = append(fees, method)
 		}
-		port++
 	}
-}
 
-func extractPorts(configFile string) (map[string]string, error) {
-	absPath, err := filepath.Abs(configFile)
+	reconnectRegex, err := regexp.Compile(*reconnectPattern)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path for config file: %w", err)
+		return fmt.Errorf("invalid datastream reconnect pattern: %w", err)
 	}
-
-	fmt.Println("Reading config file:", absPath)
-
-	content, err := os.ReadFile(absPath)
+	checks, err := parseSmokeChecks(*smokeTests)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %w", absPath, err)
+		return err
 	}
-
-	var config map[string]interface{}
-	err = yaml.Unmarshal(content, &config)
+	colorStdout, err := useColor(*color, os.Stdout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse config file %s: %w", absPath, err)
+		return err
 	}
-
-	ports := make(map[string]string)
-	for key, value := range config {
-		if strings.Contains(key, "port") || strings.Contains(key, "ports") {
-			fmt.Println(key, value)
-			portList, ok := value.(string)
-			if ok {
-				fmt.Println(key, value)
-				ports[key] = portList
-			}
+	colorStderr, _ := useColor(*color, os.Stderr)
+	var issueToken string
+	if *issueRepo != "" {
+		if *issueReportDir == "" {
+			return fmt.Errorf("-issue-repo needs -issue-report-dir")
+		}
+		if issueToken, err = alerting.ResolveSecret(*githubToken); err != nil {
+			return fmt.Errorf("failed to resolve -github-token: %w", err)
+		}
+		if issueToken == "" {
+			return fmt.Errorf("-issue-repo needs -github-token")
 		}
 	}
 
-	fmt.Println(ports)
-
-	return ports, nil
-}
-
-func updateConfig(configFile string, ports map[string]string) (string, error) {
-	content, err := os.ReadFile(configFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to read config file %s: %w", configFile, err)
+	var uploadBucket *bucketURL
+	if *crashUpload != "" {
+		if uploadBucket, err = parseBucketURL(*crashUpload); err != nil {
+			return err
+		}
+	}
+	var schedule *cronSchedule
+	if *restartSchedule != "" {
+		if schedule, err = parseCron(*restartSchedule); err != nil {
+			return err
+		}
 	}
 
-	var config map[string]interface{}
-	err = yaml.Unmarshal(content, &config)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+	const binary = "./build/bin/cdk-erigon"
+	var nodes []*node
+	defer func() {
+		for _, n := range nodes {
+			n.pipeline.Close()
+		}
+	}()
+	reserved := make(map[int]bool)
+	var portLow, portHigh int
+	if *portRange != "" {
+		if portLow, portHigh, err = parsePortRange(*portRange); err != nil {
+			return err
+		}
 	}
+	datadirs := make(map[string]string)
+	inherited := false
+	for i, nc := range nodeConfigs {
+		alertConfig, own := nodeAlertConfig(config, nc.Name, inherited)
+		inherited = inherited || !own
+		alerts := newAlertCounter()
+		// The reporter is set up along with the supervisor it reports on.
+		var reporter *issueReporter
+		pipeline, err := alerting.NewPipeline(alertConfig, alerting.Options{
+			Hostname: hostname,
+			Prefix:   *msgPrefix,
+			Service:  nc.Name,
+			DryRun:   *dryRun || *dryRunAlerts,
+			OnAlert: func(a alerting.Alert) {
+				alerts.Count(a)
+				if reporter != nil {
+					reporter.observe(a)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set up alerting for node %s: %w", nc.Name, err)
+		}
+		n := &node{name: nc.Name, pipeline: pipeline, alerts: alerts}
+		nodes = append(nodes, n)
+
+		// A command of -exec has no erigon config.
+		erigonConfigPath := filepath.Join(*erigonRepo, *erigonConfig)
+		var erigonContent string
+		var erigonSettings map[string]interface{}
+		if *execCommand == "" {
+			nodeChain := *chain
+			if nc.ErigonConfig != "" {
+				erigonConfigPath = filepath.Join(*erigonRepo, nc.ErigonConfig)
+				nodeChain = ""
+			}
+			if nc.Chain != "" {
+				nodeChain = nc.Chain
+			}
+			erigonConfigPath, erigonContent, erigonSettings, err = loadErigonConfig(*erigonRepo, erigonConfigPath, nodeChain, overrides)
+			if err != nil {
+				return err
+			}
+		}
 
-	for key, portList := range ports {
-		portValues := strings.Split(portList, ",")
-		newPortList := []string{}
+		// Datadir
+		var args []string
+		n.datadir = *datadir
+		if nc.Datadir != "" {
+			n.datadir = nc.Datadir
+			if *execCommand == "" {
+				args = append(args, "--datadir="+nc.Datadir)
+			}
+		}
+		if n.datadir == "" {
+			n.datadir = configString(erigonSettings, "datadir")
+		}
+		if n.datadir != "" && !filepath.IsAbs(n.datadir) {
+			// cdk-erigon runs in the repository.
+			n.datadir = filepath.Join(*erigonRepo, n.datadir)
+		}
+		if other, ok := datadirs[n.datadir]; ok && n.datadir != "" {
+			return fmt.Errorf("nodes %s and %s share the datadir %s", other, nc.Name, n.datadir)
+		}
+		datadirs[n.datadir] = nc.Name
 
-		for _, portStr := range portValues {
-			port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		alloc := &portAllocator{offset: *portOffset * (i + 1), low: portLow, high: portHigh, reserved: reserved}
+		if nc.PortOffset != 0 {
+			alloc.offset = nc.PortOffset
+		}
+		// An adopted node keeps the ports it was started on.
+		adopted := 0
+		if *adopt {
+			if n.datadir == "" {
+				return fmt.Errorf("-adopt needs a datadir for the pidfile, set -datadir or datadir in %s", erigonConfigPath)
+			}
+			launched := binary
+			if *execCommand != "" {
+				launched = execArgs[0]
+			}
+			if adopted, err = adoptablePID(n.datadir, filepath.Base(launched)); err != nil {
+				return err
+			}
+			alloc.adopting = adopted != 0
+		}
+		if n.datadir != "" && (alloc.offset == 0 || alloc.adopting) {
+			if alloc.locked, err = readPortLock(n.datadir); err != nil {
+				return err
+			}
+		}
+
+		// Port configuration
+		var tempConfigFile string
+		var ports map[string][]int
+		if *execCommand == "" {
+			fmt.Println("Updating ports in config file:", erigonConfigPath)
+			originalPorts, err := extractPorts(erigonSettings)
 			if err != nil {
-				return "", err
+				return fmt.Errorf("failed to extract ports from config file: %w", err)
 			}
-			newPort, err := findAvailablePort(port)
+			tempConfigFile, ports, err = updateConfig(erigonConfigPath, erigonContent, originalPorts, nc.Name, alloc)
 			if err != nil {
-				return "", err
+				return fmt.Errorf("failed to update config file: %w", err)
+			}
+			if !*dryRun {
+				defer os.Remove(tempConfigFile) // Clean up temporary file
+			}
+			args = append([]string{"--config=" + tempConfigFile}, args...)
+			if n.datadir != "" && !*dryRun {
+				if err := writePortLock(n.datadir, originalPorts, ports); err != nil {
+					fmt.Fprintf(os.Stderr, "Error writing port lockfile: %v\n", err)
+				}
 			}
-			newPortList = append(newPortList, strconv.Itoa(newPort))
 		}
 
-		newPortStr := strings.Join(newPortList, ", ")
-		config[key] = newPortStr
-		fmt.Printf("Updated %s to %s\n", key, newPortStr)
-	}
-
-	newConfigFile := configFile[:len(configFile)-len(filepath.Ext(configFile))] + "_new" + filepath.Ext(configFile)
-	tempContent, err := yaml.Marshal(config)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal updated config: %w", err)
-	}
+		// Disk space preflight
+		if n.datadir != "" && *minFreeGB > 0 {
+			free, err := freeDiskSpace(existingDir(n.datadir))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error checking free disk space: %v\n", err)
+			} else if float64(free) < *minFreeGB*gb {
+				return fmt.Errorf("only %.1f GB free on the volume of %s, need at least %.1f GB", float64(free)/gb, n.datadir, *minFreeGB)
+			}
+		}
 
-	err = ioutil.WriteFile(newConfigFile, tempContent, 0644)
-	if err != nil {
-		return "", fmt.Errorf("failed to write new config file: %w", err)
-	}
+		// L1 preflight
+		if l1URL := configString(erigonSettings, l1RPCKey); l1URL != "" && !*skipL1Check {
+			l1ChainID, _ := strconv.ParseUint(configString(erigonSettings, l1ChainIDKey), 10, 64)
+			if err := checkL1(l1URL, l1ChainID); err != nil {
+				if nc.Name != "" {
+					return fmt.Errorf("node %s: %w", nc.Name, err)
+				}
+				return err
+			}
+		}
 
-	return newConfigFile, nil
-}
+		// Datastream checks
+		datastreamURL := nc.Datastream
+		if datastreamURL == "" {
+			datastreamURL = *datastream
+		}
+		if datastreamURL == "" {
+			datastreamURL = configString(erigonSettings, datastreamURLKey)
+		}
+		if datastreamURL != "" {
+			n.watcher = &datastreamWatcher{
+				addr:          datastreamAddr(datastreamURL),
+				pipeline:      pipeline,
+				timeout:       10 * time.Second,
+				reconnect:     reconnectRegex,
+				maxReconnects: *maxReconnects,
+				window:        *reconnectWindow,
+			}
+			if err := n.watcher.check(); err == nil {
+				fmt.Println("Datastream reachable at", n.watcher.addr)
+			}
+		}
 
-func main() {
-	// Command-line arguments
-	configFile := flag.String("config", "config.json", "Path to the configuration file")
-	msgPrefix := flag.String("msg", "", "Chat message prefix")
-	erigonRepo := flag.String("repo", ".", "Path to the cdk-erigon repository")
-	erigonConfig := flag.String("erigon-config", "hermezconfig-bali.yaml", "Path to the erigon configuration file")
-	flag.Parse()
+		url := nc.RPCURL
+		if url == "" {
+			url = *rpcURL
+		}
+		if url == "" && *execCommand == "" {
+			port := defaultHTTPPort
+			if httpPorts := ports["http.port"]; len(httpPorts) > 0 {
+				port = strconv.Itoa(httpPorts[0])
+			}
+			url = "http://localhost:" + port
+		}
+		// Without an endpoint, a command of -exec has no RPC checks.
+		if url != "" {
+			n.rpc = &rpcClient{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+		}
+		pprofHost := configString(erigonSettings, "pprof.addr")
+		if pprofHost == "" {
+			pprofHost = "127.0.0.1"
+		}
+		pprofPort := defaultPprofPort
+		if pprofPorts := ports["pprof.port"]; len(pprofPorts) > 0 {
+			pprofPort = strconv.Itoa(pprofPorts[0])
+		}
+		n.pprofURL = "http://" + net.JoinHostPort(pprofHost, pprofPort)
+		if *pprofErrors > 0 && configString(erigonSettings, "pprof") != "true" {
+			fmt.Fprintf(os.Stderr, "Warning: pprof is not enabled in %s, profiles can't be captured\n", erigonConfigPath)
+		}
+		n.chainID = *chainID
+		if nc.ChainID != 0 {
+			n.chainID = nc.ChainID
+		}
 
-	// Read config for alerts
-	config, err := readConfig(*configFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading config file: %v\n", err)
-		return
-	}
+		// A node of its own ref runs in a worktree, with absolute paths.
+		nodeRepo := *erigonRepo
+		if nc.Ref != "" {
+			if *dockerImage != "" || *autoUpdate > 0 || *execCommand != "" {
+				return fmt.Errorf("node %s: ref can't be used with -docker-image, -auto-update or -exec", nc.Name)
+			}
+			if nodeRepo, err = worktreePath(*erigonRepo, nc.Name); err != nil {
+				return err
+			}
+			if *dryRun {
+				fmt.Printf("Would check out %s for node %s in %s\n", nc.Ref, nc.Name, nodeRepo)
+			} else {
+				commit, err := checkoutWorktree(*erigonRepo, nodeRepo, nc.Ref, *force)
+				if err != nil {
+					return fmt.Errorf("node %s: %w", nc.Name, err)
+				}
+				fmt.Printf("Checked out %s at %s for node %s in %s\n", nc.Ref, commit, nc.Name, nodeRepo)
+			}
+			configPath, err := filepath.Abs(tempConfigFile)
+			if err != nil {
+				return err
+			}
+			args = []string{"--config=" + configPath}
+			if n.datadir != "" {
+				if n.datadir, err = filepath.Abs(n.datadir); err != nil {
+					return err
+				}
+				args = append(args, "--datadir="+n.datadir)
+			}
+		}
 
-	regexPatterns := make([]*regexp.Regexp, len(config.Patterns))
-	patternCooldowns := make(map[string]time.Duration)
-	for i, patternConfig := range config.Patterns {
-		regexPatterns[i] = regexp.MustCompile(patternConfig.Pattern)
-		if patternConfig.TimeoutMinutes == 0 {
-			patternCooldowns[patternConfig.Pattern] = 24 * time.Hour * 365 * 100 // effectively never
-		} else {
-			patternCooldowns[patternConfig.Pattern] = time.Duration(patternConfig.TimeoutMinutes) * time.Minute
+		// The engine API secret, which a container finds in its own filesystem.
+		if jwtSecret := configString(erigonSettings, jwtSecretKey); jwtSecret != "" && *dockerImage == "" {
+			if !filepath.IsAbs(jwtSecret) {
+				jwtSecret = filepath.Join(nodeRepo, jwtSecret)
+			}
+			if err := prepareJWTSecret(jwtSecret, *dryRun); err != nil {
+				return err
+			}
 		}
-	}
 
-	defaultCooldown := time.Duration(config.DefaultTimeoutMinutes) * time.Minute
-	alertManager := NewAlertManager(defaultCooldown, patternCooldowns)
+		nodeBinary := binary
+		if *execCommand != "" {
+			nodeBinary, args = execArgs[0], append(execArgs[1:len(execArgs):len(execArgs)], args...)
+		}
 
-	// Port configuration
-	erigonConfigPath := filepath.Join(*erigonRepo, *erigonConfig)
-	fmt.Println("Updating ports in config file:", erigonConfigPath)
-	originalPorts, err := extractPorts(erigonConfigPath)
-	if err != nil {
-		log.Fatalf("Error extracting ports from config file: %v", err)
+		n.supervisor = &supervisor{
+			name:        nc.Name,
+			dir:         nodeRepo,
+			binary:      nodeBinary,
+			args:        append(append(args, nc.Args...), extraArgs...),
+			pipeline:    pipeline,
+			maxLine:     alertConfig.MaxLineBytes,
+			maxRestarts: *maxRestarts,
+			backoff:     *backoff,
+			maxBackoff:  *maxBackoff,
+
+			crashLoopRestarts: *crashLoopRestarts,
+			crashLoopWindow:   *crashLoopWindow,
+			crashDir:          *crashDir,
+			crashLogLines:     *crashBundleLines,
+			crashUpload:       uploadBucket,
+			grace:             *grace,
+			stop:              make(chan struct{}),
+			kill:              make(chan struct{}),
+			detach:            make(chan struct{}),
+			restart:           make(chan struct{}, 1),
+			done:              make(chan struct{}),
+			tail:              newLogTail(tailLines),
+			status:            childStatus{State: "starting", Peers: -1},
+
+			colorStdout: colorStdout,
+			colorStderr: colorStderr,
+			hooks:       runner.Hooks.merge(nc.Hooks),
+			hookEnv: map[string]string{
+				"NODE":     nc.Name,
+				"DATADIR":  n.datadir,
+				"REPO":     nodeRepo,
+				"CONFIG":   tempConfigFile,
+				"HOSTNAME": hostname,
+			},
+		}
+		if *issueReportDir != "" {
+			reporter = &issueReporter{
+				dir:         *issueReportDir,
+				supervisor:  n.supervisor,
+				name:        nc.Name,
+				fatal:       fatalPatterns(alertConfig, strings.Split(*issueSeverities, ",")),
+				githubRepo:  *issueRepo,
+				githubToken: issueToken,
+				dryRun:      *dryRunAlerts,
+				client:      &http.Client{Timeout: 30 * time.Second},
+				reported:    make(map[string]bool),
+			}
+		}
+		if *adopt {
+			n.supervisor.adoptDir = n.datadir
+			n.supervisor.outputRotation = alertConfig.LogRotation
+			n.supervisor.adopted = adopted
+		}
+		if *dockerImage != "" {
+			if n.datadir == "" {
+				return fmt.Errorf("-docker-image needs a datadir to mount, set -datadir or datadir in %s", erigonConfigPath)
+			}
+			n.image = *dockerImage
+			n.supervisor.container = containerName(n.datadir)
+			n.supervisor.binary = "docker"
+			n.supervisor.args, err = dockerArgs(*dockerImage, n.supervisor.container, tempConfigFile, n.datadir, ports, erigonSettings, append(nc.Args, extraArgs...))
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	tempConfigFile, err := updateConfig(erigonConfigPath, originalPorts)
-	if err != nil {
-		log.Fatalf("Error updating config file: %v", err)
+	// Limits preflight; a container gets the limits of the docker daemon.
+	if *dockerImage == "" {
+		for _, warning := range preflightLimits(*minOpenFiles, *minProcesses) {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+			nodes[0].pipeline.Event("limits", "WARNING", warning)
+		}
 	}
-	defer os.Remove(tempConfigFile) // Clean up temporary file
 
-	// Build the cdk-erigon
-	buildCmd := exec.Command("make", "cdk-erigon")
-	buildCmd.Dir = *erigonRepo
-	if err := buildCmd.Run(); err != nil {
-		log.Fatalf("Build failed: %v", err)
+	if *dryRun {
+		if *dockerImage != "" {
+			fmt.Println("Would run the image", *dockerImage)
+		} else if *execCommand == "" {
+			printBuild(*erigonRepo, binary, *skipBuild, *autoBuild)
+			for _, n := range nodes {
+				if n.supervisor.dir != *erigonRepo {
+					printBuild(n.supervisor.dir, binary, *skipBuild, *autoBuild)
+				}
+			}
+		}
+		for _, n := range nodes {
+			if n.name != "" {
+				fmt.Printf("Would run node %s:\n", n.name)
+			} else {
+				fmt.Println("Would run:")
+			}
+			if n.supervisor.adopted != 0 {
+				fmt.Printf("  adopting the running cdk-erigon %d, and once it exited\n", n.supervisor.adopted)
+			}
+			fmt.Printf("  cd %s && %s\n", shellQuote(n.supervisor.dir), shellCommand(n.supervisor.binary, n.supervisor.args))
+		}
+		return nil
 	}
 
-	// Run the cdk-erigon with the updated config file
-	runCmd := exec.Command("./build/bin/cdk-erigon", "--config="+tempConfigFile)
-	runCmd.Dir = *erigonRepo
-	stdout, err := runCmd.StdoutPipe()
-	if err != nil {
-		log.Fatalf("Error creating stdout pipe: %v", err)
+	// Build the cdk-erigon once for all nodes
+	buildOpts := buildOptions{timeout: *buildTimeout, pipeline: nodes[0].pipeline, hooks: runner.Hooks, cleanRebuild: *cleanRebuild}
+	if *dockerImage != "" {
+		// A datadir docker creates for the mount would belong to root.
+		for _, n := range nodes {
+			if err := os.MkdirAll(n.datadir, 0755); err != nil {
+				return fmt.Errorf("failed to create datadir: %w", err)
+			}
+		}
+	} else if *execCommand != "" {
+		fmt.Println("Running", *execCommand, "instead of cdk-erigon")
+	} else if err := prepareBinary(*erigonRepo, binary, *skipBuild, *autoBuild, buildOpts); err != nil {
+		return err
+	}
+	// Nodes of their own ref are built in their worktrees
+	for _, n := range nodes {
+		if n.supervisor.dir == *erigonRepo {
+			continue
+		}
+		opts := buildOpts
+		opts.pipeline = n.pipeline
+		fmt.Printf("Building node %s in %s\n", n.name, n.supervisor.dir)
+		if err := prepareBinary(n.supervisor.dir, binary, *skipBuild, *autoBuild, opts); err != nil {
+			return fmt.Errorf("node %s: %w", n.name, err)
+		}
 	}
-	stderr, err := runCmd.StderrPipe()
-	if err != nil {
-		log.Fatalf("Error creating stderr pipe: %v", err)
+
+	if *statusAddr != "" {
+		token, err := alerting.ResolveSecret(*controlToken)
+		if err != nil {
+			return fmt.Errorf("failed to resolve -control-token: %w", err)
+		}
+		listener, err := net.Listen("tcp", *statusAddr)
+		if err != nil {
+			return fmt.Errorf("failed to start status API: %w", err)
+		}
+		profiles := ""
+		if *pprofErrors > 0 {
+			profiles = *pprofDir
+		}
+		server := &http.Server{Handler: statusHandler(nodes, profiles, token)}
+		go server.Serve(listener)
+		defer server.Close()
 	}
 
-	if err := runCmd.Start(); err != nil {
-		log.Fatalf("Error starting cdk-erigon: %v", err)
+	// Profiles are linked through the status API when it runs.
+	profileLinks := ""
+	if *statusAddr != "" {
+		host, port, err := net.SplitHostPort(*statusAddr)
+		if err == nil {
+			if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+				host = hostname
+			}
+			profileLinks = "http://" + net.JoinHostPort(host, port) + "/profiles/"
+		}
 	}
 
-	// Read and process logs
-	scanner := bufio.NewScanner(io.MultiReader(stdout, stderr))
-	for scanner.Scan() {
-		logLine := scanner.Text()
-		fmt.Println(logLine)
-		logToFile(logLine, config.LogFile, *msgPrefix)
-		if match, pattern := searchLog(logLine, regexPatterns); match {
-			if shouldSend, suppressionCount := alertManager.ShouldSendAlert(pattern); shouldSend {
-				sendGoogleChatAlert(config.WebhookURL, *msgPrefix, logLine, suppressionCount)
+	// Monitor every node while it is supervised
+	for _, n := range nodes {
+		n, s := n, n.supervisor
+		if *execCommand == "" {
+			s.beforeStart = func() { n.setVersion(n.detectVersion()) }
+			s.observers = append(s.observers, n.observeBuildInfo)
+		}
+		if *pprofErrors > 0 {
+			p := &profiler{
+				name:       n.name,
+				url:        n.pprofURL,
+				dir:        *pprofDir,
+				pipeline:   n.pipeline,
+				client:     &http.Client{Timeout: time.Duration(*pprofCPUSeconds)*time.Second + time.Minute},
+				threshold:  *pprofErrors,
+				window:     *pprofWindow,
+				cooldown:   *pprofCooldown,
+				cpuSeconds: *pprofCPUSeconds,
+				linkBase:   profileLinks,
+			}
+			s.observers = append(s.observers, p.observe)
+		}
+		if *stageStallAfter > 0 {
+			w := &stageWatcher{pipeline: n.pipeline, supervisor: s, name: n.name, window: *stageStallAfter}
+			s.observers = append(s.observers, w.observe)
+		}
+		if n.watcher != nil {
+			s.observers = append(s.observers, n.watcher.observe)
+			if *datastreamInterval > 0 {
+				go n.watcher.run(*datastreamInterval, s.stop)
+			}
+		}
+
+		if *healthInterval > 0 && n.rpc != nil {
+			m := &monitor{
+				rpc:        n.rpc,
+				pipeline:   n.pipeline,
+				supervisor: s,
+				interval:   *healthInterval,
+				startDelay: *healthStartDelay,
+				failures:   *healthFailures,
+				stallAfter: *stallAfter,
+
+				watchdogFailures: *watchdogFailures,
+
+				minPeers:      *minPeers,
+				lowPeersAfter: *lowPeersAfter,
+
+				maxVirtualLag:  *maxVirtualLag,
+				maxVerifiedLag: *maxVerifiedLag,
+
+				maxPending:         *maxPendingTxs,
+				pendingGrowthAfter: *pendingGrowthAfter,
+
+				feeMethods: fees,
+				minFee:     *minFee,
+				maxFee:     *maxFee,
+
+				syncedWebhook: *syncedWebhook,
+				hostname:      hostname,
+				name:          n.name,
+			}
+			if *referenceRPC != "" {
+				m.reference = &rpcClient{url: *referenceRPC, client: n.rpc.client}
+			}
+			go m.run()
+		}
+
+		if schedule != nil {
+			r := &scheduledRestarter{
+				schedule:   schedule,
+				notice:     *restartNotice,
+				verify:     *restartVerify,
+				pipeline:   n.pipeline,
+				supervisor: s,
+				rpc:        n.rpc,
+				name:       n.name,
+			}
+			go r.run()
+		}
+
+		if len(checks) > 0 && *smokeDeadline > 0 && n.rpc != nil {
+			t := &smokeTest{
+				rpc:        n.rpc,
+				pipeline:   n.pipeline,
+				supervisor: s,
+				checks:     checks,
+				interval:   5 * time.Second,
+				deadline:   *smokeDeadline,
+				chainID:    n.chainID,
+				abort:      *smokeAbort,
+			}
+			go t.run()
+		}
+
+		if *resourceInterval > 0 {
+			r := &resourceMonitor{
+				pipeline:      n.pipeline,
+				supervisor:    s,
+				interval:      *resourceInterval,
+				maxRSSPercent: *maxRSSPercent,
+				maxCPUPercent: *maxCPUPercent,
+				maxFDPercent:  *maxFDPercent,
 			}
+			go r.run()
 		}
+
+		if n.datadir != "" && *diskInterval > 0 {
+			d := &diskMonitor{
+				path:       n.datadir,
+				pipeline:   n.pipeline,
+				supervisor: s,
+				interval:   *diskInterval,
+				warning:    uint64(*diskWarningGB * gb),
+				critical:   uint64(*diskCriticalGB * gb),
+			}
+			go d.run()
+		}
+	}
+
+	stop := make(chan struct{})
+	if *autoUpdate > 0 {
+		branch, current, err := followedBranch(*erigonRepo, *updateBranch, *ref)
+		if err != nil {
+			return fmt.Errorf("failed to set up auto-update: %w", err)
+		}
+		fmt.Printf("Following %s for updates, running %s\n", branch, describe(*erigonRepo, current))
+		u := &updater{
+			repo:     *erigonRepo,
+			branch:   branch,
+			binary:   binary,
+			pipeline: nodes[0].pipeline,
+			nodes:    nodes,
+			stop:     stop,
+			interval: *autoUpdate,
+			verify:   *updateVerify,
+			current:  current,
+
+			build: buildOpts,
+
+			snapshot:     *snapshotBeforeUpdate,
+			snapshotDir:  *snapshotDir,
+			snapshotKeep: *snapshotKeep,
+		}
+		go u.run()
 	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading log output: %v\n", err)
+
+	if *diffInterval > 0 {
+		go newDiffer(nodes, calls, *diffMaxLag, *diffInterval, stop).run()
 	}
 
-	if err := runCmd.Wait(); err != nil {
-		log.Fatalf("cdk-erigon finished with error: %v", err)
+	var bench *benchmark
+	if *benchFile != "" {
+		bench = newBenchmark(nodes, *benchInterval, *benchFile)
+		go bench.run()
 	}
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		stopped := false
+		for sig := range signals {
+			if !stopped {
+				close(stop)
+				stopped = true
+			}
+			for _, n := range nodes {
+				n.supervisor.Shutdown(sig)
+			}
+		}
+	}()
+
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *node) {
+			defer wg.Done()
+			errs[i] = n.run(*statusAddr)
+		}(i, n)
+	}
+	wg.Wait()
+	if bench != nil {
+		bench.Stop()
+	}
+	return errors.Join(errs...)
 }
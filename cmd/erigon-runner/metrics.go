@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// recentAlertCount is how many of the last alerts the status API reports.
+const recentAlertCount = 10
+
+// alertCounter counts the alerts sent per pattern or event and keeps the
+// last ones.
+type alertCounter struct {
+	counts map[string]int
+	recent []recentAlert
+	mu     sync.Mutex
+}
+
+type recentAlert struct {
+	Time     time.Time `json:"time"`
+	Pattern  string    `json:"pattern"`
+	Severity string    `json:"severity"`
+	Log      string    `json:"log"`
+}
+
+func newAlertCounter() *alertCounter {
+	return &alertCounter{counts: make(map[string]int)}
+}
+
+func (c *alertCounter) Count(a alerting.Alert) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[a.Pattern]++
+	log := a.Log
+	if len(log) > 500 {
+		log = log[:500] + "..."
+	}
+	c.recent = append(c.recent, recentAlert{Time: a.Time, Pattern: a.Pattern, Severity: a.Severity, Log: log})
+	if len(c.recent) > recentAlertCount {
+		c.recent = c.recent[1:]
+	}
+}
+
+// Recent returns the last alerts, oldest first.
+func (c *alertCounter) Recent() []recentAlert {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]recentAlert(nil), c.recent...)
+}
+
+func (c *alertCounter) Counts() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int, len(c.counts))
+	for pattern, n := range c.counts {
+		counts[pattern] = n
+	}
+	return counts
+}
+
+// metricsHandler serves runner metrics in the Prometheus text format.
+func metricsHandler(nodes []*node) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics := newMetricSet()
+		for _, n := range nodes {
+			var labels []string
+			if n.name != "" {
+				labels = []string{"instance", n.name}
+			}
+			addMetrics(metrics, labels, n.supervisor.Status(), n.alerts.Counts(), n.pipeline.Counts())
+		}
+		metrics.write(w)
+	}
+}
+
+// addMetrics adds the metrics of one node, labelled with labels.
+func addMetrics(m *metricSet, labels []string, st childStatus, alerts map[string]int, matches map[string]int64) {
+	up, uptime := 0, 0.0
+	if st.State == "running" {
+		up = 1
+		uptime = time.Since(*st.Started).Seconds()
+	}
+	m.gauge("erigon_runner_child_up", "Whether cdk-erigon is running.", labels, up)
+	m.gauge("erigon_runner_child_uptime_seconds", "Seconds since cdk-erigon was last started.", labels, uptime)
+	m.counter("erigon_runner_restarts_total", "Restarts of cdk-erigon.", labels, st.Restarts)
+	if st.PID != 0 {
+		if stats, err := readProcessStats(st.PID); err == nil {
+			m.gauge("erigon_runner_child_rss_bytes", "Resident memory of cdk-erigon.", labels, stats.RSS)
+			m.counter("erigon_runner_child_cpu_seconds_total", "CPU time used by cdk-erigon.", labels, stats.CPUSeconds)
+			m.gauge("erigon_runner_child_open_fds", "Files cdk-erigon has open.", labels, stats.OpenFDs)
+		}
+	}
+	if st.DiskFree != 0 {
+		m.gauge("erigon_runner_datadir_free_bytes", "Free space on the datadir volume.", labels, st.DiskFree)
+	}
+	if st.Head != 0 {
+		m.gauge("erigon_runner_head_block", "Latest block of the node.", labels, st.Head)
+	}
+	if st.Peers >= 0 {
+		m.gauge("erigon_runner_peers", "Peers of the node.", labels, st.Peers)
+	}
+	if b := st.Batches; b != nil {
+		const batchHelp = "zkEVM batch numbers of the node."
+		m.gauge("erigon_runner_batch_number", batchHelp, with(labels, "type", "latest"), b.Latest)
+		m.gauge("erigon_runner_batch_number", batchHelp, with(labels, "type", "virtual"), b.Virtual)
+		m.gauge("erigon_runner_batch_number", batchHelp, with(labels, "type", "verified"), b.Verified)
+		const lagHelp = "Batches the virtual and verified batch are behind the latest."
+		m.gauge("erigon_runner_batch_lag", lagHelp, with(labels, "type", "virtual"), lag(b.Latest, b.Virtual))
+		m.gauge("erigon_runner_batch_lag", lagHelp, with(labels, "type", "verified"), lag(b.Latest, b.Verified))
+	}
+
+	m.declare("erigon_runner_alerts_total", "Alerts sent per pattern or event.", "counter")
+	for _, pattern := range sortedKeys(alerts) {
+		m.counter("erigon_runner_alerts_total", "", with(labels, "pattern", pattern), alerts[pattern])
+	}
+	m.declare("erigon_runner_pattern_matches_total", "Log lines matched per pattern.", "counter")
+	patterns := make([]string, 0, len(matches))
+	for pattern := range matches {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		m.counter("erigon_runner_pattern_matches_total", "", with(labels, "pattern", pattern), matches[pattern])
+	}
+}
+
+// metricSet groups samples by metric, so each metric's HELP and TYPE are
+// written once however many nodes report it.
+type metricSet struct {
+	names   []string
+	help    map[string]string
+	kinds   map[string]string
+	samples map[string][]string
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		help:    make(map[string]string),
+		kinds:   make(map[string]string),
+		samples: make(map[string][]string),
+	}
+}
+
+func (m *metricSet) declare(name, help, kind string) {
+	if _, ok := m.kinds[name]; ok {
+		return
+	}
+	m.names = append(m.names, name)
+	m.help[name] = help
+	m.kinds[name] = kind
+}
+
+func (m *metricSet) gauge(name, help string, labels []string, value interface{}) {
+	m.add(name, help, "gauge", labels, value)
+}
+
+func (m *metricSet) counter(name, help string, labels []string, value interface{}) {
+	m.add(name, help, "counter", labels, value)
+}
+
+// add records a sample; labels are name, value pairs.
+func (m *metricSet) add(name, help, kind string, labels []string, value interface{}) {
+	m.declare(name, help, kind)
+	sample := name
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], escapeLabel(labels[i+1])))
+		}
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	m.samples[name] = append(m.samples[name], fmt.Sprintf("%s %v", sample, value))
+}
+
+func (m *metricSet) write(w io.Writer) {
+	for _, name := range m.names {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, m.help[name], name, m.kinds[name])
+		for _, sample := range m.samples[name] {
+			fmt.Fprintln(w, sample)
+		}
+	}
+}
+
+// with returns labels extended by another name, value pair.
+func with(labels []string, name, value string) []string {
+	return append(append([]string(nil), labels...), name, value)
+}
+
+func lag(latest, batch uint64) uint64 {
+	if latest > batch {
+		return latest - batch
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
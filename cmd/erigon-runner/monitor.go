@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// monitor polls the node's RPC while it runs and alerts when it stops
+// responding, its head stops advancing or it is short of peers, and again
+// once that recovers.
+type monitor struct {
+	rpc        *rpcClient
+	pipeline   *alerting.Pipeline
+	supervisor *supervisor
+	interval   time.Duration
+	// startDelay gives a freshly started node time to open its RPC port.
+	startDelay time.Duration
+	// failures is how many checks in a row must fail before alerting.
+	failures int
+	// watchdogFailures is how many checks in a row must fail before the
+	// wedged node is restarted; 0 disables the watchdog.
+	watchdogFailures int
+
+	// stallAfter is how long the head may stay put before alerting; 0
+	// disables stall detection.
+	stallAfter time.Duration
+
+	failed    int
+	unhealthy bool
+	lastError error
+	// restarted is set once the watchdog restarted the current run.
+	restarted bool
+
+	head        uint64
+	headChanged time.Time
+	stalled     bool
+
+	// minPeers is the peer count below which the node counts as short of
+	// peers once that lasted lowPeersAfter; 0 disables peer monitoring.
+	minPeers      int
+	lowPeersAfter time.Duration
+
+	lowPeersSince time.Time
+	lowPeers      bool
+
+	// Batch lags above these thresholds alert; 0 disables the check.
+	maxVirtualLag  uint64
+	maxVerifiedLag uint64
+
+	virtualLagging  bool
+	verifiedLagging bool
+
+	// maxPending is the pending transaction count above which a
+	// sequencer's pool counts as backlogged, pendingGrowthAfter how long
+	// the count may keep growing before alerting; 0 disables either check.
+	maxPending         uint64
+	pendingGrowthAfter time.Duration
+
+	pending       uint64
+	growingSince  time.Time
+	backlogged    bool
+	pendingGrowth bool
+
+	// feeMethods return fees in wei, like eth_gasPrice, which must be
+	// non-zero and within minFee and maxFee, if not 0.
+	feeMethods []string
+	minFee     uint64
+	maxFee     uint64
+
+	feeProblems map[string]string
+
+	// reference is a trusted node whose block hashes the node's must match;
+	// nil disables the check.
+	reference *rpcClient
+	diverged  bool
+
+	// syncedWebhook, when set, is posted to as well when the node reached
+	// the chain tip.
+	syncedWebhook string
+	hostname      string
+	name          string
+
+	started   time.Time
+	syncing   bool
+	announced bool
+}
+
+func (m *monitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.supervisor.stop:
+			return
+		case <-ticker.C:
+		}
+		status := m.supervisor.Status()
+		if status.State == "running" && !status.Started.Equal(m.started) {
+			// Every start syncs up to the tip again.
+			m.started = *status.Started
+			m.restarted = false
+			m.syncing = false
+			m.announced = false
+			m.supervisor.setStatus(func(st *childStatus) { st.SyncedAt = nil })
+		}
+		if status.State != "running" || time.Since(*status.Started) < m.startDelay {
+			// A restart is alerted on by the supervisor, so start afresh.
+			m.failed = 0
+			m.unhealthy = false
+			m.head = 0
+			m.stalled = false
+			m.lowPeersSince = time.Time{}
+			m.lowPeers = false
+			m.virtualLagging = false
+			m.verifiedLagging = false
+			m.pending = 0
+			m.growingSince = time.Time{}
+			m.backlogged = false
+			m.pendingGrowth = false
+			m.feeProblems = nil
+			m.diverged = false
+			continue
+		}
+		m.check()
+	}
+}
+
+func (m *monitor) check() {
+	head, err := m.rpc.callUint64("eth_blockNumber")
+	var progress *syncProgress
+	if err == nil {
+		progress, err = m.rpc.syncing()
+	}
+	if err != nil {
+		m.failed++
+		m.lastError = err
+		if m.failed >= m.failures && !m.unhealthy {
+			m.unhealthy = true
+			message := fmt.Sprintf("RPC at %s stopped responding (%d failed checks): %v", m.rpc.url, m.failed, err)
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("rpc-unhealthy", "CRITICAL", message)
+		}
+		if m.watchdogFailures > 0 && m.failed >= m.watchdogFailures && !m.restarted {
+			m.restarted = true
+			message := fmt.Sprintf("Watchdog restarting cdk-erigon: it is still running but its RPC at %s failed %d checks in a row (%s), so it is considered wedged. Last error: %v",
+				m.rpc.url, m.failed, time.Duration(m.failed)*m.interval, err)
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("watchdog-restart", "CRITICAL", message)
+			m.supervisor.Restart()
+		}
+		m.supervisor.setStatus(func(st *childStatus) { st.RPCError = err.Error() })
+		return
+	}
+
+	if m.unhealthy {
+		message := fmt.Sprintf("RPC at %s is responding again after %d failed checks (last error: %v)", m.rpc.url, m.failed, m.lastError)
+		fmt.Fprintln(os.Stderr, message)
+		m.pipeline.Event("rpc-recovered", "INFO", message)
+	}
+	m.failed = 0
+	m.unhealthy = false
+	m.checkStall(head)
+	m.checkSynced(head, progress)
+	peers := -1
+	if m.minPeers > 0 {
+		peers = m.checkPeers()
+	}
+	if m.reference != nil {
+		m.checkDivergence(head)
+	}
+	var batches *batchNumbers
+	if m.maxVirtualLag > 0 || m.maxVerifiedLag > 0 {
+		batches = m.checkBatchLag()
+	}
+	var pool *txPoolStatus
+	if m.maxPending > 0 || m.pendingGrowthAfter > 0 {
+		pool = m.checkTxPool()
+	}
+	var fees feeQuotes
+	if len(m.feeMethods) > 0 {
+		fees = m.checkFees()
+	}
+	m.supervisor.setStatus(func(st *childStatus) {
+		st.Batches = batches
+		st.TxPool = pool
+		st.Fees = fees
+		st.Peers = peers
+		st.RPCError = ""
+		st.Head = head
+		st.Syncing = progress != nil
+	})
+}
+
+func (m *monitor) checkStall(head uint64) {
+	now := time.Now()
+	if m.head == 0 || head > m.head {
+		if m.stalled {
+			message := fmt.Sprintf("Head advanced again from %d to %d after being stuck for %s", m.head, head, now.Sub(m.headChanged).Round(time.Second))
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("sync-resumed", "INFO", message)
+			m.stalled = false
+		}
+		m.head = head
+		m.headChanged = now
+		return
+	}
+	if m.stallAfter > 0 && !m.stalled && now.Sub(m.headChanged) >= m.stallAfter {
+		m.stalled = true
+		message := fmt.Sprintf("Head has been stuck at block %d for %s", m.head, now.Sub(m.headChanged).Round(time.Second))
+		fmt.Fprintln(os.Stderr, message)
+		m.pipeline.Event("sync-stalled", "CRITICAL", message)
+	}
+}
+
+// checkSynced announces, once per start and again after every resync, when
+// the node reached the chain tip.
+func (m *monitor) checkSynced(head uint64, progress *syncProgress) {
+	if progress != nil {
+		if m.announced && !m.syncing {
+			fmt.Fprintf(os.Stderr, "Node fell behind the chain tip at block %d, resyncing\n", head)
+		}
+		m.syncing = true
+		return
+	}
+	if head == 0 || (m.announced && !m.syncing) {
+		return
+	}
+	message := fmt.Sprintf("Node is synced to the chain tip at block %d, %s after starting", head, time.Since(m.started).Round(time.Second))
+	if m.announced {
+		message = fmt.Sprintf("Node is synced to the chain tip again at block %d", head)
+	}
+	m.syncing = false
+	m.announced = true
+	now := time.Now()
+	m.supervisor.setStatus(func(st *childStatus) { st.SyncedAt = &now })
+	fmt.Fprintln(os.Stderr, message)
+	m.pipeline.Event("synced", "INFO", message)
+	if m.syncedWebhook != "" {
+		go m.notifySynced(head, now)
+	}
+}
+
+// notifySynced posts the synced event to the deploy system's webhook.
+func (m *monitor) notifySynced(head uint64, at time.Time) {
+	body, err := json.Marshal(map[string]interface{}{
+		"event":    "synced",
+		"hostname": m.hostname,
+		"node":     m.name,
+		"head":     head,
+		"time":     at.UTC(),
+	})
+	if err != nil {
+		return
+	}
+	resp, err := m.rpc.client.Post(m.syncedWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending synced webhook: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		fmt.Fprintf(os.Stderr, "Error sending synced webhook: HTTP %s\n", resp.Status)
+	}
+}
+
+// peerCount asks for net_peerCount, falling back to admin_peers for nodes that
+// don't serve it.
+func (m *monitor) peerCount() (int, error) {
+	count, err := m.rpc.callUint64("net_peerCount")
+	if err == nil {
+		return int(count), nil
+	}
+	var peers []interface{}
+	if adminErr := m.rpc.call("admin_peers", &peers); adminErr != nil {
+		return 0, err
+	}
+	return len(peers), nil
+}
+
+// checkPeers returns the peer count, or -1 if it is unknown.
+func (m *monitor) checkPeers() int {
+	peers, err := m.peerCount()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting peer count: %v\n", err)
+		return -1
+	}
+
+	now := time.Now()
+	if peers >= m.minPeers {
+		if m.lowPeers {
+			message := fmt.Sprintf("Peer count recovered to %d after %s below %d", peers, now.Sub(m.lowPeersSince).Round(time.Second), m.minPeers)
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("peers-recovered", "INFO", message)
+		}
+		m.lowPeersSince = time.Time{}
+		m.lowPeers = false
+		return peers
+	}
+	if m.lowPeersSince.IsZero() {
+		m.lowPeersSince = now
+	}
+	if !m.lowPeers && now.Sub(m.lowPeersSince) >= m.lowPeersAfter {
+		m.lowPeers = true
+		message := fmt.Sprintf("Peer count has been below %d for %s, now %d", m.minPeers, now.Sub(m.lowPeersSince).Round(time.Second), peers)
+		fmt.Fprintln(os.Stderr, message)
+		m.pipeline.Event("low-peers", "WARNING", message)
+	}
+	return peers
+}
+
+// batchNumbers are the zkEVM batch heights of the node.
+type batchNumbers struct {
+	Latest   uint64 `json:"latest"`
+	Virtual  uint64 `json:"virtual"`
+	Verified uint64 `json:"verified"`
+}
+
+// checkBatchLag returns the node's batch numbers, or nil if they are unknown.
+func (m *monitor) checkBatchLag() *batchNumbers {
+	var b batchNumbers
+	var err error
+	if b.Latest, err = m.rpc.callUint64("zkevm_batchNumber"); err == nil {
+		if b.Virtual, err = m.rpc.callUint64("zkevm_virtualBatchNumber"); err == nil {
+			b.Verified, err = m.rpc.callUint64("zkevm_verifiedBatchNumber")
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting batch numbers: %v\n", err)
+		return nil
+	}
+
+	m.checkLag(&m.virtualLagging, "virtual", b.Latest, b.Virtual, m.maxVirtualLag)
+	m.checkLag(&m.verifiedLagging, "verified", b.Latest, b.Verified, m.maxVerifiedLag)
+	return &b
+}
+
+// checkLag alerts when the kind batch number falls more than max behind the
+// latest one, and again once it caught up.
+func (m *monitor) checkLag(lagging *bool, kind string, latest, batch, max uint64) {
+	if max == 0 {
+		return
+	}
+	lag := lag(latest, batch)
+	switch {
+	case lag > max && !*lagging:
+		*lagging = true
+		message := fmt.Sprintf("The %s batch %d is %d batches behind the latest batch %d (threshold %d)", kind, batch, lag, latest, max)
+		fmt.Fprintln(os.Stderr, message)
+		m.pipeline.Event(kind+"-batch-lag", "WARNING", message)
+	case lag <= max && *lagging:
+		*lagging = false
+		message := fmt.Sprintf("The %s batch %d caught up to within %d batches of the latest batch %d", kind, batch, lag, latest)
+		fmt.Fprintln(os.Stderr, message)
+		m.pipeline.Event(kind+"-batch-lag-recovered", "INFO", message)
+	}
+}
+
+// checkTxPool returns the node's transaction pool counts, or nil if they are
+// unknown. A sequencer that stopped building batches leaves transactions
+// piling up in its pool.
+func (m *monitor) checkTxPool() *txPoolStatus {
+	pool, err := m.rpc.txPoolStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting txpool status: %v\n", err)
+		return nil
+	}
+
+	if m.maxPending > 0 {
+		switch {
+		case pool.Pending > m.maxPending && !m.backlogged:
+			m.backlogged = true
+			message := fmt.Sprintf("%d transactions are pending in the txpool, above the threshold of %d (%d queued)", pool.Pending, m.maxPending, pool.Queued)
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("txpool-backlog", "WARNING", message)
+		case pool.Pending <= m.maxPending && m.backlogged:
+			m.backlogged = false
+			message := fmt.Sprintf("Pending transactions in the txpool are down to %d, within the threshold of %d", pool.Pending, m.maxPending)
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("txpool-backlog-resolved", "INFO", message)
+		}
+	}
+
+	if m.pendingGrowthAfter > 0 {
+		now := time.Now()
+		switch {
+		case pool.Pending < m.pending || pool.Pending == 0:
+			// Transactions are taken out of the pool.
+			if m.pendingGrowth {
+				message := fmt.Sprintf("Pending transactions in the txpool dropped to %d after growing for %s", pool.Pending, now.Sub(m.growingSince).Round(time.Second))
+				fmt.Fprintln(os.Stderr, message)
+				m.pipeline.Event("txpool-draining", "INFO", message)
+			}
+			m.growingSince = time.Time{}
+			m.pendingGrowth = false
+		case pool.Pending > m.pending && m.growingSince.IsZero():
+			m.growingSince = now
+		}
+		if !m.growingSince.IsZero() && !m.pendingGrowth && now.Sub(m.growingSince) >= m.pendingGrowthAfter {
+			m.pendingGrowth = true
+			message := fmt.Sprintf("Pending transactions in the txpool have kept growing for %s, now %d; the sequencer may have stopped building batches", now.Sub(m.growingSince).Round(time.Second), pool.Pending)
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("txpool-growing", "CRITICAL", message)
+		}
+		m.pending = pool.Pending
+	}
+	return pool
+}
+
+// feeQuotes are the fees in wei the node returns per method.
+type feeQuotes map[string]uint64
+
+// checkFees returns the fees the node quotes, alerting when one dropped to
+// zero, usually a broken fee oracle, or left its bounds, and again once it is
+// back within them.
+func (m *monitor) checkFees() feeQuotes {
+	if m.feeProblems == nil {
+		m.feeProblems = make(map[string]string)
+	}
+	fees := make(feeQuotes, len(m.feeMethods))
+	for _, method := range m.feeMethods {
+		fee, err := m.rpc.callUint64(method)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting %s: %v\n", method, err)
+			continue
+		}
+		fees[method] = fee
+
+		var problem, severity string
+		switch {
+		case fee == 0:
+			problem, severity = "is zero", "CRITICAL"
+		case fee < m.minFee:
+			problem, severity = fmt.Sprintf("is %s, below %s", gwei(fee), gwei(m.minFee)), "WARNING"
+		case m.maxFee > 0 && fee > m.maxFee:
+			problem, severity = fmt.Sprintf("is %s, above %s", gwei(fee), gwei(m.maxFee)), "WARNING"
+		}
+		previous := m.feeProblems[method]
+		switch {
+		case problem != "" && previous == "":
+			m.feeProblems[method] = problem
+			message := fmt.Sprintf("The fee %s returns %s", method, problem)
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("fee-out-of-bounds", severity, message)
+		case problem == "" && previous != "":
+			delete(m.feeProblems, method)
+			message := fmt.Sprintf("The fee %s returns is back within bounds at %s", method, gwei(fee))
+			fmt.Fprintln(os.Stderr, message)
+			m.pipeline.Event("fee-recovered", "INFO", message)
+		}
+	}
+	return fees
+}
+
+// gwei formats a fee in wei.
+func gwei(wei uint64) string {
+	return fmt.Sprintf("%.9g gwei", float64(wei)/1e9)
+}
+
+// checkDivergence compares the block hash at the highest height both the node
+// and the reference have, alerting as soon as they differ.
+func (m *monitor) checkDivergence(head uint64) {
+	refHead, err := m.reference.callUint64("eth_blockNumber")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error querying reference RPC: %v\n", err)
+		return
+	}
+	height := head
+	if refHead < height {
+		height = refHead
+	}
+	hash, err := m.rpc.blockHash(height)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting block %d: %v\n", height, err)
+		return
+	}
+	refHash, err := m.reference.blockHash(height)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting block %d from reference RPC: %v\n", height, err)
+		return
+	}
+	if hash == "" || refHash == "" {
+		return
+	}
+
+	switch {
+	case hash != refHash && !m.diverged:
+		m.diverged = true
+		message := fmt.Sprintf("Block %d diverged from the reference RPC %s: local hash %s, reference hash %s", height, m.reference.url, hash, refHash)
+		fmt.Fprintln(os.Stderr, message)
+		m.pipeline.Event("head-divergence", "CRITICAL", message)
+	case hash == refHash && m.diverged:
+		m.diverged = false
+		message := fmt.Sprintf("Block %d matches the reference RPC %s again", height, m.reference.url)
+		fmt.Fprintln(os.Stderr, message)
+		m.pipeline.Event("head-divergence-resolved", "INFO", message)
+	}
+}
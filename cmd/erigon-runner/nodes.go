@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// nodeConfig defines one cdk-erigon instance in the runner config. Empty
+// fields fall back to the command-line flags.
+type nodeConfig struct {
+	Name         string   `json:"name"`
+	ErigonConfig string   `json:"erigonConfig"`
+	Chain        string   `json:"chain"`
+	Datadir      string   `json:"datadir"`
+	RPCURL       string   `json:"rpcURL"`
+	Datastream   string   `json:"datastream"`
+	PortOffset   int      `json:"portOffset"`
+	ChainID      uint64   `json:"chainId"`
+	Args         []string `json:"args"`
+	// Ref runs another branch, tag or commit of cdk-erigon than -repo,
+	// built in a worktree next to it.
+	Ref string `json:"ref"`
+	// Hooks replace the runner's hooks of the same event for this node.
+	Hooks hookSet `json:"hooks"`
+}
+
+// runnerConfig holds the runner's own settings, which live next to the
+// alerting settings in the config file.
+type runnerConfig struct {
+	Nodes []nodeConfig `json:"nodes"`
+	Hooks hookSet      `json:"hooks"`
+}
+
+func readRunnerConfig(path string) (*runnerConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	var config runnerConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := config.Hooks.validate(); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i, node := range config.Nodes {
+		if node.Name == "" {
+			return nil, fmt.Errorf("node %d has no name", i)
+		}
+		if names[node.Name] {
+			return nil, fmt.Errorf("duplicate node name %s", node.Name)
+		}
+		names[node.Name] = true
+		if err := node.Hooks.validate(); err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.Name, err)
+		}
+	}
+	return &config, nil
+}
+
+// nodeAlertConfig returns the alerting config of the named node and whether
+// it is its own: the service section of the same name if there is one, or
+// else the top level config. The control API and files of the top level
+// config can't be shared, so they are dropped once already inherited.
+func nodeAlertConfig(config *alerting.Config, name string, inherited bool) (*alerting.Config, bool) {
+	for _, sc := range config.Services {
+		if sc.Name == name {
+			selected := sc.Config
+			return &selected, true
+		}
+	}
+	selected := *config
+	selected.Services = nil
+	if inherited {
+		selected.ControlAddr = ""
+		selected.LogFile = ""
+		selected.HistoryFile = ""
+		selected.Patterns = append([]alerting.PatternConfig(nil), config.Patterns...)
+		for i := range selected.Patterns {
+			selected.Patterns[i].OutputFile = ""
+		}
+	}
+	return &selected, false
+}
+
+// node is a supervised cdk-erigon instance with its own alerting.
+type node struct {
+	name       string
+	pipeline   *alerting.Pipeline
+	alerts     *alertCounter
+	supervisor *supervisor
+	rpc        *rpcClient
+	datadir    string
+	watcher    *datastreamWatcher
+	chainID    uint64
+	pprofURL   string
+	// image is the docker image the node runs, if any.
+	image string
+	// version is the cdk-erigon version last detected.
+	version string
+}
+
+// run supervises the node until it is stopped or gives up.
+func (n *node) run(statusAddr string) error {
+	err := n.supervisor.run()
+	if errors.Is(err, errCrashLoop) && statusAddr != "" {
+		// Stay up so the failure can be inspected through the status API.
+		fmt.Fprintf(os.Stderr, "%v; status API remains available on %s until interrupted\n", err, statusAddr)
+		<-n.supervisor.stop
+	} else if err != nil && !errors.Is(err, errCrashLoop) && !errors.Is(err, errSmokeTests) {
+		n.pipeline.Event("stopped", "CRITICAL", err.Error())
+	}
+	if err != nil && n.name != "" {
+		err = fmt.Errorf("node %s: %w", n.name, err)
+	}
+	return err
+}
+
+// nodePrefix tags a message about a named node.
+func nodePrefix(name string) string {
+	if name == "" {
+		return ""
+	}
+	return "node " + name + " "
+}
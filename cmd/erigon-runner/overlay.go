@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// topLevelKey matches the first line of a top level setting.
+var topLevelKey = regexp.MustCompile(`^(["']?)([^\s"'#:][^"':]*)(["']?)\s*:`)
+
+// readOverrides reads an overrides file, keeping the order of its settings.
+func readOverrides(path string) (yaml.MapSlice, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides file %s: %w", path, err)
+	}
+	expanded, err := expandEnv(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overrides file %s: %w", path, err)
+	}
+	var overrides yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(expanded), &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse overrides file %s: %w", path, err)
+	}
+	return overrides, nil
+}
+
+// mergeOverrides merges overrides into the config content. Maps are merged
+// key by key, anything else is replaced. Only the overridden settings are
+// rewritten, so the rest of the file keeps its comments and formatting.
+func mergeOverrides(content string, overrides yaml.MapSlice) (string, error) {
+	var base map[string]interface{}
+	if err := yaml.Unmarshal([]byte(content), &base); err != nil {
+		return "", err
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	lines := strings.SplitAfter(content, "\n")
+	lines = lines[:len(lines)-1]
+
+	for _, item := range overrides {
+		key, ok := item.Key.(string)
+		if !ok {
+			return "", fmt.Errorf("override key %v is not a string", item.Key)
+		}
+		value := item.Value
+		if current, ok := base[key]; ok {
+			value = mergeValue(current, value)
+		}
+		setting, err := yaml.Marshal(yaml.MapSlice{{Key: key, Value: value}})
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal override %s: %w", key, err)
+		}
+
+		start, end := settingLines(lines, key)
+		if start < 0 {
+			lines = append(lines, string(setting))
+			continue
+		}
+		lines = append(lines[:start], append([]string{string(setting)}, lines[end:]...)...)
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// settingLines returns the range of lines holding the top level setting key,
+// or -1 if it isn't set.
+func settingLines(lines []string, key string) (int, int) {
+	for i, line := range lines {
+		m := topLevelKey.FindStringSubmatch(line)
+		if m == nil || strings.TrimSpace(m[2]) != key {
+			continue
+		}
+		end := i + 1
+		for end < len(lines) && continuesSetting(lines[end]) {
+			end++
+		}
+		return i, end
+	}
+	return -1, -1
+}
+
+// continuesSetting reports whether line belongs to the setting above it, i.e.
+// is indented or a list item.
+func continuesSetting(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return false
+	}
+	return line[0] == ' ' || line[0] == '\t' || strings.HasPrefix(trimmed, "- ") || trimmed == "-"
+}
+
+// mergeValue deep-merges override into base when both are maps.
+func mergeValue(base, override interface{}) interface{} {
+	baseMap, ok := base.(map[interface{}]interface{})
+	if !ok {
+		return override
+	}
+	merged := make(map[interface{}]interface{}, len(baseMap))
+	for k, v := range baseMap {
+		merged[k] = v
+	}
+	switch o := override.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range o {
+			merged[k] = mergeValue(merged[k], v)
+		}
+	case yaml.MapSlice:
+		for _, item := range o {
+			merged[item.Key] = mergeValue(merged[item.Key], item.Value)
+		}
+	default:
+		return override
+	}
+	return merged
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Port scanning and configuration updating
+
+// portKeys are the cdk-erigon settings holding ports. Values may be a number,
+// a string of comma separated numbers or a list.
+var portKeys = []string{
+	"port",
+	"p2p.allowed-ports",
+	"http.port",
+	"ws.port",
+	"authrpc.port",
+	"torrent.port",
+	"metrics.port",
+	"pprof.port",
+	"zkevm.data-stream-port",
+}
+
+var (
+	portKeyLine  = regexp.MustCompile(`^(["']?)([\w.-]+)(["']?)(\s*:)(.*)$`)
+	listItemLine = regexp.MustCompile(`^\s*-\s`)
+	portNumber   = regexp.MustCompile(`\b\d+\b`)
+)
+
+func isPortKey(key string) bool {
+	for _, k := range portKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePorts returns the ports of a config value.
+func parsePorts(value interface{}) ([]int, error) {
+	switch v := value.(type) {
+	case int:
+		return []int{v}, nil
+	case string:
+		var ports []int
+		for _, number := range portNumber.FindAllString(v, -1) {
+			port, err := strconv.Atoi(number)
+			if err != nil {
+				return nil, err
+			}
+			ports = append(ports, port)
+		}
+		return ports, nil
+	case []interface{}:
+		var ports []int
+		for _, item := range v {
+			itemPorts, err := parsePorts(item)
+			if err != nil {
+				return nil, err
+			}
+			ports = append(ports, itemPorts...)
+		}
+		return ports, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// portLockFile keeps the ports picked for a node in its datadir.
+const portLockFile = "erigon-runner-ports.json"
+
+// portAllocator picks the ports of a node: by scanning upwards for a free port
+// or, with an offset, by shifting every port by it. Ports already given to
+// other nodes are reserved, and ports outside the range, if set, are refused.
+type portAllocator struct {
+	offset   int
+	low      int
+	high     int
+	reserved map[int]bool
+	// locked maps the configured ports of each key to the ones picked on the
+	// last start, which are reused when still free.
+	locked map[string]map[int]int
+	// adopting reuses the locked ports although they are taken, by the
+	// running node that is adopted.
+	adopting bool
+}
+
+// parsePortRange parses a LOW-HIGH port range.
+func parsePortRange(value string) (int, int, error) {
+	lowStr, highStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range %q, want LOW-HIGH", value)
+	}
+	low, err := strconv.Atoi(strings.TrimSpace(lowStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", value, err)
+	}
+	high, err := strconv.Atoi(strings.TrimSpace(highStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", value, err)
+	}
+	if low < 1 || high > 65535 || low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q", value)
+	}
+	return low, high, nil
+}
+
+func (a *portAllocator) inRange(port int) bool {
+	if a.high == 0 {
+		return port >= 1 && port <= 65535
+	}
+	return port >= a.low && port <= a.high
+}
+
+// allocateKey returns and reserves the port to use instead of port of key,
+// preferring the locked one.
+func (a *portAllocator) allocateKey(key string, port int) (int, error) {
+	if locked, ok := a.locked[key][port]; ok && a.adopting {
+		a.reserved[locked] = true
+		return locked, nil
+	}
+	if locked, ok := a.locked[key][port]; ok && a.offset == 0 {
+		if a.inRange(locked) && !a.reserved[locked] && portFree(locked) {
+			a.reserved[locked] = true
+			return locked, nil
+		}
+		fmt.Fprintf(os.Stderr, "Locked port %d of %s is taken, picking another\n", locked, key)
+	}
+	return a.allocate(port)
+}
+
+// allocate returns and reserves the port to use instead of port.
+func (a *portAllocator) allocate(port int) (int, error) {
+	if a.offset != 0 {
+		shifted := port + a.offset
+		if !a.inRange(shifted) {
+			return 0, fmt.Errorf("port %d shifted by %d is out of range", port, a.offset)
+		}
+		if a.reserved[shifted] || !portFree(shifted) {
+			return 0, fmt.Errorf("port %d shifted by %d is in use", port, a.offset)
+		}
+		a.reserved[shifted] = true
+		return shifted, nil
+	}
+
+	if a.high != 0 && port < a.low {
+		port = a.low
+	}
+	for ; a.inRange(port); port++ {
+		if !a.reserved[port] && portFree(port) {
+			a.reserved[port] = true
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free port left in range %d-%d", a.low, a.high)
+}
+
+func portFree(port int) bool {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// extractPorts returns the ports set by the known port keys of config.
+func extractPorts(config map[string]interface{}) (map[string][]int, error) {
+	ports := make(map[string][]int)
+	for key, value := range config {
+		if !isPortKey(key) {
+			continue
+		}
+		keyPorts, err := parsePorts(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if len(keyPorts) > 0 {
+			ports[key] = keyPorts
+		}
+	}
+	return ports, nil
+}
+
+// updateConfig writes content, the expanded YAML or TOML config of configFile,
+// to a copy next to it with every port moved to the one picked by alloc. Only the port
+// numbers are edited, so comments and formatting are kept; as the copy may
+// hold secrets, only the owner can read it. Copies for named nodes carry the
+// name so nodes sharing a config don't overwrite each other's.
+func updateConfig(configFile, content string, ports map[string][]int, name string, alloc *portAllocator) (string, map[string][]int, error) {
+	var err error
+	// A port used by several keys, like port and p2p.allowed-ports, keeps
+	// being shared.
+	moved := make(map[int]int)
+	newPorts := make(map[string][]int)
+	for _, key := range portKeys {
+		for _, port := range ports[key] {
+			newPort, ok := moved[port]
+			if !ok {
+				if newPort, err = alloc.allocateKey(key, port); err != nil {
+					return "", nil, fmt.Errorf("failed to move %s: %w", key, err)
+				}
+				moved[port] = newPort
+			}
+			newPorts[key] = append(newPorts[key], newPort)
+		}
+		if len(ports[key]) > 0 {
+			fmt.Printf("Updated %s to %s\n", key, joinPorts(newPorts[key]))
+		}
+	}
+
+	rewrite := rewritePorts
+	if isTOML(configFile) {
+		rewrite = rewriteTOMLPorts
+	}
+	newContent, err := rewrite(content, newPorts)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to update %s: %w", configFile, err)
+	}
+
+	// Make sure the edit changed exactly what it was meant to.
+	written, err := unmarshalConfig(configFile, newContent)
+	if err != nil {
+		return "", nil, fmt.Errorf("rewritten config of %s is invalid: %w", configFile, err)
+	}
+	writtenPorts, err := extractPorts(written)
+	if err != nil {
+		return "", nil, err
+	}
+	if !reflect.DeepEqual(writtenPorts, newPorts) {
+		return "", nil, fmt.Errorf("rewritten config of %s has ports %v, want %v", configFile, writtenPorts, newPorts)
+	}
+
+	suffix := "_new"
+	if name != "" {
+		suffix = "_" + name + suffix
+	}
+	newConfigFile := configFile[:len(configFile)-len(filepath.Ext(configFile))] + suffix + filepath.Ext(configFile)
+	os.Remove(newConfigFile) // a leftover copy would keep its permissions
+	if err := os.WriteFile(newConfigFile, []byte(newContent), 0600); err != nil {
+		return "", nil, fmt.Errorf("failed to write new config file: %w", err)
+	}
+	return newConfigFile, newPorts, nil
+}
+
+func readPortLock(datadir string) (map[string]map[int]int, error) {
+	content, err := os.ReadFile(filepath.Join(datadir, portLockFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read port lockfile: %w", err)
+	}
+	var locked map[string]map[int]int
+	if err := json.Unmarshal(content, &locked); err != nil {
+		return nil, fmt.Errorf("failed to parse port lockfile %s: %w", filepath.Join(datadir, portLockFile), err)
+	}
+	return locked, nil
+}
+
+// writePortLock records which port was picked for each configured one.
+func writePortLock(datadir string, original, picked map[string][]int) error {
+	locked := make(map[string]map[int]int, len(picked))
+	for key, ports := range picked {
+		locked[key] = make(map[int]int, len(ports))
+		for i, port := range ports {
+			locked[key][original[key][i]] = port
+		}
+	}
+	content, err := json.MarshalIndent(locked, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(datadir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(datadir, portLockFile), append(content, '\n'), 0644)
+}
+
+// rewritePorts replaces, in order, the port numbers of the top level keys in
+// ports, whether inline or in a block list below the key.
+func rewritePorts(content string, ports map[string][]int) (string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	remaining := make(map[string][]int, len(ports))
+	for key, keyPorts := range ports {
+		remaining[key] = keyPorts
+	}
+	for i := 0; i < len(lines); i++ {
+		m := portKeyLine.FindStringSubmatch(strings.TrimRight(lines[i], "\r\n"))
+		if m == nil {
+			continue
+		}
+		key := m[2]
+		keyPorts, ok := remaining[key]
+		if !ok {
+			continue
+		}
+		value, rest := splitComment(m[5])
+		value, keyPorts = replacePorts(value, keyPorts)
+		lines[i] = m[1] + key + m[3] + m[4] + value + rest + lineEnding(lines[i])
+		for i+1 < len(lines) && len(keyPorts) > 0 && listItemLine.MatchString(lines[i+1]) {
+			i++
+			item, rest := splitComment(strings.TrimRight(lines[i], "\r\n"))
+			item, keyPorts = replacePorts(item, keyPorts)
+			lines[i] = item + rest + lineEnding(lines[i])
+		}
+		if len(keyPorts) > 0 {
+			return "", fmt.Errorf("%s has fewer ports than expected", key)
+		}
+		delete(remaining, key)
+	}
+	for key := range remaining {
+		return "", fmt.Errorf("%s not found", key)
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// replacePorts replaces the port numbers in value with the first of ports
+// and returns the ports left over.
+func replacePorts(value string, ports []int) (string, []int) {
+	value = portNumber.ReplaceAllStringFunc(value, func(match string) string {
+		if len(ports) == 0 {
+			return match
+		}
+		port := ports[0]
+		ports = ports[1:]
+		return strconv.Itoa(port)
+	})
+	return value, ports
+}
+
+// splitComment splits a YAML value from a trailing comment, ignoring # within
+// quotes.
+func splitComment(value string) (string, string) {
+	var quote rune
+	for i, c := range value {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || value[i-1] == ' ' || value[i-1] == '\t'):
+			return value[:i], value[i:]
+		}
+	}
+	return value, ""
+}
+
+func lineEnding(line string) string {
+	if strings.HasSuffix(line, "\r\n") {
+		return "\r\n"
+	}
+	if strings.HasSuffix(line, "\n") {
+		return "\n"
+	}
+	return ""
+}
+
+func joinPorts(ports []int) string {
+	s := make([]string, len(ports))
+	for i, port := range ports {
+		s[i] = strconv.Itoa(port)
+	}
+	return strings.Join(s, ", ")
+}
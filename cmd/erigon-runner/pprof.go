@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// defaultPprofPort is cdk-erigon's pprof port when the config doesn't set one.
+const defaultPprofPort = "6060"
+
+// profiler counts the error lines of the child and, when more than threshold
+// of them occur within window, saves goroutine, heap and CPU profiles from
+// the node's pprof endpoint and alerts with where to find them.
+type profiler struct {
+	name       string
+	url        string
+	dir        string
+	pipeline   *alerting.Pipeline
+	client     *http.Client
+	threshold  int
+	window     time.Duration
+	cooldown   time.Duration
+	cpuSeconds int
+	// linkBase, when set, is the URL the profiles directory is served at.
+	linkBase string
+
+	mu        sync.Mutex
+	errors    []time.Time
+	captured  time.Time
+	capturing bool
+}
+
+// observe is a supervisor observer counting lines logged at ERROR or above.
+func (p *profiler) observe(line string) {
+	if alerting.ExtractLevel(line) < alerting.LevelError {
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errors = append(p.errors, now)
+	for len(p.errors) > 0 && now.Sub(p.errors[0]) > p.window {
+		p.errors = p.errors[1:]
+	}
+	if len(p.errors) <= p.threshold || p.capturing || now.Sub(p.captured) < p.cooldown {
+		return
+	}
+	p.capturing = true
+	p.captured = now
+	go p.capture(len(p.errors), now)
+}
+
+func (p *profiler) capture(count int, at time.Time) {
+	defer func() {
+		p.mu.Lock()
+		p.capturing = false
+		p.mu.Unlock()
+	}()
+
+	name := at.UTC().Format("20060102T150405Z")
+	if p.name != "" {
+		name = p.name + "-" + name
+	}
+	dir := filepath.Join(p.dir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating profile directory: %v\n", err)
+		return
+	}
+	profiles := []struct{ file, path string }{
+		{"goroutine.txt", "/debug/pprof/goroutine?debug=2"},
+		{"heap.pb.gz", "/debug/pprof/heap"},
+		{"cpu.pb.gz", fmt.Sprintf("/debug/pprof/profile?seconds=%d", p.cpuSeconds)},
+	}
+	var saved, failed []string
+	for _, profile := range profiles {
+		if err := p.fetch(profile.path, filepath.Join(dir, profile.file)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error capturing %s: %v\n", profile.file, err)
+			failed = append(failed, profile.file)
+			continue
+		}
+		location := filepath.Join(dir, profile.file)
+		if p.linkBase != "" {
+			location = p.linkBase + name + "/" + profile.file
+		}
+		saved = append(saved, location)
+	}
+	if len(saved) == 0 {
+		os.Remove(dir)
+		message := fmt.Sprintf("%d error lines within %s, but no profiles could be captured from %s", count, p.window, p.url)
+		fmt.Fprintln(os.Stderr, message)
+		p.pipeline.Event("error-spike", "WARNING", message)
+		return
+	}
+
+	message := fmt.Sprintf("%d error lines within %s, captured profiles:\n%s", count, p.window, strings.Join(saved, "\n"))
+	if len(failed) > 0 {
+		message += "\nFailed to capture: " + strings.Join(failed, ", ")
+	}
+	fmt.Fprintln(os.Stderr, message)
+	p.pipeline.Event("error-spike", "WARNING", message)
+}
+
+// fetch saves the pprof endpoint path to file.
+func (p *profiler) fetch(path, file string) error {
+	resp, err := p.client.Get(p.url + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		os.Remove(file)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat (USER_HZ),
+// which is 100 on all common Linux platforms.
+const clockTicks = 100
+
+// readProcessStats samples the resource usage of pid from /proc.
+func readProcessStats(pid int) (processStats, error) {
+	var stats processStats
+
+	rss, err := procField(fmt.Sprintf("/proc/%d/status", pid), "VmRSS:")
+	if err != nil {
+		return stats, err
+	}
+	stats.RSS = rss * 1024
+
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return stats, err
+	}
+	// The command name may contain spaces, so fields are counted from its
+	// closing parenthesis; utime and stime are fields 14 and 15.
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) < 13 {
+		return stats, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	utime, err1 := strconv.ParseUint(fields[11], 10, 64)
+	stime, err2 := strconv.ParseUint(fields[12], 10, 64)
+	if err1 != nil || err2 != nil {
+		return stats, fmt.Errorf("unexpected /proc/%d/stat format", pid)
+	}
+	stats.CPUSeconds = float64(utime+stime) / clockTicks
+
+	fds, err := os.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+	if err != nil {
+		return stats, err
+	}
+	stats.OpenFDs = len(fds)
+	stats.MaxFDs = maxOpenFiles(pid)
+	return stats, nil
+}
+
+// maxOpenFiles returns the soft limit on open files of pid, or 0 if it is
+// unlimited or unknown.
+func maxOpenFiles(pid int) uint64 {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/limits", pid))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			return 0
+		}
+		limit, _ := strconv.ParseUint(fields[0], 10, 64)
+		return limit
+	}
+	return 0
+}
+
+// totalMemory returns the RAM of the machine in bytes.
+func totalMemory() (uint64, error) {
+	kb, err := procField("/proc/meminfo", "MemTotal:")
+	return kb * 1024, err
+}
+
+// procField returns the number following name in a /proc file of
+// "name value kB" lines.
+func procField(path, name string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == name {
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s %q in %s", name, fields[1], path)
+			}
+			return value, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no %s in %s", name, path)
+}
+
+// processCmdline returns the command line of pid, its arguments separated by
+// spaces.
+func processCmdline(pid int) (string, error) {
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " ")), nil
+}
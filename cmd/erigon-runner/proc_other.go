@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errNoProcStats = errors.New("process statistics are only available on Linux")
+
+func readProcessStats(pid int) (processStats, error) {
+	return processStats{}, errNoProcStats
+}
+
+func totalMemory() (uint64, error) {
+	return 0, errNoProcStats
+}
+
+func processCmdline(pid int) (string, error) {
+	return "", errNoProcStats
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import "os/exec"
+
+func isolateChild(cmd *exec.Cmd) {}
+
+func killChild(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+// processAlive is not implemented on Windows, so nothing is adopted.
+func processAlive(pid int) bool {
+	return false
+}
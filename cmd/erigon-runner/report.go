@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// issueLogLines is how many log lines an issue report quotes.
+const issueLogLines = 100
+
+// maxIssueBody stays below GitHub's limit on the length of an issue body.
+const maxIssueBody = 60000
+
+var (
+	// secretKey matches the config settings whose values are left out of
+	// reports.
+	secretKey = regexp.MustCompile(`(?i)(secret|password|passwd|token|private|mnemonic|apikey|api-key|credential)`)
+	// configLine splits a YAML or TOML setting into key, separator and value.
+	configLine = regexp.MustCompile(`^(\s*["']?[\w.-]+["']?\s*[:=])(.*)$`)
+	urlValue   = regexp.MustCompile(`\w+://[^\s"']+`)
+)
+
+// issueReporter writes a markdown bug report when a fatal pattern matches and,
+// with a repository and token, opens it as a GitHub issue. Every pattern is
+// reported once per version.
+type issueReporter struct {
+	dir        string
+	supervisor *supervisor
+	name       string
+	// fatal are the patterns that are reported.
+	fatal map[string]bool
+
+	// githubRepo is the owner/name the issues are opened in; empty only
+	// writes the reports.
+	githubRepo  string
+	githubToken string
+	dryRun      bool
+	client      *http.Client
+
+	mu       sync.Mutex
+	reported map[string]bool
+}
+
+// fatalPatterns returns the patterns of config whose severity is one of
+// severities.
+func fatalPatterns(config *alerting.Config, severities []string) map[string]bool {
+	fatal := make(map[string]bool)
+	for _, p := range config.Patterns {
+		for _, severity := range severities {
+			if strings.EqualFold(p.Severity, strings.TrimSpace(severity)) {
+				fatal[p.Pattern] = true
+			}
+		}
+	}
+	return fatal
+}
+
+// observe is the pipeline's alert callback.
+func (r *issueReporter) observe(a alerting.Alert) {
+	if !r.fatal[a.Pattern] {
+		return
+	}
+	version := a.Metadata[versionMetadata]
+	r.mu.Lock()
+	key := a.Pattern + "|" + version
+	seen := r.reported[key]
+	r.reported[key] = true
+	count := len(r.reported)
+	r.mu.Unlock()
+	if seen {
+		return
+	}
+
+	title, body := r.report(a, version)
+	path, err := r.write(a, body, count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%sError writing issue report: %v\n", nodePrefix(r.name), err)
+	} else {
+		fmt.Fprintf(os.Stderr, "%sWrote issue report %s\n", nodePrefix(r.name), path)
+	}
+	if r.githubRepo == "" || r.dryRun {
+		return
+	}
+	go func() {
+		url, err := r.openIssue(title, body)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%sError opening issue: %v\n", nodePrefix(r.name), err)
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%sOpened issue %s\n", nodePrefix(r.name), url)
+	}()
+}
+
+// report renders the title and markdown body of the report of a.
+func (r *issueReporter) report(a alerting.Alert, version string) (string, string) {
+	if version == "" {
+		version = "unknown"
+	}
+	summary := strings.SplitN(strings.TrimSpace(a.Log), "\n", 2)[0]
+	if len(summary) > 120 {
+		summary = summary[:120] + "..."
+	}
+	title := fmt.Sprintf("%s: %s", version, summary)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "## What happened\n\n")
+	fmt.Fprintf(&b, "The pattern `%s` (%s) matched at %s:\n\n", a.Pattern, a.Severity, a.Time.Format(time.RFC3339))
+	fmt.Fprintf(&b, "```\n%s\n```\n\n", a.Log)
+	fmt.Fprintf(&b, "## Version\n\n%s\n\n", version)
+
+	status := r.supervisor.Status()
+	fmt.Fprintf(&b, "## Node\n\n")
+	fmt.Fprintf(&b, "- Command: `%s`\n", urlValue.ReplaceAllStringFunc(shellCommand(r.supervisor.binary, r.supervisor.args), redactURL))
+	if status.Started != nil {
+		fmt.Fprintf(&b, "- Running since: %s\n", status.Started.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&b, "- Restarts: %d\n", status.Restarts)
+	if status.Stage != "" {
+		fmt.Fprintf(&b, "- Stage: %s\n", status.Stage)
+	}
+	if status.Head != 0 {
+		fmt.Fprintf(&b, "- Head: %d\n", status.Head)
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(&b, "## Host\n\n")
+	fmt.Fprintf(&b, "- OS: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		fmt.Fprintf(&b, "- Kernel: %s\n", strings.TrimSpace(string(release)))
+	}
+	fmt.Fprintf(&b, "- CPUs: %d\n", runtime.NumCPU())
+	if res := status.Resources; res != nil {
+		fmt.Fprintf(&b, "- Memory of the node: %.1f GB, CPU %.0f%%, %d open files\n", float64(res.RSS)/gb, res.CPUPercent, res.OpenFDs)
+	}
+	if status.DiskFree > 0 {
+		fmt.Fprintf(&b, "- Free disk: %.1f GB\n", float64(status.DiskFree)/gb)
+	}
+	b.WriteString("\n")
+
+	for _, arg := range r.supervisor.args {
+		configFile := strings.TrimPrefix(arg, "--config=")
+		if configFile == arg {
+			continue
+		}
+		if content, err := os.ReadFile(configFile); err == nil {
+			fmt.Fprintf(&b, "## Config\n\nSecrets and URLs are redacted.\n\n```\n%s\n```\n\n", strings.TrimRight(redactConfig(string(content)), "\n"))
+		}
+	}
+
+	fmt.Fprintf(&b, "## Last %d log lines\n\n```\n%s\n```\n", issueLogLines, strings.Join(r.supervisor.tail.Lines(issueLogLines), "\n"))
+	body := b.String()
+	if len(body) > maxIssueBody {
+		body = body[:maxIssueBody] + "\n```\n\n(truncated)\n"
+	}
+	return title, body
+}
+
+// redactConfig blanks the values of secret settings and reduces URLs, which
+// may carry API keys, to their scheme and host.
+func redactConfig(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		m := configLine.FindStringSubmatch(line)
+		switch {
+		case m != nil && secretKey.MatchString(m[1]):
+			lines[i] = m[1] + " <redacted>"
+		default:
+			lines[i] = urlValue.ReplaceAllStringFunc(line, redactURL)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// write saves the count-th report of the run in the report directory.
+func (r *issueReporter) write(a alerting.Alert, body string, count int) (string, error) {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("issue-%s-%d.md", a.Time.UTC().Format("20060102T150405Z"), count)
+	if r.name != "" {
+		name = r.name + "-" + name
+	}
+	path := filepath.Join(r.dir, name)
+	return path, os.WriteFile(path, []byte(body), 0600)
+}
+
+// openIssue opens the report as an issue and returns its URL.
+func (r *issueReporter) openIssue(title, body string) (string, error) {
+	payload, err := json.Marshal(map[string]string{"title": title, "body": body})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://api.github.com/repos/"+r.githubRepo+"/issues", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+r.githubToken)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var issue struct {
+		HTMLURL string `json:"html_url"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&issue)
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("GitHub returned HTTP %s: %s", resp.Status, issue.Message)
+	}
+	return issue.HTMLURL, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// processStats is a sample of the child's resource usage.
+type processStats struct {
+	RSS        uint64  `json:"rss"`
+	CPUSeconds float64 `json:"cpuSeconds"`
+	CPUPercent float64 `json:"cpuPercent"`
+	OpenFDs    int     `json:"openFDs"`
+	MaxFDs     uint64  `json:"maxFDs,omitempty"`
+}
+
+// resourceMonitor samples the child's resource usage and alerts when it
+// crosses the thresholds, and again once it is back below them. Thresholds of
+// 0 disable the respective check.
+type resourceMonitor struct {
+	pipeline   *alerting.Pipeline
+	supervisor *supervisor
+	interval   time.Duration
+	// maxRSSPercent is relative to the machine's RAM, maxCPUPercent to one
+	// core and maxFDPercent to the child's open files limit.
+	maxRSSPercent float64
+	maxCPUPercent float64
+	maxFDPercent  float64
+
+	memory   uint64
+	lastPID  int
+	lastCPU  float64
+	lastTime time.Time
+	over     map[string]bool
+}
+
+func (r *resourceMonitor) run() {
+	if r.maxRSSPercent > 0 {
+		memory, err := totalMemory()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading total memory, not checking RSS: %v\n", err)
+		}
+		r.memory = memory
+	}
+	r.over = make(map[string]bool)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.supervisor.stop:
+			return
+		case <-ticker.C:
+		}
+		pid := r.supervisor.Status().PID
+		if pid == 0 {
+			continue
+		}
+		stats, err := readProcessStats(pid)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error sampling cdk-erigon resources: %v\n", err)
+			continue
+		}
+		r.check(pid, &stats)
+		r.supervisor.setStatus(func(st *childStatus) { st.Resources = &stats })
+	}
+}
+
+func (r *resourceMonitor) check(pid int, stats *processStats) {
+	now := time.Now()
+	sampled := pid == r.lastPID
+	if sampled {
+		stats.CPUPercent = (stats.CPUSeconds - r.lastCPU) / now.Sub(r.lastTime).Seconds() * 100
+	} else {
+		// A new child starts with a clean slate.
+		r.over = make(map[string]bool)
+	}
+	r.lastPID, r.lastCPU, r.lastTime = pid, stats.CPUSeconds, now
+
+	if r.maxRSSPercent > 0 && r.memory > 0 {
+		percent := float64(stats.RSS) / float64(r.memory) * 100
+		r.threshold("rss", percent, r.maxRSSPercent,
+			fmt.Sprintf("cdk-erigon uses %.0f MiB of memory, %.1f%% of RAM", float64(stats.RSS)/(1<<20), percent))
+	}
+	if r.maxCPUPercent > 0 && sampled {
+		r.threshold("cpu", stats.CPUPercent, r.maxCPUPercent,
+			fmt.Sprintf("cdk-erigon uses %.0f%% CPU", stats.CPUPercent))
+	}
+	if r.maxFDPercent > 0 && stats.MaxFDs > 0 {
+		percent := float64(stats.OpenFDs) / float64(stats.MaxFDs) * 100
+		r.threshold("fds", percent, r.maxFDPercent,
+			fmt.Sprintf("cdk-erigon has %d of %d files open", stats.OpenFDs, stats.MaxFDs))
+	}
+}
+
+func (r *resourceMonitor) threshold(resource string, value, max float64, message string) {
+	switch {
+	case value > max && !r.over[resource]:
+		r.over[resource] = true
+		message = fmt.Sprintf("%s, above the %.0f%% threshold", message, max)
+		fmt.Fprintln(os.Stderr, message)
+		r.pipeline.Event("high-"+resource, "WARNING", message)
+	case value <= max && r.over[resource]:
+		r.over[resource] = false
+		message = fmt.Sprintf("%s, back below the %.0f%% threshold", message, max)
+		fmt.Fprintln(os.Stderr, message)
+		r.pipeline.Event("high-"+resource+"-resolved", "INFO", message)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rpcClient makes JSON-RPC calls to the node's HTTP endpoint.
+type rpcClient struct {
+	url    string
+	client *http.Client
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (c *rpcClient) call(method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s failed: HTTP %s", method, resp.Status)
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("%s returned an invalid response: %w", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s failed: %s (%d)", method, response.Error.Message, response.Error.Code)
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("%s returned an unexpected result: %w", method, err)
+	}
+	return nil
+}
+
+// callUint64 calls a method returning a hex encoded quantity.
+func (c *rpcClient) callUint64(method string, params ...interface{}) (uint64, error) {
+	var hex string
+	if err := c.call(method, &hex, params...); err != nil {
+		return 0, err
+	}
+	return parseQuantity(hex)
+}
+
+func parseQuantity(hex string) (uint64, error) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(hex, "0x"), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q", hex)
+	}
+	return n, nil
+}
+
+// syncProgress is the result of eth_syncing while the node is syncing.
+type syncProgress struct {
+	CurrentBlock string `json:"currentBlock"`
+	HighestBlock string `json:"highestBlock"`
+}
+
+// syncing returns nil once the node is in sync.
+func (c *rpcClient) syncing() (*syncProgress, error) {
+	var raw json.RawMessage
+	if err := c.call("eth_syncing", &raw); err != nil {
+		return nil, err
+	}
+	if string(raw) == "false" {
+		return nil, nil
+	}
+	var progress syncProgress
+	if err := json.Unmarshal(raw, &progress); err != nil {
+		return nil, fmt.Errorf("eth_syncing returned an unexpected result: %w", err)
+	}
+	return &progress, nil
+}
+
+// txPoolStatus is the result of txpool_status.
+type txPoolStatus struct {
+	Pending uint64 `json:"pending"`
+	Queued  uint64 `json:"queued"`
+}
+
+func (c *rpcClient) txPoolStatus() (*txPoolStatus, error) {
+	var raw struct {
+		Pending string `json:"pending"`
+		Queued  string `json:"queued"`
+	}
+	if err := c.call("txpool_status", &raw); err != nil {
+		return nil, err
+	}
+	pending, err := parseQuantity(raw.Pending)
+	if err != nil {
+		return nil, fmt.Errorf("txpool_status returned an unexpected result: %w", err)
+	}
+	queued, err := parseQuantity(raw.Queued)
+	if err != nil {
+		return nil, fmt.Errorf("txpool_status returned an unexpected result: %w", err)
+	}
+	return &txPoolStatus{Pending: pending, Queued: queued}, nil
+}
+
+// blockHash returns the hash of block number, or "" if the node doesn't have
+// it.
+func (c *rpcClient) blockHash(number uint64) (string, error) {
+	var block *struct {
+		Hash string `json:"hash"`
+	}
+	if err := c.call("eth_getBlockByNumber", &block, fmt.Sprintf("0x%x", number), false); err != nil {
+		return "", err
+	}
+	if block == nil {
+		return "", nil
+	}
+	return block.Hash, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// cronSchedule is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week, each holding the values it matches.
+type cronSchedule struct {
+	spec   string
+	fields [5]map[int]bool
+	// Like cron, a restricted day of month or day of week matches either.
+	domAny, dowAny bool
+}
+
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseCron parses an expression like "0 4 * * 0": numbers, ranges (a-b),
+// lists (a,b), steps (*/n, a-b/n) and *. Sunday is 0 or 7.
+func parseCron(spec string) (*cronSchedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, want minute hour day-of-month month day-of-week", spec)
+	}
+	s := &cronSchedule{spec: spec, domAny: parts[2] == "*", dowAny: parts[4] == "*"}
+	for i, part := range parts {
+		values, err := parseCronField(part, cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		s.fields[i] = values
+	}
+	if s.fields[4][7] {
+		s.fields[4][0] = true
+	}
+	return s, nil
+}
+
+func parseCronField(field string, low, high int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, item := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in %q", item)
+			}
+		}
+		from, to := low, high
+		if rangePart != "*" {
+			fromStr, toStr, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = strconv.Atoi(fromStr); err != nil {
+				return nil, fmt.Errorf("invalid value %q", item)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(toStr); err != nil {
+					return nil, fmt.Errorf("invalid value %q", item)
+				}
+			} else if hasStep {
+				to = high
+			}
+		}
+		if from < low || to > high || from > to {
+			return nil, fmt.Errorf("%q out of range %d-%d", item, low, high)
+		}
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.fields[0][t.Minute()] || !s.fields[1][t.Hour()] || !s.fields[3][int(t.Month())] {
+		return false
+	}
+	dom, dow := s.fields[2][t.Day()], s.fields[4][int(t.Weekday())]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first minute after t the schedule matches, or the zero
+// time if there is none within a year.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(1, 0, 1); t.Before(end); t = t.Add(time.Minute) {
+		if s.matches(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// scheduledRestarter restarts a node on a schedule, announcing each restart
+// notice ahead and confirming that the RPC answers again within verify.
+type scheduledRestarter struct {
+	schedule   *cronSchedule
+	notice     time.Duration
+	verify     time.Duration
+	pipeline   *alerting.Pipeline
+	supervisor *supervisor
+	rpc        *rpcClient
+	name       string
+}
+
+func (r *scheduledRestarter) run() {
+	for {
+		at := r.schedule.next(time.Now())
+		if at.IsZero() {
+			fmt.Fprintf(os.Stderr, "Schedule %q never matches, no scheduled restarts\n", r.schedule.spec)
+			return
+		}
+		if !r.sleepUntil(at.Add(-r.notice)) {
+			return
+		}
+		if r.notice > 0 {
+			message := fmt.Sprintf("%scdk-erigon will be restarted at %s as scheduled (%s)", nodePrefix(r.name), at.Format("15:04 MST"), r.schedule.spec)
+			fmt.Println(message)
+			r.pipeline.Event("scheduled-restart", "INFO", message)
+		}
+		if !r.sleepUntil(at) {
+			return
+		}
+		if state := r.supervisor.Status().State; state != "running" {
+			fmt.Fprintf(os.Stderr, "%sSkipping the scheduled restart, cdk-erigon is %s\n", nodePrefix(r.name), state)
+			continue
+		}
+		before := r.supervisor.Status().Started
+		fmt.Printf("%sRestarting cdk-erigon as scheduled\n", nodePrefix(r.name))
+		r.supervisor.Restart()
+		if r.rpc != nil {
+			r.confirm(before)
+		}
+	}
+}
+
+// sleepUntil waits for t and reports whether the node is still supervised.
+func (r *scheduledRestarter) sleepUntil(t time.Time) bool {
+	select {
+	case <-r.supervisor.stop:
+		return false
+	case <-time.After(time.Until(t)):
+		return true
+	}
+}
+
+// confirm waits for the node started after before to answer its RPC.
+func (r *scheduledRestarter) confirm(before *time.Time) {
+	deadline := time.After(r.verify)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		select {
+		case <-r.supervisor.stop:
+			return
+		case <-deadline:
+			message := fmt.Sprintf("%scdk-erigon did not come back within %s of its scheduled restart: %v", nodePrefix(r.name), r.verify, lastErr)
+			fmt.Fprintln(os.Stderr, message)
+			r.pipeline.Event("scheduled-restart-failed", "CRITICAL", message)
+			return
+		case <-ticker.C:
+		}
+		status := r.supervisor.Status()
+		if status.State != "running" || status.Started == nil || (before != nil && !status.Started.After(*before)) {
+			lastErr = fmt.Errorf("cdk-erigon is %s", status.State)
+			continue
+		}
+		head, err := r.rpc.callUint64("eth_blockNumber")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		message := fmt.Sprintf("%scdk-erigon is back after its scheduled restart, at block %d after %s", nodePrefix(r.name), head, time.Since(*status.Started).Round(time.Second))
+		fmt.Println(message)
+		r.pipeline.Event("scheduled-restart-done", "INFO", message)
+		return
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		{"0 4 * * 0", "2024-06-05 12:00", "2024-06-09 04:00"},
+		{"0 4 * * 7", "2024-06-05 12:00", "2024-06-09 04:00"},
+		{"*/15 * * * *", "2024-06-05 12:07", "2024-06-05 12:15"},
+		{"30 2 1 * *", "2024-06-05 12:00", "2024-07-01 02:30"},
+		{"0 0 1 1 *", "2024-06-05 12:00", "2025-01-01 00:00"},
+		{"0 9-17/4 * * 1-5", "2024-06-07 17:00", "2024-06-10 09:00"},
+		{"0 12 * * 1,3", "2024-06-05 12:00", "2024-06-10 12:00"},
+		// A restricted day of month and day of week match either.
+		{"0 0 15 * 5", "2024-06-05 12:00", "2024-06-07 00:00"},
+		{"0 0 29 2 *", "2024-06-05 12:00", ""}, // none within a year
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.spec, err)
+			continue
+		}
+		after, err := time.Parse("2006-01-02 15:04", tt.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if next := s.next(after); !next.IsZero() {
+			got = next.Format("2006-01-02 15:04")
+		}
+		if got != tt.want {
+			t.Errorf("parseCron(%q).next(%s) = %s, want %s", tt.spec, tt.after, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "0 4 * *", "0 4 * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) should fail", spec)
+		}
+	}
+}
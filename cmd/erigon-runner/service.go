@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// serviceOptions are the flags only understood by install-service.
+type serviceOptions struct {
+	name       string
+	enable     bool
+	unitDir    string
+	user       string
+	restart    string
+	restartSec time.Duration
+	// adopt is set when the runner is run with -adopt.
+	adopt bool
+}
+
+func registerServiceFlags(fs *flag.FlagSet) *serviceOptions {
+	opts := &serviceOptions{}
+	fs.StringVar(&opts.name, "name", "erigon-runner", "Service name")
+	fs.BoolVar(&opts.enable, "enable", false, "Enable and start the service after installing it")
+	fs.StringVar(&opts.unitDir, "unit-dir", "/etc/systemd/system", "Directory the systemd unit is written to")
+	fs.StringVar(&opts.user, "user", "", "User the service runs as; defaults to root")
+	fs.StringVar(&opts.restart, "restart", "on-failure", "systemd restart policy of the runner itself, e.g. always")
+	fs.DurationVar(&opts.restartSec, "restart-sec", 10*time.Second, "Delay before systemd restarts the runner")
+	return opts
+}
+
+// serviceArgs rebuilds the run flags that were explicitly set, leaving out
+// the ones of install-service.
+func serviceArgs(fs *flag.FlagSet) []string {
+	install := flag.NewFlagSet("install-service", flag.ContinueOnError)
+	registerServiceFlags(install)
+	var args []string
+	fs.Visit(func(f *flag.Flag) {
+		if install.Lookup(f.Name) != nil {
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+	})
+	return args
+}
+
+// runInstallService writes a unit running the runner with args from the
+// current directory. grace is the runner's shutdown grace period, which the
+// unit's stop timeout has to cover.
+func runInstallService(opts *serviceOptions, args []string, grace time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	return installService(opts, exe, args, workingDirectory, grace)
+}
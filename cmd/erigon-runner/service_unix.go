@@ -0,0 +1,59 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/revitteth/scripts/internal/systemd"
+)
+
+// The runner forwards SIGTERM to cdk-erigon itself, so only it is signalled
+// (KillMode=mixed) and it gets the grace period before systemd kills all.
+// With -adopt cdk-erigon is left running for the next runner, which systemd
+// must not kill either (KillMode=process).
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=erigon-runner cdk-erigon supervisor ({{.Name}})
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart={{.ExecStart}}
+WorkingDirectory={{.WorkingDirectory}}
+{{- if .User}}
+User={{.User}}
+{{- end}}
+Restart={{.Restart}}
+RestartSec={{.RestartSec}}
+KillSignal=SIGTERM
+KillMode={{.KillMode}}
+TimeoutStopSec={{.TimeoutStopSec}}
+LimitNOFILE=1048576
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func installService(opts *serviceOptions, exe string, args []string, workingDirectory string, grace time.Duration) error {
+	killMode := "mixed"
+	if opts.adopt {
+		killMode = "process"
+	}
+	unitPath, err := systemd.WriteUnit(opts.unitDir, opts.name, unitTemplate, map[string]string{
+		"Name":             opts.name,
+		"ExecStart":        systemd.ExecStart(exe, args),
+		"WorkingDirectory": workingDirectory,
+		"User":             opts.user,
+		"Restart":          opts.restart,
+		"RestartSec":       fmt.Sprintf("%ds", int(opts.restartSec.Seconds())),
+		"TimeoutStopSec":   fmt.Sprintf("%ds", int((grace + 30*time.Second).Seconds())),
+		"KillMode":         killMode,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Wrote", unitPath)
+	return systemd.Enable(opts.name, opts.enable)
+}
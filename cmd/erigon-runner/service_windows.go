@@ -0,0 +1,10 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func installService(opts *serviceOptions, exe string, args []string, workingDirectory string, grace time.Duration) error {
+	return fmt.Errorf("install-service needs systemd and is not supported on this platform")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// errSmokeTests is returned by supervisor.run when a node was stopped for
+// failing its smoke tests.
+var errSmokeTests = errors.New("smoke tests failed")
+
+// smokeChecks are the RPC checks a started node can be put through, by name.
+var smokeChecks = map[string]func(t *smokeTest) error{
+	"chainId":     (*smokeTest).checkChainID,
+	"syncing":     (*smokeTest).checkSyncing,
+	"batchNumber": (*smokeTest).checkBatchNumber,
+}
+
+// parseSmokeChecks parses a comma separated list of smoke check names.
+func parseSmokeChecks(value string) ([]string, error) {
+	var checks []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := smokeChecks[name]; !ok {
+			return nil, fmt.Errorf("unknown smoke test %q, want chainId, syncing or batchNumber", name)
+		}
+		checks = append(checks, name)
+	}
+	return checks, nil
+}
+
+// smokeTest runs RPC checks against every start of the node until they all
+// pass, alerting, and optionally stopping the node, if they don't within the
+// deadline.
+type smokeTest struct {
+	rpc        *rpcClient
+	pipeline   *alerting.Pipeline
+	supervisor *supervisor
+	checks     []string
+	interval   time.Duration
+	deadline   time.Duration
+	// chainID is the chain ID eth_chainId must return; 0 accepts any.
+	chainID uint64
+	// abort stops the node when the deadline passes.
+	abort bool
+}
+
+func (t *smokeTest) run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	var tested time.Time
+	for {
+		select {
+		case <-t.supervisor.stop:
+			return
+		case <-ticker.C:
+		}
+		status := t.supervisor.Status()
+		if status.State != "running" || status.Started.Equal(tested) {
+			continue
+		}
+		started := *status.Started
+		err := t.check()
+		if err == nil {
+			tested = started
+			t.supervisor.logf("Smoke tests passed after %s\n", time.Since(started).Round(time.Second))
+			t.supervisor.setStatus(func(st *childStatus) { st.SmokeTests = "passed" })
+			continue
+		}
+		t.supervisor.setStatus(func(st *childStatus) { st.SmokeTests = "pending: " + err.Error() })
+		if time.Since(started) < t.deadline {
+			continue
+		}
+
+		tested = started
+		t.supervisor.setStatus(func(st *childStatus) { st.SmokeTests = "failed: " + err.Error() })
+		message := fmt.Sprintf("cdk-erigon failed its smoke tests within %s of starting: %v", t.deadline, err)
+		if t.abort {
+			message += ", stopping it"
+		}
+		t.supervisor.logf("%s\n", message)
+		t.pipeline.Event("smoke-test-failed", "CRITICAL", message)
+		if t.abort {
+			t.supervisor.Abort(fmt.Errorf("%w: %v", errSmokeTests, err))
+		}
+	}
+}
+
+// check runs every check, returning the first failure.
+func (t *smokeTest) check() error {
+	for _, name := range t.checks {
+		if err := smokeChecks[name](t); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (t *smokeTest) checkChainID() error {
+	chainID, err := t.rpc.callUint64("eth_chainId")
+	if err != nil {
+		return err
+	}
+	if t.chainID != 0 && chainID != t.chainID {
+		return fmt.Errorf("chain ID is %d, want %d", chainID, t.chainID)
+	}
+	return nil
+}
+
+func (t *smokeTest) checkSyncing() error {
+	_, err := t.rpc.syncing()
+	return err
+}
+
+func (t *smokeTest) checkBatchNumber() error {
+	batch, err := t.rpc.callUint64("zkevm_batchNumber")
+	if err != nil {
+		return err
+	}
+	if batch == 0 {
+		return errors.New("batch number is still 0")
+	}
+	return nil
+}
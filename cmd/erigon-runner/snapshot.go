@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// immutableDir holds erigon's segment files, which never change once written
+// and can be shared with a snapshot through hardlinks.
+const immutableDir = "snapshots"
+
+// snapshotPrefix is the path of the snapshots of datadir without their
+// timestamp. They are kept next to the datadir unless dir is set; hardlinks
+// need both on the same volume.
+func snapshotPrefix(datadir, dir string) string {
+	if dir == "" {
+		dir = filepath.Dir(datadir)
+	}
+	return filepath.Join(dir, filepath.Base(datadir)+".snapshot-")
+}
+
+// snapshotDatadir copies datadir, which must not be in use, to a timestamped
+// snapshot. Segment files are hardlinked where possible, the databases copied.
+func snapshotDatadir(datadir, dir string, at time.Time) (string, error) {
+	snapshot := snapshotPrefix(datadir, dir) + at.UTC().Format("20060102T150405Z")
+	partial := snapshot + ".partial"
+	os.RemoveAll(partial)
+	err := filepath.WalkDir(datadir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(datadir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(partial, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !d.Type().IsRegular():
+			return nil
+		}
+		if rel == immutableDir || strings.HasPrefix(rel, immutableDir+string(filepath.Separator)) {
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+		}
+		return copyRegularFile(path, target, info.Mode().Perm())
+	})
+	if err == nil {
+		err = os.Rename(partial, snapshot)
+	}
+	if err != nil {
+		os.RemoveAll(partial)
+		return "", fmt.Errorf("failed to snapshot %s: %w", datadir, err)
+	}
+	return snapshot, nil
+}
+
+func copyRegularFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// restoreSnapshot puts snapshot in place of datadir, which must not be in
+// use. The replaced datadir is kept aside for inspection and its path
+// returned.
+func restoreSnapshot(datadir, snapshot string, at time.Time) (string, error) {
+	aside := datadir + ".failed-" + at.UTC().Format("20060102T150405Z")
+	if err := os.Rename(datadir, aside); err != nil {
+		return "", fmt.Errorf("failed to move %s aside: %w", datadir, err)
+	}
+	if err := os.Rename(snapshot, datadir); err != nil {
+		os.Rename(aside, datadir)
+		return "", fmt.Errorf("failed to restore %s: %w", snapshot, err)
+	}
+	return aside, nil
+}
+
+// pruneSnapshots removes all but the keep newest snapshots of datadir.
+func pruneSnapshots(datadir, dir string, keep int) {
+	snapshots, err := filepath.Glob(snapshotPrefix(datadir, dir) + "*")
+	if err != nil {
+		return
+	}
+	var complete []string
+	for _, snapshot := range snapshots {
+		if !strings.HasSuffix(snapshot, ".partial") {
+			complete = append(complete, snapshot)
+		}
+	}
+	// Timestamps sort chronologically.
+	sort.Strings(complete)
+	for len(complete) > keep {
+		fmt.Println("Removing old snapshot", complete[0])
+		if err := os.RemoveAll(complete[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing old snapshot: %v\n", err)
+		}
+		complete = complete[1:]
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// stageRegex matches the "[5/15 Execution]" module of the lines the stage
+// loop logs.
+var stageRegex = regexp.MustCompile(`\[\d+/\d+ ([\w-]+)\]`)
+
+// stageProgressKeys are the fields stages report their progress in, in order
+// of preference.
+var stageProgressKeys = []string{"progress", "number", "block", "blk", "block_num", "blockNumber", "batch", "batchNumber"}
+
+// stageWatcher follows the progress the stages log and alerts when the stage
+// being run keeps reporting the same progress for window.
+type stageWatcher struct {
+	pipeline   *alerting.Pipeline
+	supervisor *supervisor
+	name       string
+	window     time.Duration
+
+	mu       sync.Mutex
+	stage    string
+	progress uint64
+	moved    time.Time
+	seen     time.Time
+	stalled  bool
+}
+
+// parseStageProgress returns the stage and progress of a stage loop line.
+func parseStageProgress(line string) (string, uint64, bool) {
+	m := stageRegex.FindStringSubmatch(line)
+	if m == nil {
+		return "", 0, false
+	}
+	parsed, ok := alerting.ParseLogLine(line)
+	if !ok {
+		return "", 0, false
+	}
+	for _, key := range stageProgressKeys {
+		if value, ok := parsed.Fields[key]; ok {
+			if progress, err := strconv.ParseUint(value, 10, 64); err == nil {
+				return m[1], progress, true
+			}
+		}
+	}
+	return "", 0, false
+}
+
+func (w *stageWatcher) observe(line string) {
+	stage, progress, ok := parseStageProgress(line)
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	// A stage that went quiet for a window, e.g. over a restart, starts over.
+	changed := stage != w.stage || progress != w.progress
+	if changed || now.Sub(w.seen) > w.window {
+		if w.stalled && changed {
+			message := fmt.Sprintf("%sStage %s got past %d after being stuck for %s, now %s at %d", nodePrefix(w.name), w.stage, w.progress, now.Sub(w.moved).Round(time.Second), stage, progress)
+			fmt.Fprintln(os.Stderr, message)
+			w.pipeline.Event("stage-resumed", "INFO", message)
+		}
+		w.stalled = false
+		w.stage, w.progress, w.moved = stage, progress, now
+		w.supervisor.setStatus(func(st *childStatus) { st.Stage = fmt.Sprintf("%s at %d", stage, progress) })
+	}
+	w.seen = now
+	if !w.stalled && now.Sub(w.moved) >= w.window {
+		w.stalled = true
+		message := fmt.Sprintf("%sStage %s has been stuck at %d for %s\nLast progress: %s", nodePrefix(w.name), stage, progress, now.Sub(w.moved).Round(time.Second), line)
+		fmt.Fprintln(os.Stderr, message)
+		w.pipeline.Event("stage-stalled", "WARNING", message)
+	}
+}
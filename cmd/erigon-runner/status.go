@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// crashLogLines is how many of the last log lines a crash loop alert carries.
+	crashLogLines = 200
+	// maxCrashLogBytes keeps the attached lines within a chat message.
+	maxCrashLogBytes = 16 * 1024
+)
+
+// logTail keeps the last lines of the child's output.
+type logTail struct {
+	lines []string
+	next  int
+	full  bool
+	added int
+	mu    sync.Mutex
+}
+
+func newLogTail(size int) *logTail {
+	return &logTail{lines: make([]string, size)}
+}
+
+func (t *logTail) Add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+	t.added++
+}
+
+// Lines returns up to the last n lines, oldest first.
+func (t *logTail) Lines(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last(n)
+}
+
+// Since returns the lines added after the first added ones, as far as they
+// are still kept, and how many lines were added in total.
+func (t *logTail) Since(added int) ([]string, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.added <= added {
+		return nil, t.added
+	}
+	return t.last(t.added - added), t.added
+}
+
+func (t *logTail) last(n int) []string {
+	var lines []string
+	if t.full {
+		lines = append(lines, t.lines[t.next:]...)
+	}
+	lines = append(lines, t.lines[:t.next]...)
+	if n >= 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// lastLines joins the last lines of tail, dropping the oldest ones that don't
+// fit within maxBytes.
+func lastLines(tail *logTail, n, maxBytes int) string {
+	lines := tail.Lines(n)
+	size := 0
+	for i := len(lines) - 1; i >= 0; i-- {
+		size += len(lines[i]) + 1
+		if size > maxBytes {
+			return strings.Join(lines[i+1:], "\n")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// childStatus is what the status API reports about the supervised child.
+type childStatus struct {
+	State      string        `json:"state"`
+	PID        int           `json:"pid,omitempty"`
+	Version    string        `json:"version,omitempty"`
+	Started    *time.Time    `json:"started,omitempty"`
+	Restarts   int           `json:"restarts"`
+	LastExit   string        `json:"lastExit,omitempty"`
+	LastExitAt *time.Time    `json:"lastExitAt,omitempty"`
+	Head       uint64        `json:"head,omitempty"`
+	Syncing    bool          `json:"syncing"`
+	Stage      string        `json:"stage,omitempty"`
+	SyncedAt   *time.Time    `json:"syncedAt,omitempty"`
+	Peers      int           `json:"peers"`
+	Batches    *batchNumbers `json:"batches,omitempty"`
+	TxPool     *txPoolStatus `json:"txPool,omitempty"`
+	Fees       feeQuotes     `json:"fees,omitempty"`
+	Resources  *processStats `json:"resources,omitempty"`
+	DiskFree   uint64        `json:"diskFree,omitempty"`
+	SmokeTests string        `json:"smokeTests,omitempty"`
+	RPCError   string        `json:"rpcError,omitempty"`
+}
+
+// nodeStatus is the status of a node as the status API reports it.
+type nodeStatus struct {
+	childStatus
+	Uptime     string        `json:"uptime,omitempty"`
+	LastAlerts []recentAlert `json:"lastAlerts,omitempty"`
+}
+
+func (n *node) status() nodeStatus {
+	st := nodeStatus{childStatus: n.supervisor.Status(), LastAlerts: n.alerts.Recent()}
+	if st.State == "running" && st.Started != nil {
+		st.Uptime = time.Since(*st.Started).Round(time.Second).String()
+	}
+	return st
+}
+
+// statusHandler serves the supervisor state at /status, the last log lines at
+// /logs?lines=N, followed with &follow=true, and Prometheus metrics at
+// /metrics. With several nodes, /status reports each by name and the other
+// endpoints need ?node=NAME. Captured profiles are served from profiles at
+// /profiles/ if it is set.
+//
+// With a controlToken, POST /restart gracefully restarts cdk-erigon, POST
+// /stop stops it for good and POST /bundle collects and uploads a bundle of
+// its logs like after a crash, given the token as an "Authorization: Bearer"
+// header.
+func statusHandler(nodes []*node, profiles, controlToken string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler(nodes))
+	if profiles != "" {
+		mux.Handle("/profiles/", http.StripPrefix("/profiles/", http.FileServer(http.Dir(profiles))))
+	}
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var status interface{} = nodes[0].status()
+		if len(nodes) > 1 {
+			statuses := make(map[string]nodeStatus, len(nodes))
+			for _, n := range nodes {
+				statuses[n.name] = n.status()
+			}
+			status = statuses
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(status); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing status response: %v\n", err)
+		}
+	})
+
+	mux.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		n, ok := findNode(nodes, r.URL.Query().Get("node"))
+		if !ok {
+			http.Error(w, "node must name one of the nodes", http.StatusBadRequest)
+			return
+		}
+		lines := crashLogLines
+		if l := r.URL.Query().Get("lines"); l != "" {
+			var err error
+			if lines, err = strconv.Atoi(l); err != nil || lines <= 0 {
+				http.Error(w, "lines must be a positive integer", http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		tail := n.supervisor.tail
+		kept, added := tail.Since(0)
+		if len(kept) > lines {
+			kept = kept[len(kept)-lines:]
+		}
+		for _, line := range kept {
+			fmt.Fprintln(w, line)
+		}
+		if r.URL.Query().Get("follow") != "true" {
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			return
+		}
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			flusher.Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+			var newLines []string
+			newLines, added = tail.Since(added)
+			for _, line := range newLines {
+				fmt.Fprintln(w, line)
+			}
+		}
+	})
+
+	control := func(action string, act func(n *node) (int, map[string]string)) {
+		mux.HandleFunc("/"+action, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if controlToken == "" {
+				http.Error(w, "control is disabled, start the runner with -control-token", http.StatusForbidden)
+				return
+			}
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(controlToken)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+			n, ok := findNode(nodes, r.URL.Query().Get("node"))
+			if !ok {
+				http.Error(w, "node must name one of the nodes", http.StatusBadRequest)
+				return
+			}
+			fmt.Printf("%sStatus API client %s requested a %s\n", nodePrefix(n.name), r.RemoteAddr, action)
+			code, response := act(n)
+			response["node"] = n.name
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			json.NewEncoder(w).Encode(response)
+		})
+	}
+	control("restart", func(n *node) (int, map[string]string) {
+		if state := n.supervisor.Status().State; state != "running" {
+			return http.StatusConflict, map[string]string{"state": state}
+		}
+		n.supervisor.Restart()
+		return http.StatusAccepted, map[string]string{"state": "restarting"}
+	})
+	control("stop", func(n *node) (int, map[string]string) {
+		n.supervisor.Stop(os.Interrupt)
+		return http.StatusAccepted, map[string]string{"state": "stopping"}
+	})
+	control("bundle", func(n *node) (int, map[string]string) {
+		path, url, err := n.supervisor.collectBundle()
+		if path == "" {
+			return http.StatusInternalServerError, map[string]string{"error": err.Error()}
+		}
+		response := map[string]string{"path": path, "url": url}
+		if err != nil {
+			response["error"] = err.Error()
+		}
+		return http.StatusOK, response
+	})
+
+	return mux
+}
+
+// findNode returns the node called name, or the only node if name is empty.
+func findNode(nodes []*node, name string) (*node, bool) {
+	if name == "" {
+		return nodes[0], len(nodes) == 1
+	}
+	for _, n := range nodes {
+		if n.name == name {
+			return n, true
+		}
+	}
+	return nil, false
+}
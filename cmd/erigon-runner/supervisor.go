@@ -0,0 +1,608 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// tailLines is how many of the child's last log lines are kept for the
+// status API and crash loop alerts.
+const tailLines = 1000
+
+// errCrashLoop is returned by supervisor.run when the crash loop breaker trips.
+var errCrashLoop = errors.New("crash loop detected")
+
+// supervisor runs cdk-erigon and restarts it with exponential backoff when it
+// exits, alerting on every restart.
+type supervisor struct {
+	// name tags the child's log lines when several nodes run.
+	name        string
+	dir         string
+	binary      string
+	args        []string
+	pipeline    *alerting.Pipeline
+	maxLine     int
+	maxRestarts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	// container is the docker container the child runs, if any; it
+	// outlives a killed docker client and is removed along with it.
+	container string
+	// adoptDir, the datadir, is set when the child outlives the runner:
+	// its output goes to files there, and a pidfile tells the next runner
+	// which process to adopt. adopted is that process, supervised instead of
+	// starting a new one.
+	adoptDir string
+	adopted  int
+	// detach is closed by Shutdown when such a child is left running as the
+	// runner exits, for the next runner to adopt.
+	detach chan struct{}
+	// outputRotation rotates the output files of such a child.
+	outputRotation alerting.LogRotationConfig
+
+	// More than crashLoopRestarts restarts within crashLoopWindow trip the
+	// breaker, which stops restarting; 0 disables it.
+	crashLoopRestarts int
+	crashLoopWindow   time.Duration
+
+	// crashDir, when set, receives a bundle of artifacts for every non-zero
+	// exit, carrying the last crashLogLines log lines.
+	crashDir      string
+	crashLogLines int
+	// crashUpload, when set, is the bucket bundles are uploaded to.
+	crashUpload *bucketURL
+
+	// grace is how long a stopped child gets to exit before it is killed.
+	grace      time.Duration
+	stop       chan struct{}
+	kill       chan struct{}
+	stopSignal os.Signal
+	signals    int
+	// aborted is returned by run when the child was stopped by Abort.
+	aborted error
+
+	// restart asks for the child to be restarted right away, e.g. to run an
+	// updated binary. Such restarts don't count towards the restart limits.
+	restart    chan struct{}
+	restarting bool
+	// prepare runs between the exit of the child and its restart.
+	prepare func()
+	// done is closed once run returned, when restarts and prepare no
+	// longer happen.
+	done chan struct{}
+
+	// beforeStart runs before every start of the child.
+	beforeStart func()
+	// hooks run on the child's lifecycle events with hookEnv, which
+	// describes the node, in their environment.
+	hooks   hookSet
+	hookEnv map[string]string
+	// Lines printed to the console's stdout and stderr are colored by
+	// level when set.
+	colorStdout bool
+	colorStderr bool
+	// observers see every log line of the child.
+	observers []func(string)
+
+	tail     *logTail
+	status   childStatus
+	statusMu sync.Mutex
+}
+
+// Stop forwards sig to the child and ends supervision once it exited. The
+// child is killed when it takes longer than the grace period or Stop is called
+// a second time.
+func (s *supervisor) Stop(sig os.Signal) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.signals++
+	switch s.signals {
+	case 1:
+		s.stopSignal = sig
+		close(s.stop)
+	case 2:
+		close(s.kill)
+	}
+}
+
+// Shutdown ends supervision as the runner exits. A child that outlives the
+// runner is left running, in its own process group, for the next runner to
+// adopt; any other is stopped like Stop.
+func (s *supervisor) Shutdown(sig os.Signal) {
+	if s.adoptDir != "" {
+		s.statusMu.Lock()
+		select {
+		case <-s.detach:
+		default:
+			close(s.detach)
+		}
+		s.statusMu.Unlock()
+	}
+	s.Stop(sig)
+}
+
+func (s *supervisor) detached() bool {
+	select {
+	case <-s.detach:
+		return true
+	default:
+		return false
+	}
+}
+
+// Abort stops the child like Stop, making run return err.
+func (s *supervisor) Abort(err error) {
+	s.statusMu.Lock()
+	s.aborted = err
+	s.statusMu.Unlock()
+	s.Stop(os.Interrupt)
+}
+
+func (s *supervisor) abortError() error {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.aborted
+}
+
+// Restart stops the child like Stop and starts it again without backoff.
+func (s *supervisor) Restart() {
+	select {
+	case s.restart <- struct{}{}:
+	default:
+	}
+}
+
+// RestartAfter is Restart running prepare once the child exited, before it
+// is started again.
+func (s *supervisor) RestartAfter(prepare func()) {
+	s.statusMu.Lock()
+	s.prepare = prepare
+	s.statusMu.Unlock()
+	s.Restart()
+}
+
+// prepareRestart runs the function passed to RestartAfter, if any.
+func (s *supervisor) prepareRestart() {
+	s.statusMu.Lock()
+	prepare := s.prepare
+	s.prepare = nil
+	s.statusMu.Unlock()
+	if prepare != nil {
+		s.setStatus(func(st *childStatus) { st.State = "preparing" })
+		prepare()
+	}
+}
+
+func (s *supervisor) restartRequested() bool {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	requested := s.restarting
+	s.restarting = false
+	return requested
+}
+
+func (s *supervisor) stopping() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// logf writes a message about the child to stderr, tagged with its name.
+func (s *supervisor) logf(format string, args ...interface{}) {
+	if s.name != "" {
+		format = "[" + s.name + "] " + format
+	}
+	fmt.Fprintf(os.Stderr, format, args...)
+}
+
+func (s *supervisor) Status() childStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.status
+}
+
+func (s *supervisor) setStatus(update func(*childStatus)) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	update(&s.status)
+}
+
+// run returns once the child exited more than maxRestarts times in a row, the
+// crash loop breaker tripped or the supervisor was stopped, in which case the
+// error is nil unless it was aborted. A run lasting longer than maxBackoff counts
+// as healthy and resets the backoff and the restart count.
+func (s *supervisor) run() error {
+	defer close(s.done)
+	restarts := 0
+	delay := s.backoff
+	var recent []time.Time
+	for {
+		started := time.Now()
+		var err error
+		if s.adopted != 0 {
+			pid := s.adopted
+			s.adopted = 0
+			err = s.watchAdopted(pid)
+		} else {
+			err = s.runOnce()
+		}
+		if errors.Is(err, errDetached) {
+			pid := s.Status().PID
+			s.setStatus(func(st *childStatus) {
+				st.State = "detached"
+			})
+			s.logf("Left cdk-erigon %d running for the next runner to adopt\n", pid)
+			return nil
+		}
+		ran := s.Status()
+		if ran.Started != nil && !ran.Started.Before(started) {
+			s.runHook(hookPostStop, exitEnv(ran.PID, err))
+		}
+		if s.stopping() {
+			s.setStatus(func(st *childStatus) {
+				st.State = "stopped"
+				st.PID = 0
+			})
+			s.logf("cdk-erigon stopped\n")
+			return s.abortError()
+		}
+		if s.restartRequested() {
+			s.prepareRestart()
+			s.logf("Restarting cdk-erigon\n")
+			restarts = 0
+			delay = s.backoff
+			recent = nil
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("exited cleanly")
+		}
+		exited := time.Now()
+		crash := ""
+		var exitErr *exec.ExitError
+		crashed := errors.As(err, &exitErr)
+		crashEnv := exitEnv(ran.PID, err)
+		if s.crashDir != "" && crashed {
+			path, summary, err := collectCrash(s.crashDir, crashReport{
+				name:     s.name,
+				exit:     exitErr,
+				pid:      ran.PID,
+				started:  started,
+				exited:   exited,
+				binary:   s.binary,
+				args:     s.args,
+				logLines: s.tail.Lines(s.crashLogLines),
+				redact:   s.crashUpload != nil,
+			})
+			if err != nil {
+				s.logf("Error collecting crash artifacts: %v\n", err)
+			} else {
+				crashEnv["CRASH_BUNDLE"] = path
+				if url, err := s.uploadBundle(path); err != nil {
+					s.logf("Error uploading crash bundle: %v\n", err)
+				} else if url != "" {
+					summary += "\nUploaded to " + url
+					crashEnv["CRASH_BUNDLE_URL"] = url
+				}
+				crash = "\n\n" + summary
+			}
+		}
+		if crashed {
+			s.runHook(hookOnCrash, crashEnv)
+		}
+		s.setStatus(func(st *childStatus) {
+			st.State = "exited"
+			st.PID = 0
+			st.LastExit = err.Error()
+			st.LastExitAt = &exited
+		})
+		if time.Since(started) > s.maxBackoff {
+			restarts = 0
+			delay = s.backoff
+		}
+		if restarts >= s.maxRestarts {
+			return fmt.Errorf("cdk-erigon %w, giving up after %d restart(s)%s", err, restarts, crash)
+		}
+
+		if s.crashLoopRestarts > 0 {
+			recent = append(recent, time.Now())
+			for len(recent) > 0 && time.Since(recent[0]) > s.crashLoopWindow {
+				recent = recent[1:]
+			}
+			if len(recent) > s.crashLoopRestarts {
+				s.setStatus(func(st *childStatus) { st.State = "crash-loop" })
+				message := fmt.Sprintf("cdk-erigon exited %d times within %s, not restarting it any more. Last exit: %v%s\n\nLast log lines:\n%s",
+					len(recent), s.crashLoopWindow, err, crash, lastLines(s.tail, crashLogLines, maxCrashLogBytes))
+				s.pipeline.Event("crash-loop", "CRITICAL", message)
+				return fmt.Errorf("%w: cdk-erigon exited %d times within %s", errCrashLoop, len(recent), s.crashLoopWindow)
+			}
+		}
+		restarts++
+
+		message := fmt.Sprintf("cdk-erigon %v after %s, restarting in %s (restart %d of %d)%s",
+			err, time.Since(started).Round(time.Second), delay, restarts, s.maxRestarts, crash)
+		s.logf("%s\n", message)
+		s.pipeline.Event("restart", "WARNING", message)
+		s.setStatus(func(st *childStatus) {
+			st.State = "backoff"
+			st.Restarts++
+		})
+
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			s.setStatus(func(st *childStatus) { st.State = "stopped" })
+			return s.abortError()
+		case <-s.restart:
+			s.prepareRestart()
+			restarts = 0
+			delay = s.backoff
+			recent = nil
+			continue
+		}
+		delay *= 2
+		if delay > s.maxBackoff {
+			delay = s.maxBackoff
+		}
+	}
+}
+
+// runOnce starts cdk-erigon and feeds its output to the pipeline until it
+// exits.
+func (s *supervisor) runOnce() error {
+	if s.adoptDir != "" {
+		return s.runDetached()
+	}
+	if s.beforeStart != nil {
+		s.beforeStart()
+	}
+	if err := s.hooks.run(hookPreStart, s.hookVars(nil)); err != nil {
+		s.pipeline.Event("hook-failed", "CRITICAL", fmt.Sprintf("Not starting cdk-erigon: %v", err))
+		return fmt.Errorf("not started: %w", err)
+	}
+	cmd := exec.Command(s.binary, s.args...)
+	cmd.Dir = s.dir
+	isolateChild(cmd)
+	if s.container != "" {
+		removeContainer(s.container)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	s.started(cmd)
+
+	exited := make(chan struct{})
+	defer close(exited)
+	go s.forwardStop(cmd, exited)
+	s.processOutput(stdout, stderr)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("finished with error: %w", err)
+	}
+	return nil
+}
+
+// runDetached is runOnce for a child that may outlive the runner: its output
+// is written to files in adoptDir, which are followed until it exited.
+func (s *supervisor) runDetached() error {
+	if s.beforeStart != nil {
+		s.beforeStart()
+	}
+	if err := s.hooks.run(hookPreStart, s.hookVars(nil)); err != nil {
+		s.pipeline.Event("hook-failed", "CRITICAL", fmt.Sprintf("Not starting cdk-erigon: %v", err))
+		return fmt.Errorf("not started: %w", err)
+	}
+	cmd := exec.Command(s.binary, s.args...)
+	cmd.Dir = s.dir
+	isolateChild(cmd)
+	files, err := openOutput(s.adoptDir, s.outputRotation)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(files)
+	// Every run starts its own files, so earlier output isn't alerted on
+	// again.
+	for _, rf := range files {
+		if err := rf.Rotate(); err != nil {
+			s.logf("Error rotating output of cdk-erigon: %v\n", err)
+		}
+	}
+	exited := make(chan struct{})
+	following := s.untilDetached(exited)
+	stdout, stderr, err := followOutput(s.adoptDir, true, following)
+	if err != nil {
+		return err
+	}
+	defer stdout.Close()
+	defer stderr.Close()
+	cmd.Stdout = files[0].File()
+	cmd.Stderr = files[1].File()
+	if err := cmd.Start(); err != nil {
+		close(exited)
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	s.started(cmd)
+	if err := writePidFile(s.adoptDir, cmd.Process.Pid); err != nil {
+		s.logf("Error writing pidfile: %v\n", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- cmd.Wait()
+		close(exited)
+	}()
+	go s.forwardStop(cmd, exited)
+	go s.rotateOutput(files, following)
+	s.processOutput(stdout, stderr)
+
+	// The pidfile stays for the next runner when the child is left running.
+	if s.detached() {
+		return errDetached
+	}
+	defer removePidFile(s.adoptDir)
+	if err := <-waited; err != nil {
+		return fmt.Errorf("finished with error: %w", err)
+	}
+	return nil
+}
+
+func (s *supervisor) started(cmd *exec.Cmd) {
+	started := time.Now()
+	s.setStatus(func(st *childStatus) {
+		st.State = "running"
+		st.PID = cmd.Process.Pid
+		st.Started = &started
+	})
+}
+
+// processOutput feeds the child's output to the console, the log tail, the
+// observers and the pipeline until both streams ended.
+func (s *supervisor) processOutput(stdout, stderr io.Reader) {
+	// Both streams are read at once so neither blocks the child, but their
+	// lines are processed one at a time.
+	lines := make(chan streamLine)
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go s.readLines(alerting.StreamStdout, stdout, lines, &readers)
+	go s.readLines(alerting.StreamStderr, stderr, lines, &readers)
+	go func() {
+		readers.Wait()
+		close(lines)
+	}()
+	for line := range lines {
+		matched := s.pipeline.ProcessStream(line.text, line.stream)
+		console, color := os.Stdout, s.colorStdout
+		if line.stream == alerting.StreamStderr {
+			console, color = os.Stderr, s.colorStderr
+		}
+		text := line.text
+		if color {
+			text = colorize(text, matched)
+		}
+		if s.name != "" {
+			fmt.Fprintf(console, "[%s] %s\n", s.name, text)
+		} else {
+			fmt.Fprintln(console, text)
+		}
+		s.tail.Add(line.text)
+		for _, observe := range s.observers {
+			observe(line.text)
+		}
+	}
+}
+
+// hookVars returns the environment of a hook: hookEnv, the version and vars.
+func (s *supervisor) hookVars(vars map[string]string) map[string]string {
+	env := map[string]string{"VERSION": s.Status().Version}
+	for _, m := range []map[string]string{s.hookEnv, vars} {
+		for key, value := range m {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// runHook runs a hook whose failure doesn't affect the child.
+func (s *supervisor) runHook(event string, vars map[string]string) {
+	if err := s.hooks.run(event, s.hookVars(vars)); err != nil {
+		s.logf("Error running hook: %v\n", err)
+		s.pipeline.Event("hook-failed", "WARNING", err.Error())
+	}
+}
+
+// exitEnv describes the exit of the child with pid for a hook.
+func exitEnv(pid int, err error) map[string]string {
+	env := map[string]string{"PID": strconv.Itoa(pid), "EXIT": "exited cleanly", "EXIT_CODE": "0"}
+	if err != nil {
+		env["EXIT"] = err.Error()
+		env["EXIT_CODE"] = ""
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			env["EXIT_CODE"] = strconv.Itoa(exitErr.ExitCode())
+		}
+	}
+	return env
+}
+
+// streamLine is a log line of the child and the stream it was written to.
+type streamLine struct {
+	stream string
+	text   string
+}
+
+// readLines sends the lines of one of the child's streams to lines.
+func (s *supervisor) readLines(stream string, r io.Reader, lines chan<- streamLine, readers *sync.WaitGroup) {
+	defer readers.Done()
+	scanner := alerting.NewLineScanner(r, s.maxLine)
+	for scanner.Scan() {
+		lines <- streamLine{stream: stream, text: scanner.Text()}
+	}
+	if err := scanner.Err(); err != nil {
+		s.logf("Error reading %s of cdk-erigon: %v\n", stream, err)
+	}
+}
+
+// forwardStop passes a stop or restart on to cmd until it exited.
+func (s *supervisor) forwardStop(cmd *exec.Cmd, exited chan struct{}) {
+	var sig os.Signal
+	select {
+	case <-s.stop:
+		if s.detached() {
+			return
+		}
+		s.statusMu.Lock()
+		sig = s.stopSignal
+		s.statusMu.Unlock()
+	case <-s.restart:
+		s.statusMu.Lock()
+		s.restarting = true
+		s.statusMu.Unlock()
+		sig = os.Interrupt
+	case <-exited:
+		return
+	}
+	s.logf("Forwarding %v to cdk-erigon, killing it if it hasn't exited within %s\n", sig, s.grace)
+	if err := cmd.Process.Signal(sig); err != nil {
+		s.logf("Error forwarding %v to cdk-erigon: %v\n", sig, err)
+		s.killChild(cmd)
+		return
+	}
+
+	select {
+	case <-exited:
+		return
+	case <-time.After(s.grace):
+		s.logf("cdk-erigon did not exit within %s, killing it\n", s.grace)
+	case <-s.kill:
+		s.logf("Stopped again, killing cdk-erigon\n")
+	}
+	s.killChild(cmd)
+}
+
+func (s *supervisor) killChild(cmd *exec.Cmd) {
+	killChild(cmd)
+	if s.container != "" {
+		removeContainer(s.container)
+	}
+}
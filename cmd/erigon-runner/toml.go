@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TOML configs, as vanilla erigon uses. Only what erigon configs need is
+// understood: key = value pairs with bare, quoted or dotted keys, [table]
+// headers, and strings, numbers, booleans and arrays as values. Keys in tables
+// and dotted keys are flattened to "table.key", like the flags they set.
+
+var (
+	tomlTableLine = regexp.MustCompile(`^\s*\[\s*([^\[\]]+?)\s*\]\s*(#.*)?$`)
+	tomlKeyLine   = regexp.MustCompile(`^(\s*)((?:"[^"]*"|'[^']*'|[\w-]+)(?:\s*\.\s*(?:"[^"]*"|'[^']*'|[\w-]+))*)(\s*=)(.*)$`)
+)
+
+// isTOML reports whether path is a TOML rather than a YAML config.
+func isTOML(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".toml")
+}
+
+// unmarshalConfig parses the settings of a config of either format.
+func unmarshalConfig(path, content string) (map[string]interface{}, error) {
+	if isTOML(path) {
+		return parseTOML(content)
+	}
+	var config map[string]interface{}
+	err := yaml.Unmarshal([]byte(content), &config)
+	return config, err
+}
+
+// tomlKey joins the parts of a dotted key, unquoting them.
+func tomlKey(key string) string {
+	var parts []string
+	for key != "" {
+		key = strings.TrimLeft(key, " \t.")
+		var part string
+		if key != "" && (key[0] == '"' || key[0] == '\'') {
+			end := strings.IndexByte(key[1:], key[0]) + 1
+			part, key = key[1:end], key[end+1:]
+		} else {
+			end := strings.IndexAny(key, " \t.")
+			if end < 0 {
+				end = len(key)
+			}
+			part, key = key[:end], key[end:]
+		}
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// tomlValue returns the text of the value starting a line and whether it's
+// an array left open, continued on the next lines.
+func tomlValue(value string) (string, bool) {
+	value, _ = splitComment(value)
+	value = strings.TrimSpace(value)
+	return value, strings.HasPrefix(value, "[") && strings.Count(value, "[") > strings.Count(value, "]")
+}
+
+// parseTOML parses the flattened settings of a TOML config.
+func parseTOML(content string) (map[string]interface{}, error) {
+	config := make(map[string]interface{})
+	table := ""
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if m := tomlTableLine.FindStringSubmatch(line); m != nil {
+			table = tomlKey(m[1])
+			continue
+		}
+		m := tomlKeyLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: expected key = value", i+1)
+		}
+		key := tomlKey(m[2])
+		if table != "" {
+			key = table + "." + key
+		}
+		value, open := tomlValue(m[4])
+		for open && i+1 < len(lines) {
+			i++
+			more, _ := splitComment(strings.TrimRight(lines[i], "\r"))
+			value += " " + strings.TrimSpace(more)
+			open = strings.Count(value, "[") > strings.Count(value, "]")
+		}
+		parsed, err := parseTOMLValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid %s: %w", i+1, key, err)
+		}
+		if _, ok := config[key]; ok {
+			return nil, fmt.Errorf("line %d: %s is set twice", i+1, key)
+		}
+		config[key] = parsed
+	}
+	return config, nil
+}
+
+func parseTOMLValue(value string) (interface{}, error) {
+	switch {
+	case value == "":
+		return nil, fmt.Errorf("value is missing")
+	case strings.HasPrefix(value, "["):
+		if !strings.HasSuffix(value, "]") {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		items := []interface{}{}
+		for _, item := range splitTOMLArray(value[1 : len(value)-1]) {
+			parsed, err := parseTOMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, parsed)
+		}
+		return items, nil
+	case strings.HasPrefix(value, `"`):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		return value[1 : len(value)-1], nil
+	case value == "true" || value == "false":
+		return value == "true", nil
+	}
+	number := strings.ReplaceAll(value, "_", "")
+	if n, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return int(n), nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	// Dates and anything else are kept as they are written.
+	return value, nil
+}
+
+// splitTOMLArray splits the items of an array, ignoring commas in strings and
+// nested arrays.
+func splitTOMLArray(items string) []string {
+	var split []string
+	var quote rune
+	depth, start := 0, 0
+	for i, c := range items {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			split = append(split, strings.TrimSpace(items[start:i]))
+			start = i + 1
+		}
+	}
+	// A trailing comma is allowed.
+	if last := strings.TrimSpace(items[start:]); last != "" {
+		split = append(split, last)
+	}
+	return split
+}
+
+// rewriteTOMLPorts is rewritePorts for a TOML config, replacing the port
+// numbers of the flattened keys in ports, whether inline or in an array
+// continued on the next lines.
+func rewriteTOMLPorts(content string, ports map[string][]int) (string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	remaining := make(map[string][]int, len(ports))
+	for key, keyPorts := range ports {
+		remaining[key] = keyPorts
+	}
+	table := ""
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		if m := tomlTableLine.FindStringSubmatch(line); m != nil {
+			table = tomlKey(m[1])
+			continue
+		}
+		m := tomlKeyLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		key := tomlKey(m[2])
+		if table != "" {
+			key = table + "." + key
+		}
+		keyPorts, ok := remaining[key]
+		if !ok {
+			continue
+		}
+		value, rest := splitComment(m[4])
+		_, open := tomlValue(value)
+		value, keyPorts = replacePorts(value, keyPorts)
+		lines[i] = m[1] + m[2] + m[3] + value + rest + lineEnding(lines[i])
+		for open && i+1 < len(lines) {
+			i++
+			item, rest := splitComment(strings.TrimRight(lines[i], "\r\n"))
+			open = !strings.Contains(item, "]")
+			item, keyPorts = replacePorts(item, keyPorts)
+			lines[i] = item + rest + lineEnding(lines[i])
+		}
+		if len(keyPorts) > 0 {
+			return "", fmt.Errorf("%s has fewer ports than expected", key)
+		}
+		delete(remaining, key)
+	}
+	for key := range remaining {
+		return "", fmt.Errorf("%s not found", key)
+	}
+	return strings.Join(lines, ""), nil
+}
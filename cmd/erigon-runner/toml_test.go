@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewriteTOMLPorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		ports   map[string][]int
+		want    string
+	}{
+		{
+			name:    "top level keys",
+			content: "datadir = \"/data\"\n\"http.port\" = 8545\nprivate.api.addr = \"localhost:9090\" # grpc\n",
+			ports:   map[string][]int{"http.port": {8645}, "private.api.addr": {9190}},
+			want:    "datadir = \"/data\"\n\"http.port\" = 8645\nprivate.api.addr = \"localhost:9190\" # grpc\n",
+		},
+		{
+			name:    "tables",
+			content: "[http]\nport = 8545\n\n[torrent]\nport = 42069\n",
+			ports:   map[string][]int{"http.port": {8645}, "torrent.port": {42169}},
+			want:    "[http]\nport = 8645\n\n[torrent]\nport = 42169\n",
+		},
+		{
+			name:    "multiline array",
+			content: "p2p.allowed-ports = [\r\n  30303, # first\r\n  30304,\r\n]\r\nport = 30303\r\n",
+			ports:   map[string][]int{"p2p.allowed-ports": {30403, 30404}},
+			want:    "p2p.allowed-ports = [\r\n  30403, # first\r\n  30404,\r\n]\r\nport = 30303\r\n",
+		},
+		{
+			name:    "inline array",
+			content: "p2p.allowed-ports = [30303, 30304]\n",
+			ports:   map[string][]int{"p2p.allowed-ports": {30403, 30404}},
+			want:    "p2p.allowed-ports = [30403, 30404]\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := rewriteTOMLPorts(tt.content, tt.ports)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: rewriteTOMLPorts =\n%q\nwant\n%q", tt.name, got, tt.want)
+		}
+		// The rewritten config still parses.
+		if _, err := parseTOML(got); err != nil {
+			t.Errorf("%s: rewritten config: %v", tt.name, err)
+		}
+	}
+
+	if _, err := rewriteTOMLPorts("http.port = 8545\n", map[string][]int{"ws.port": {8546}}); err == nil {
+		t.Error("a missing key should fail")
+	}
+	if _, err := rewriteTOMLPorts("p2p.allowed-ports = [30303]\n", map[string][]int{"p2p.allowed-ports": {30403, 30404}}); err == nil {
+		t.Error("too few ports should fail")
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	content := `# erigon config
+datadir = "/data"
+"zkevm.l2-chain-id" = 1_101
+http.api = ["eth", "net",
+  "zkevm"]
+
+[txpool]
+'price.limit' = 1.5
+disable = false
+`
+	want := map[string]interface{}{
+		"datadir":            "/data",
+		"zkevm.l2-chain-id":  1101,
+		"http.api":           []interface{}{"eth", "net", "zkevm"},
+		"txpool.price.limit": 1.5,
+		"txpool.disable":     false,
+	}
+	got, err := parseTOML(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOML = %v, want %v", got, want)
+	}
+
+	for _, content := range []string{"datadir\n", "a = 1\na = 2\n", "a = [1, 2\n", "a = 'open\n"} {
+		if _, err := parseTOML(content); err == nil {
+			t.Errorf("parseTOML(%q) should fail", content)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// updateFailures is how many exits of an updated binary within the verify
+// window count as a crash loop and roll the update back.
+const updateFailures = 2
+
+// updater polls the upstream branch, and builds and restarts into new
+// commits, rolling back to the previous binary when the new one doesn't come
+// up healthy.
+type updater struct {
+	repo     string
+	branch   string
+	binary   string
+	pipeline *alerting.Pipeline
+	// nodes are restarted into an update together, and their RPC has to
+	// answer once the verify window is over.
+	nodes    []*node
+	stop     chan struct{}
+	interval time.Duration
+	verify   time.Duration
+	build    buildOptions
+
+	// snapshot copies the datadirs while the nodes are stopped for an
+	// update, and restores them when it is rolled back. Only the newest
+	// snapshotKeep snapshots are kept, in snapshotDir or next to the datadirs.
+	snapshot     bool
+	snapshotDir  string
+	snapshotKeep int
+
+	current string
+	// failed is the last commit that was rolled back, so it isn't retried.
+	failed string
+}
+
+// followedBranch returns the branch to follow, defaulting to ref and then to
+// the checked out branch, and the commit running now.
+func followedBranch(repo, branch, ref string) (string, string, error) {
+	head, dirty, err := gitHead(repo)
+	if err != nil {
+		return "", "", err
+	}
+	if dirty {
+		return "", "", fmt.Errorf("worktree of %s has uncommitted changes", repo)
+	}
+	if branch == "" {
+		branch = ref
+	}
+	if branch == "" {
+		branch, err = git(repo, "rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return "", "", err
+		}
+		if branch == "HEAD" {
+			return "", "", fmt.Errorf("HEAD is detached, set -update-branch")
+		}
+	}
+	return branch, head, nil
+}
+
+func (u *updater) run() {
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-ticker.C:
+		}
+		u.check()
+	}
+}
+
+func (u *updater) check() {
+	if _, err := git(u.repo, "fetch", "--quiet", "origin", u.branch); err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking for updates: %v\n", err)
+		return
+	}
+	latest, err := git(u.repo, "rev-parse", "--verify", "origin/"+u.branch+"^{commit}")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking for updates: %v\n", err)
+		return
+	}
+	if latest == u.current || latest == u.failed {
+		return
+	}
+	u.update(latest)
+}
+
+func (u *updater) update(commit string) {
+	from, to := describe(u.repo, u.current), describe(u.repo, commit)
+	fmt.Printf("Updating cdk-erigon from %s to %s\n", from, to)
+
+	binary := filepath.Join(u.repo, u.binary)
+	backup := binary + ".previous"
+	if err := copyFile(binary, backup); err != nil {
+		u.fail(commit, fmt.Sprintf("Not updating cdk-erigon from %s to %s, failed to back up the binary: %v", from, to, err))
+		return
+	}
+	if _, err := git(u.repo, "checkout", "--quiet", "--detach", commit); err != nil {
+		u.fail(commit, fmt.Sprintf("Not updating cdk-erigon from %s to %s: %v", from, to, err))
+		return
+	}
+	if err := build(u.repo, commit, u.build); err != nil {
+		u.rollback(commit, backup, nil, fmt.Sprintf("Not updating cdk-erigon from %s to %s: %v", from, to, err))
+		return
+	}
+
+	before := make([]int, len(u.nodes))
+	snapshots := make([]string, len(u.nodes))
+	prepared := make(map[*supervisor]chan struct{})
+	for i, n := range u.nodes {
+		before[i] = n.supervisor.Status().Restarts
+		if !u.snapshot || n.datadir == "" {
+			n.supervisor.Restart()
+			continue
+		}
+		i, n := i, n
+		ready := make(chan struct{})
+		prepared[n.supervisor] = ready
+		n.supervisor.RestartAfter(func() {
+			defer close(ready)
+			fmt.Printf("Snapshotting %s before starting %s\n", n.datadir, to)
+			snapshot, err := snapshotDatadir(n.datadir, u.snapshotDir, time.Now())
+			if err != nil {
+				message := fmt.Sprintf("%sUpdating to %s without a snapshot of the datadir: %v", nodePrefix(n.name), to, err)
+				fmt.Fprintln(os.Stderr, message)
+				u.pipeline.Event("snapshot-failed", "WARNING", message)
+				return
+			}
+			fmt.Println("Snapshotted datadir to", snapshot)
+			snapshots[i] = snapshot
+			pruneSnapshots(n.datadir, u.snapshotDir, u.snapshotKeep)
+		})
+	}
+	// The verify window starts once the nodes run again. A supervisor that
+	// already gave up never prepares its restart, which the verify window
+	// then reports as unhealthy.
+	for s, ready := range prepared {
+		select {
+		case <-ready:
+		case <-s.done:
+		case <-u.stop:
+			return
+		}
+	}
+	if err := u.healthy(before); err != nil {
+		u.rollback(commit, backup, snapshots, fmt.Sprintf("Rolled cdk-erigon back from %s to %s: %v", to, from, err))
+		return
+	}
+	u.current = commit
+	message := fmt.Sprintf("Updated cdk-erigon from %s to %s", from, to)
+	fmt.Println(message)
+	u.pipeline.Event("updated", "INFO", message)
+}
+
+// healthy waits out the verify window and fails when a restarted node
+// crash-loops in the meantime or its RPC doesn't answer at the end of it.
+func (u *updater) healthy(restartsBefore []int) error {
+	deadline := time.After(u.verify)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.stop:
+			return nil
+		case <-deadline:
+			for _, n := range u.nodes {
+				if _, err := n.rpc.callUint64("eth_blockNumber"); err != nil {
+					return fmt.Errorf("%sRPC unhealthy after %s: %w", nodePrefix(n.name), u.verify, err)
+				}
+			}
+			return nil
+		case <-ticker.C:
+			for i, n := range u.nodes {
+				if exits := n.supervisor.Status().Restarts - restartsBefore[i]; exits >= updateFailures {
+					return fmt.Errorf("%sexited %d times within %s", nodePrefix(n.name), exits, u.verify)
+				}
+			}
+		}
+	}
+}
+
+// rollback restores the previous binary and commit, restarting the nodes when
+// they already run the new binary, which is the case when snapshots is set.
+// Nodes with a snapshot get their datadir restored while stopped.
+func (u *updater) rollback(commit, backup string, snapshots []string, message string) {
+	u.failed = commit
+	if err := copyFile(backup, filepath.Join(u.repo, u.binary)); err != nil {
+		message += fmt.Sprintf("\n\nFailed to restore the previous binary: %v", err)
+	}
+	if _, err := git(u.repo, "checkout", "--quiet", "--detach", u.current); err != nil {
+		message += fmt.Sprintf("\n\nFailed to check out %s again: %v", u.current, err)
+	}
+	writeStamp(u.repo, u.current)
+	for i, n := range u.nodes {
+		switch {
+		case i >= len(snapshots):
+		case snapshots[i] == "":
+			n.supervisor.Restart()
+		default:
+			n, snapshot := n, snapshots[i]
+			n.supervisor.RestartAfter(func() {
+				aside, err := restoreSnapshot(n.datadir, snapshot, time.Now())
+				if err != nil {
+					message := fmt.Sprintf("%sFailed to restore the datadir snapshot after rolling back: %v", nodePrefix(n.name), err)
+					fmt.Fprintln(os.Stderr, message)
+					u.pipeline.Event("snapshot-restore-failed", "CRITICAL", message)
+					return
+				}
+				fmt.Printf("Restored %s from %s, the updated datadir was moved to %s\n", n.datadir, snapshot, aside)
+			})
+		}
+	}
+	fmt.Fprintln(os.Stderr, message)
+	u.pipeline.Event("update-failed", "CRITICAL", message)
+}
+
+func (u *updater) fail(commit, message string) {
+	u.failed = commit
+	fmt.Fprintln(os.Stderr, message)
+	u.pipeline.Event("update-failed", "CRITICAL", message)
+}
+
+// describe names commit by its closest tag where possible.
+func describe(repo, commit string) string {
+	name, err := git(repo, "describe", "--tags", "--always", commit)
+	if err != nil {
+		return commit
+	}
+	return name
+}
+
+// copyFile copies src over dst through a temporary file, which also works
+// while dst is being executed.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// uploadTimeout bounds the upload of a bundle, which delays the restart after
+// a crash.
+const uploadTimeout = 5 * time.Minute
+
+// bucketURL is an s3:// or gs:// bucket and prefix bundles are uploaded to.
+type bucketURL struct {
+	scheme string
+	bucket string
+	prefix string
+}
+
+func parseBucketURL(url string) (*bucketURL, error) {
+	scheme, rest, ok := strings.Cut(url, "://")
+	if !ok || (scheme != "s3" && scheme != "gs") {
+		return nil, fmt.Errorf("invalid bucket URL %q, want s3://bucket/prefix or gs://bucket/prefix", url)
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid bucket URL %q, bucket is missing", url)
+	}
+	return &bucketURL{scheme: scheme, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+// upload copies the file at src into the bucket with the aws or gcloud CLI,
+// which take the credentials from the environment, and returns the HTTPS URL
+// of the object.
+func (b *bucketURL) upload(src string) (string, error) {
+	key := path.Join(b.prefix, filepath.Base(src))
+	object := b.scheme + "://" + b.bucket + "/" + key
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+	var cmd *exec.Cmd
+	if b.scheme == "s3" {
+		cmd = exec.CommandContext(ctx, "aws", "s3", "cp", "--only-show-errors", src, object)
+	} else {
+		cmd = exec.CommandContext(ctx, "gcloud", "storage", "cp", "--no-user-output-enabled", src, object)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("upload to %s timed out after %s", object, uploadTimeout)
+		}
+		return "", fmt.Errorf("failed to upload to %s: %w: %s", object, err, strings.TrimSpace(string(out)))
+	}
+	if b.scheme == "s3" {
+		return "https://" + b.bucket + ".s3.amazonaws.com/" + key, nil
+	}
+	return "https://storage.cloud.google.com/" + b.bucket + "/" + key, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// versionMetadata is the alert metadata key the node's version is sent under.
+const versionMetadata = "cdk-erigon version"
+
+var versionPrefix = regexp.MustCompile(`(?i)^.*\bversion\s+`)
+
+// binaryVersion runs name with args and --version and returns the version it
+// reports, e.g. 2.61.0-dev-5d4a8b3c.
+func binaryVersion(dir, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, append(args, "--version")...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s --version: %w", name, err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return versionPrefix.ReplaceAllString(line, ""), nil
+		}
+	}
+	return "", fmt.Errorf("%s --version printed nothing", name)
+}
+
+// detectVersion determines the version of the cdk-erigon about to start:
+// the one the binary reports along with the commit checked out in the
+// repository, or the image and its version in docker mode.
+func (n *node) detectVersion() string {
+	s := n.supervisor
+	if n.image != "" {
+		// An image doesn't change, so it is only run once.
+		if n.version == "" {
+			version, err := binaryVersion(s.dir, "docker", "run", "--rm", n.image)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error detecting the cdk-erigon version: %v\n", err)
+				return n.image
+			}
+			return n.image + " (" + version + ")"
+		}
+		return n.version
+	}
+	version, err := binaryVersion(s.dir, s.binary)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error detecting the cdk-erigon version: %v\n", err)
+		version = "unknown"
+	}
+	if head, dirty, err := gitHead(s.dir); err == nil {
+		if len(head) > 12 {
+			head = head[:12]
+		}
+		if dirty {
+			head += ", dirty"
+		}
+		version += " (commit " + head + ")"
+	}
+	return version
+}
+
+// setVersion records version in the status and the metadata of alerts.
+func (n *node) setVersion(version string) {
+	if version == n.version {
+		return
+	}
+	n.version = version
+	fmt.Printf("%scdk-erigon version %s\n", nodePrefix(n.name), version)
+	n.pipeline.SetMetadata(versionMetadata, version)
+	n.supervisor.setStatus(func(st *childStatus) { st.Version = version })
+}
+
+// observeBuildInfo takes the version from the build info cdk-erigon logs at
+// startup, which is what actually runs.
+func (n *node) observeBuildInfo(line string) {
+	if !strings.Contains(line, "Build info") {
+		return
+	}
+	parsed, ok := alerting.ParseLogLine(line)
+	if !ok {
+		return
+	}
+	version := parsed.Fields["git_tag"]
+	if version == "" {
+		version = parsed.Fields["git_branch"]
+	}
+	commit := parsed.Fields["git_commit"]
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	switch {
+	case version != "" && commit != "":
+		version += " (commit " + commit + ")"
+	case commit != "":
+		version = "commit " + commit
+	case version == "":
+		return
+	}
+	if n.image != "" {
+		version = n.image + " (" + version + ")"
+	}
+	n.setVersion(version)
+}
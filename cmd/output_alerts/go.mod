@@ -0,0 +1,15 @@
+module github.com/revitteth/scripts/cmd/output_alerts
+
+go 1.20
+
+require github.com/revitteth/scripts/internal v0.0.0-00010101000000-000000000000
+
+require golang.org/x/sys v0.30.0
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/redis/go-redis/v9 v9.7.3 // indirect
+)
+
+replace github.com/revitteth/scripts/internal => ../../internal
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// installOptions are the flags only understood by the install subcommand.
+type installOptions struct {
+	name    string
+	enable  bool
+	unitDir string
+}
+
+// runInstall registers output_alerts as a system service that runs with the
+// run flags given to install, e.g. `output_alerts install -config /etc/oa.json`.
+func runInstall(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	install := &installOptions{}
+	fs.StringVar(&install.name, "name", "output_alerts", "Service name")
+	fs.BoolVar(&install.enable, "enable", false, "Enable and start the service after installing it")
+	fs.StringVar(&install.unitDir, "unit-dir", "/etc/systemd/system", "Directory the systemd unit is written to (Linux only)")
+	opts := registerRunFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	configFile, err := filepath.Abs(opts.configFile)
+	if err != nil {
+		return fmt.Errorf("failed to resolve config path: %w", err)
+	}
+	opts.configFile = configFile
+	warnStdinServices(configFile)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	return installService(install, exe, serviceArgs(fs, opts))
+}
+
+// serviceArgs rebuilds the run flags that were explicitly set, always
+// including the absolute config path.
+func serviceArgs(fs *flag.FlagSet, opts *runOptions) []string {
+	args := []string{"-config", opts.configFile}
+	run := flag.NewFlagSet("run", flag.ContinueOnError)
+	registerRunFlags(run)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || run.Lookup(f.Name) == nil {
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+	})
+	return args
+}
+
+// warnStdinServices points out services that read stdin, which is not
+// connected to anything when running as a system service.
+func warnStdinServices(configFile string) {
+	config, err := alerting.ReadConfig(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return
+	}
+	if len(config.Services) == 0 {
+		fmt.Fprintln(os.Stderr, "Warning: config has no services with an input file; a system service has no stdin to read")
+		return
+	}
+	for _, s := range config.Services {
+		if s.Input == "" || s.Input == "-" {
+			fmt.Fprintf(os.Stderr, "Warning: service %s reads stdin, which a system service doesn't have\n", s.Name)
+		}
+	}
+}
@@ -1,198 +1,235 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
-	"regexp"
+	"os/signal"
 	"sync"
-	"time"
-)
+	"syscall"
 
-const (
-	LogLevelThreshold = "ERROR"
+	"github.com/revitteth/scripts/internal/alerting"
 )
 
-type PatternConfig struct {
-	Pattern        string `json:"pattern"`
-	TimeoutMinutes int    `json:"timeoutMinutes"`
+// service is a named input together with the pipeline alerting on it.
+type service struct {
+	name     string
+	input    string
+	maxLine  int
+	pipeline *alerting.Pipeline
 }
 
-type Config struct {
-	WebhookURL            string          `json:"webhookURL"`
-	Patterns              []PatternConfig `json:"patterns"`
-	LogFile               string          `json:"logFile"`
-	AlertCooldownMinutes  int             `json:"alertCooldownMinutes"`
-	DefaultTimeoutMinutes int             `json:"defaultTimeoutMinutes"`
+// runOptions are the flags of a normal run. They are shared with the install
+// subcommand so an installed service runs with the same flags.
+type runOptions struct {
+	configFile string
+	msgPrefix  string
+	emitJSON   bool
+	jsonFD     int
+	dryRun     bool
 }
 
-type AlertManager struct {
-	sentAlerts        map[string]time.Time
-	suppressionCounts map[string]int
-	mu                sync.Mutex
-	defaultCooldown   time.Duration
-	patternCooldowns  map[string]time.Duration
+func registerRunFlags(fs *flag.FlagSet) *runOptions {
+	opts := &runOptions{}
+	fs.StringVar(&opts.configFile, "config", "config.json", "Path to the configuration file")
+	fs.StringVar(&opts.msgPrefix, "msg", "", "Chat message prefix")
+	fs.BoolVar(&opts.emitJSON, "emit-json", false, "Print every fired alert as a JSON object")
+	fs.IntVar(&opts.jsonFD, "json-fd", 1, "File descriptor for -emit-json output; when 1, passed-through log lines go to stderr instead")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "Log alerts to stderr instead of sending them or running their actions")
+	return opts
 }
 
-func NewAlertManager(defaultCooldown time.Duration, patternCooldowns map[string]time.Duration) *AlertManager {
-	return &AlertManager{
-		sentAlerts:        make(map[string]time.Time),
-		suppressionCounts: make(map[string]int),
-		defaultCooldown:   defaultCooldown,
-		patternCooldowns:  patternCooldowns,
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "install" {
+		if err := runInstall(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error installing service: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-}
-
-func (am *AlertManager) ShouldSendAlert(pattern string) (bool, int) {
-	am.mu.Lock()
-	defer am.mu.Unlock()
-
-	now := time.Now()
-	key := pattern
 
-	cooldown, exists := am.patternCooldowns[pattern]
-	if !exists {
-		cooldown = am.defaultCooldown
-	}
+	opts := registerRunFlags(flag.CommandLine)
+	replay := &replayOptions{}
+	flag.StringVar(&replay.file, "replay", "", "Replay a log file through the patterns in dry-run and report which alerts would fire")
+	flag.StringVar(&replay.speed, "speed", "max", "Replay speed relative to the log timestamps, e.g. 10x; max replays without delay")
+	flag.StringVar(&replay.service, "service", "", "Service whose patterns -replay uses when the config defines several")
+	flag.Parse()
 
-	if lastSent, exists := am.sentAlerts[key]; exists {
-		if now.Sub(lastSent) < cooldown {
-			am.suppressionCounts[key]++
-			return false, am.suppressionCounts[key]
+	if isService() {
+		if err := runService(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running as a service: %v\n", err)
+			os.Exit(1)
 		}
+		return
 	}
 
-	suppressionCount := am.suppressionCounts[key]
-	am.sentAlerts[key] = now
-	am.suppressionCounts[key] = 0
-	return true, suppressionCount
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Restore default signal handling so a second signal exits immediately.
+		<-ctx.Done()
+		stop()
+	}()
 
-func (am *AlertManager) GetSuppressionCount(pattern string) int {
-	am.mu.Lock()
-	defer am.mu.Unlock()
-	return am.suppressionCounts[pattern]
-}
-
-func readConfig(filePath string) (*Config, error) {
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %w", filePath, err)
-	}
-	var config Config
-	err = json.Unmarshal(content, &config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse config file %s: %w", filePath, err)
+	if replay.file != "" {
+		if err := runReplay(ctx, opts, replay); err != nil {
+			fmt.Fprintf(os.Stderr, "Error replaying log: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
-	return &config, nil
+	run(ctx, opts)
 }
 
-func sendGoogleChatAlert(webhookURL, msgPrefix, log string, suppressionCount int) {
-	msgContent := fmt.Sprintf("%s\n%s", msgPrefix, log)
-	if suppressionCount > 0 {
-		msgContent = fmt.Sprintf("%s\nSuppressed %d duplicate(s)", msgContent, suppressionCount)
-	}
-	message := map[string]string{"text": msgContent}
-	messageBytes, err := json.Marshal(message)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating JSON message: %v\n", err)
-		return
+// run alerts on the configured inputs until they are exhausted or ctx is
+// cancelled, then flushes and closes every pipeline.
+func run(ctx context.Context, opts *runOptions) {
+	// Keep stdout clean for the JSON stream when it shares the descriptor.
+	var console io.Writer = os.Stdout
+	var emitter *alerting.JSONEmitter
+	if opts.emitJSON {
+		if opts.jsonFD == 1 {
+			console = os.Stderr
+		}
+		emitter = alerting.NewJSONEmitter(os.NewFile(uintptr(opts.jsonFD), "json"))
 	}
 
-	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(messageBytes))
+	fmt.Fprintln(console, "prefix:", opts.msgPrefix)
+
+	hostname, err := os.Hostname()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
+		fmt.Printf("Error getting hostname: %v\n", err)
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
+	fmt.Fprintf(console, "Hostname: %s\n", hostname)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	config, err := alerting.ReadConfig(opts.configFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error sending alert: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error reading config file: %v\n", err)
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode > 299 {
-		fmt.Println("Alert sent to Google Chat, response status:", resp.Status)
+
+	// Without service sections the top level config is a single service
+	// reading stdin.
+	serviceConfigs := config.Services
+	if len(serviceConfigs) == 0 {
+		serviceConfigs = []alerting.ServiceConfig{{Config: *config}}
 	}
-}
 
-func logToFile(log, logFile, msgPrefix string) {
-	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	services, err := newServices(serviceConfigs, alerting.Options{
+		Hostname: hostname,
+		Prefix:   opts.msgPrefix,
+		Emitter:  emitter,
+		DryRun:   opts.dryRun,
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
-	defer file.Close()
-	l := fmt.Sprintf("%s %s \n", msgPrefix, log)
-	_, err = file.WriteString(l)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing to file: %v\n", err)
+
+	var consoleMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, s := range services {
+		wg.Add(1)
+		go func(s *service) {
+			defer wg.Done()
+			handle := func(log string) {
+				if ctx.Err() != nil {
+					return
+				}
+				consoleMu.Lock()
+				if s.name != "" && len(services) > 1 {
+					fmt.Fprintf(console, "[%s] %s\n", s.name, log)
+				} else {
+					fmt.Fprintln(console, log)
+				}
+				consoleMu.Unlock()
+				s.pipeline.Process(log)
+			}
+			if err := readInput(ctx, s.input, s.maxLine, handle); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading input of service %s: %v\n", s.name, err)
+			}
+		}(s)
 	}
-}
+	wg.Wait()
 
-func searchLog(log string, patterns []*regexp.Regexp) (bool, string) {
-	for _, pattern := range patterns {
-		if pattern.MatchString(log) {
-			return true, pattern.String()
+	closeServices(services)
+	for _, s := range services {
+		for pattern, count := range s.pipeline.Counts() {
+			if s.name != "" {
+				fmt.Fprintf(os.Stderr, "[%s] ", s.name)
+			}
+			fmt.Fprintf(os.Stderr, "Pattern %s matched %d time(s)\n", pattern, count)
 		}
 	}
-	return false, ""
 }
 
-func main() {
-	configFile := flag.String("config", "config.json", "Path to the configuration file")
-	msgPrefix := flag.String("msg", "", "Chat message prefix")
-	flag.Parse()
-
-	fmt.Println("prefix:", *msgPrefix)
-
-	hostname, err := os.Hostname()
-	if err != nil {
-		fmt.Printf("Error getting hostname: %v\n", err)
-		return
+// newServices sets up the pipeline of every service with pipelineOpts, each
+// on its own copy of the service's config.
+func newServices(serviceConfigs []alerting.ServiceConfig, pipelineOpts alerting.Options) ([]*service, error) {
+	var services []*service
+	stdinUsers := 0
+	for _, sc := range serviceConfigs {
+		if sc.Input == "" || sc.Input == "-" {
+			stdinUsers++
+		}
+		// The pipeline keeps the config, which must not be the loop variable.
+		config := sc.Config
+		opts := pipelineOpts
+		opts.Service = sc.Name
+		pipeline, err := alerting.NewPipeline(&config, opts)
+		if err != nil {
+			closeServices(services)
+			return nil, fmt.Errorf("failed to set up alerting for service %s: %w", sc.Name, err)
+		}
+		services = append(services, &service{name: sc.Name, input: sc.Input, maxLine: sc.MaxLineBytes, pipeline: pipeline})
 	}
-	fmt.Printf("Hostname: %s\n", hostname)
-
-	config, err := readConfig(*configFile)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading config file: %v\n", err)
-		return
+	if stdinUsers > 1 {
+		closeServices(services)
+		return nil, fmt.Errorf("only one service can read standard input")
 	}
+	return services, nil
+}
 
-	regexPatterns := make([]*regexp.Regexp, len(config.Patterns))
-	patternCooldowns := make(map[string]time.Duration)
-	for i, patternConfig := range config.Patterns {
-		regexPatterns[i] = regexp.MustCompile(patternConfig.Pattern)
-		if patternConfig.TimeoutMinutes == 0 {
-			patternCooldowns[patternConfig.Pattern] = 24 * time.Hour * 365 * 100 // effectively never
-		} else {
-			patternCooldowns[patternConfig.Pattern] = time.Duration(patternConfig.TimeoutMinutes) * time.Minute
-		}
+func closeServices(services []*service) {
+	for _, s := range services {
+		s.pipeline.Close()
 	}
+}
 
-	defaultCooldown := time.Duration(config.DefaultTimeoutMinutes) * time.Minute
-	alertManager := NewAlertManager(defaultCooldown, patternCooldowns)
-
-	prefixString := fmt.Sprintf("[%s]: %s", hostname, *msgPrefix)
-
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		log := scanner.Text()
-		fmt.Println(log)
-		logToFile(log, config.LogFile, *msgPrefix)
-		if match, pattern := searchLog(log, regexPatterns); match {
-			if shouldSend, suppressionCount := alertManager.ShouldSendAlert(pattern); shouldSend {
-				sendGoogleChatAlert(config.WebhookURL, prefixString, log, suppressionCount)
+// readInput feeds lines from stdin ("" or "-") or a followed file to handle
+// until the input ends or ctx is cancelled.
+func readInput(ctx context.Context, input string, maxLine int, handle func(string)) error {
+	if input != "" && input != "-" {
+		return tailFile(ctx, input, maxLine, handle)
+	}
+
+	// The scanner may stay blocked on stdin after ctx is cancelled, so lines
+	// are handled here, never once readInput returned and the pipelines
+	// are closed.
+	scanner := alerting.NewLineScanner(os.Stdin, maxLine)
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
 			}
 		}
-	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading standard input: %v\n", err)
+	}()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return scanner.Err()
+			}
+			handle(line)
+		case <-ctx.Done():
+			return nil
+		}
 	}
 }
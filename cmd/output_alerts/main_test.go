@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+func TestNewServicesKeepTheirConfigs(t *testing.T) {
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	serviceConfigs := []alerting.ServiceConfig{
+		{Name: "node", Input: "node.log", Config: alerting.Config{
+			WebhookURL: server.URL + "/node",
+			Patterns:   []alerting.PatternConfig{{Pattern: "bad batch"}},
+		}},
+		{Name: "bridge", Input: "bridge.log", Config: alerting.Config{
+			WebhookURL: server.URL + "/bridge",
+			Patterns:   []alerting.PatternConfig{{Pattern: "deposit failed"}},
+		}},
+	}
+	services, err := newServices(serviceConfigs, alerting.Options{Hostname: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services[0].pipeline.Process("bad batch 42")
+	services[1].pipeline.Process("deposit failed 7")
+	closeServices(services)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if hits["/node"] != 1 || hits["/bridge"] != 1 {
+		t.Errorf("webhook hits = %v, want one each on /node and /bridge", hits)
+	}
+}
+
+func TestNewServicesSingleStdin(t *testing.T) {
+	serviceConfigs := []alerting.ServiceConfig{{Name: "a"}, {Name: "b", Input: "-"}}
+	if _, err := newServices(serviceConfigs, alerting.Options{}); err == nil {
+		t.Error("two services reading stdin accepted")
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// replayOptions are the flags of -replay, which runs a log file through the
+// pipeline in dry-run to check patterns against past incidents.
+type replayOptions struct {
+	file    string
+	speed   string
+	service string
+}
+
+// parseSpeed turns "10x", "10" or "max" into a replay speed factor, where 0
+// means replaying as fast as possible.
+func parseSpeed(s string) (float64, error) {
+	s = strings.ToLower(s)
+	if s == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || speed < 0 {
+		return 0, fmt.Errorf("invalid speed %q", s)
+	}
+	return speed, nil
+}
+
+// replayConfig picks the config of the service to replay and strips
+// everything that would touch the outside world besides the (disabled)
+// webhook: the control API, history and output files.
+func replayConfig(config *alerting.Config, name string) (alerting.Config, error) {
+	selected := *config
+	if len(config.Services) > 0 {
+		found := false
+		for _, sc := range config.Services {
+			if sc.Name == name || (name == "" && len(config.Services) == 1) {
+				selected = sc.Config
+				found = true
+				break
+			}
+		}
+		if !found {
+			if name == "" {
+				return alerting.Config{}, fmt.Errorf("config has several services, pick one with -service")
+			}
+			return alerting.Config{}, fmt.Errorf("unknown service %s", name)
+		}
+	} else if name != "" {
+		return alerting.Config{}, fmt.Errorf("config has no services, -service %s does not apply", name)
+	}
+
+	selected.ControlAddr = ""
+	selected.HistoryFile = ""
+	selected.LogFile = ""
+	selected.Patterns = append([]alerting.PatternConfig(nil), selected.Patterns...)
+	for i := range selected.Patterns {
+		selected.Patterns[i].OutputFile = ""
+	}
+	return selected, nil
+}
+
+func runReplay(ctx context.Context, opts *runOptions, replay *replayOptions) error {
+	speed, err := parseSpeed(replay.speed)
+	if err != nil {
+		return err
+	}
+	config, err := alerting.ReadConfig(opts.configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	selected, err := replayConfig(config, replay.service)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(replay.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hostname, _ := os.Hostname()
+	// Lines are timed by their own timestamps; lines without one keep the
+	// time of the last line that had one.
+	now := time.Now()
+	fired := make(map[string]int)
+	pipeline, err := alerting.NewPipeline(&selected, alerting.Options{
+		Hostname: hostname,
+		Prefix:   opts.msgPrefix,
+		Service:  replay.service,
+		DryRun:   true,
+		Clock:    func() time.Time { return now },
+		OnAlert:  func(a alerting.Alert) { fired[a.Pattern]++ },
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up alerting: %w", err)
+	}
+
+	lines := 0
+	var last time.Time
+	scanner := alerting.NewLineScanner(file, selected.MaxLineBytes)
+	for scanner.Scan() && ctx.Err() == nil {
+		line := scanner.Text()
+		lines++
+		if t, ok := alerting.ParseLogTime(line, now); ok {
+			if speed > 0 && !last.IsZero() && t.After(last) {
+				select {
+				case <-time.After(time.Duration(float64(t.Sub(last)) / speed)):
+				case <-ctx.Done():
+				}
+			}
+			now, last = t, t
+		}
+		pipeline.Process(line)
+	}
+	pipeline.Close()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", replay.file, err)
+	}
+
+	counts := pipeline.Counts()
+	patterns := make([]string, 0, len(counts))
+	for pattern := range counts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	fmt.Printf("Replayed %d line(s) from %s\n", lines, replay.file)
+	for _, pattern := range patterns {
+		fmt.Printf("Pattern %s matched %d time(s), fired %d alert(s)\n", pattern, counts[pattern], fired[pattern])
+	}
+	return nil
+}
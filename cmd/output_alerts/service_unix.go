@@ -0,0 +1,53 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/revitteth/scripts/internal/systemd"
+)
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=output_alerts log alerting ({{.Name}})
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart={{.ExecStart}}
+WorkingDirectory={{.WorkingDirectory}}
+Restart=on-failure
+RestartSec=5
+KillSignal=SIGTERM
+TimeoutStopSec=30
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func installService(install *installOptions, exe string, args []string) error {
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	unitPath, err := systemd.WriteUnit(install.unitDir, install.name, unitTemplate, map[string]string{
+		"Name":             install.name,
+		"ExecStart":        systemd.ExecStart(exe, args),
+		"WorkingDirectory": workingDirectory,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("Wrote", unitPath)
+	return systemd.Enable(install.name, install.enable)
+}
+
+func isService() bool {
+	return false
+}
+
+func runService(opts *runOptions) error {
+	return fmt.Errorf("not supported on this platform")
+}
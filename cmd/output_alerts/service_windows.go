@@ -0,0 +1,82 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func installService(install *installOptions, exe string, args []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(install.name, exe, mgr.Config{
+		DisplayName: "output_alerts (" + install.name + ")",
+		Description: "Log pattern alerting",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+	fmt.Println("Registered Windows service", install.name)
+
+	if install.enable {
+		if err := s.Start(); err != nil {
+			return fmt.Errorf("failed to start service: %w", err)
+		}
+	}
+	return nil
+}
+
+func isService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+func runService(opts *runOptions) error {
+	return svc.Run("output_alerts", &serviceHandler{opts: opts})
+}
+
+// serviceHandler runs the alerter under the Windows service control manager,
+// cancelling it on stop or shutdown requests.
+type serviceHandler struct {
+	opts *runOptions
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx, h.opts)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			cancel()
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
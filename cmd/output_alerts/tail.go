@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+const tailPollInterval = 500 * time.Millisecond
+
+// tailFile follows path like `tail -F`, passing each complete line to handle
+// until ctx is cancelled. It starts at the end of the file and reopens it from
+// the start when it is rotated or truncated. Lines longer than maxLine bytes
+// are truncated like alerting.NewLineScanner does.
+func tailFile(ctx context.Context, path string, maxLine int, handle func(string)) error {
+	if maxLine <= 0 {
+		maxLine = alerting.DefaultMaxLineBytes
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+
+	var partial strings.Builder
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		offset += int64(len(chunk))
+		// Keep room for the line ending so only longer lines are truncated.
+		if room := maxLine + 2 - partial.Len(); room > 0 {
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			partial.Write(chunk)
+		}
+		if err == nil {
+			line := strings.TrimRight(partial.String(), "\r\n")
+			if len(line) > maxLine {
+				line = line[:maxLine] + alerting.TruncatedMarker
+			}
+			handle(line)
+			partial.Reset()
+			continue
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(tailPollInterval):
+		}
+
+		if tailRotated(file, path, offset) {
+			reopened, err := os.Open(path)
+			if err != nil {
+				// The new file may not have been created yet.
+				continue
+			}
+			file.Close()
+			file = reopened
+			reader.Reset(file)
+			offset = 0
+			partial.Reset()
+		}
+	}
+}
+
+func tailRotated(file *os.File, path string, offset int64) bool {
+	current, err := file.Stat()
+	if err != nil {
+		return true
+	}
+	latest, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(current, latest) || latest.Size() < offset
+}
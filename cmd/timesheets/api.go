@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// perPage is the largest page FreeAgent serves.
+	perPage = 100
+	// maxRetries is how often a rate limited request is retried.
+	maxRetries = 5
+)
+
+// requestBudget spaces out the requests to FreeAgent to stay within its rate
+// limit, and holds them all back when it asks to retry later.
+type requestBudget struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// budget is shared by every request of a run.
+var budget = &requestBudget{}
+
+func newRequestBudget(perMinute int) *requestBudget {
+	b := &requestBudget{}
+	if perMinute > 0 {
+		b.interval = time.Minute / time.Duration(perMinute)
+	}
+	return b
+}
+
+// wait blocks until the next request is within the budget.
+func (b *requestBudget) wait() {
+	b.mu.Lock()
+	at := b.next
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	b.next = at.Add(b.interval)
+	b.mu.Unlock()
+	time.Sleep(time.Until(at))
+}
+
+// pause holds back all requests for d.
+func (b *requestBudget) pause(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if at := time.Now().Add(d); at.After(b.next) {
+		b.next = at
+	}
+}
+
+// retryAfter is how long a 429 response asks to wait: its Retry-After in
+// seconds or as a date, an exponential backoff from attempt otherwise.
+func retryAfter(resp *http.Response, attempt int) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return time.Duration(1<<attempt) * time.Second
+}
+
+// get GETs a FreeAgent URL within the budget, retrying when rate limited.
+func get(target string) (*http.Response, error) {
+	client := &http.Client{}
+	for attempt := 0; ; attempt++ {
+		accessToken, err := getAccessToken()
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+
+		budget.wait()
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt == maxRetries {
+			return resp, nil
+		}
+		resp.Body.Close()
+		wait := retryAfter(resp, attempt)
+		fmt.Fprintf(logOut, "Rate limited by FreeAgent, retrying in %s\n", wait.Round(time.Second))
+		budget.pause(wait)
+	}
+}
+
+// nextLinkRegex matches the next page of a Link header, whose rel may be
+// double quoted, single quoted as FreeAgent documents it, or a bare token.
+var nextLinkRegex = regexp.MustCompile(`<([^>]+)>[^,<]*;\s*rel=(?:"next"|'next'|next)(?:[\s;,]|$)`)
+
+// getPages GETs every page of a FreeAgent list endpoint, following the next
+// links of the Link header, and hands the body of each to decode.
+func getPages(endpoint string, query url.Values, decode func(io.Reader) error) error {
+	query.Set("per_page", strconv.Itoa(perPage))
+	return followPages(fmt.Sprintf("%s/%s?%s", baseURL, endpoint, query.Encode()), decode)
+}
+
+// followPages GETs the pages from first on like getPages.
+func followPages(first string, decode func(io.Reader) error) error {
+	next := first
+	for page := 1; next != ""; page++ {
+		resp, err := get(next)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("page %d: %s", page, resp.Status)
+		}
+		err = decode(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("page %d: %w", page, err)
+		}
+		next = nextLink(resp.Header.Values("Link"))
+	}
+	return nil
+}
+
+// nextLink returns the URL of the next page in the Link headers, if any.
+func nextLink(headers []string) string {
+	if match := nextLinkRegex.FindStringSubmatch(strings.Join(headers, ", ")); match != nil {
+		return match[1]
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{`<https://api.example/v2/users?page=2>; rel="next", <https://api.example/v2/users?page=5>; rel="last"`, "https://api.example/v2/users?page=2"},
+		{`<https://api.example/v2/users?page=1>; rel='prev', <https://api.example/v2/users?page=3>; rel='next'`, "https://api.example/v2/users?page=3"},
+		{`<https://api.example/v2/users?page=2>; rel=next`, "https://api.example/v2/users?page=2"},
+		{`<https://api.example/v2/users?page=2>; title="more"; rel=next; type="json"`, "https://api.example/v2/users?page=2"},
+		{`<https://api.example/v2/users?page=5>; rel="last"`, ""},
+		{`<https://api.example/v2/users?page=5>; rel=nextish`, ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := nextLink([]string{tt.header}); got != tt.want {
+			t.Errorf("nextLink(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestFollowPages(t *testing.T) {
+	tokens = TokenResponse{AccessToken: "token", ExpiresIn: int(time.Now().Add(time.Hour).Unix())}
+	budget = newRequestBudget(0)
+	logOut = io.Discard
+
+	for _, quote := range []string{`"`, `'`, ``} {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			if page == 0 {
+				page = 1
+			}
+			if page < 3 {
+				w.Header().Set("Link", fmt.Sprintf("<%s/users?page=%d>; rel=%snext%s", server.URL, page+1, quote, quote))
+			}
+			json.NewEncoder(w).Encode(map[string][]int{"items": {page}})
+		}))
+
+		var items []int
+		err := followPages(server.URL+"/users", func(body io.Reader) error {
+			var page map[string][]int
+			if err := json.NewDecoder(body).Decode(&page); err != nil {
+				return err
+			}
+			items = append(items, page["items"]...)
+			return nil
+		})
+		server.Close()
+		if err != nil {
+			t.Fatalf("rel quoted with %q: %v", quote, err)
+		}
+		if len(items) != 3 {
+			t.Errorf("rel quoted with %q: items = %v, want all 3 pages", quote, items)
+		}
+	}
+}
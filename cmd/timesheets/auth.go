@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const approveURL = "https://api.freeagent.com/v2/approve_app"
+
+// runAuth authorizes the app with FreeAgent in the browser and writes the
+// tokens it is granted to the token file.
+func runAuth(args []string) error {
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	redirect := flags.String("redirect", "http://localhost:8085/callback", "Redirect URI registered for the app; a local server on its port receives the authorization code")
+	timeout := flags.Duration("timeout", 5*time.Minute, "How long to wait for the authorization in the browser")
+	configPath := flags.String("config", defaultConfigPath, "Path to the config file with the FreeAgent credentials")
+	flags.Parse(args)
+
+	var err error
+	if config, err = loadConfig(*configPath); err != nil {
+		return err
+	}
+
+	redirectURL, err := url.Parse(*redirect)
+	if err != nil || redirectURL.Scheme != "http" || redirectURL.Port() == "" {
+		return fmt.Errorf("invalid redirect URI %q, want e.g. http://localhost:8085/callback", *redirect)
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(redirectURL.Hostname(), redirectURL.Port()))
+	if err != nil {
+		return fmt.Errorf("failed to start callback server: %w", err)
+	}
+
+	stateBytes := make([]byte, 16)
+	if _, err := rand.Read(stateBytes); err != nil {
+		return err
+	}
+	state := hex.EncodeToString(stateBytes)
+
+	// Only the first callback counts; later ones, e.g. a refreshed page,
+	// must not block their handler as nothing reads them.
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(redirectURL.Path, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("state") != state:
+			http.Error(w, "Unexpected state, start again with timesheets auth", http.StatusBadRequest)
+			return
+		case query.Get("error") != "":
+			fmt.Fprintln(w, "Authorization failed, you can close this window.")
+			select {
+			case errs <- fmt.Errorf("authorization failed: %s", query.Get("error")):
+			default:
+			}
+		case query.Get("code") == "":
+			http.Error(w, "Missing code", http.StatusBadRequest)
+			return
+		default:
+			fmt.Fprintln(w, "Authorized, you can close this window.")
+			select {
+			case codes <- query.Get("code"):
+			default:
+			}
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	consent := fmt.Sprintf("%s?%s", approveURL, url.Values{
+		"client_id":     {config.ClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {*redirect},
+		"state":         {state},
+	}.Encode())
+	fmt.Println("Open this URL to authorize timesheets with FreeAgent:")
+	fmt.Println(consent)
+	if err := openBrowser(consent); err != nil {
+		fmt.Println("Could not open a browser:", err)
+	}
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return err
+	case <-time.After(*timeout):
+		return fmt.Errorf("no authorization within %s", *timeout)
+	}
+
+	tokens, err := exchangeCode(code, *redirect)
+	if err != nil {
+		return err
+	}
+	if err := saveTokens(tokens); err != nil {
+		return fmt.Errorf("error saving tokens: %w", err)
+	}
+	fmt.Println("Saved tokens to", config.TokenFile)
+	return nil
+}
+
+// exchangeCode trades an authorization code for tokens.
+func exchangeCode(code, redirect string) (TokenResponse, error) {
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", redirect)
+	data.Set("client_id", config.ClientID)
+	data.Set("client_secret", config.ClientSecret)
+
+	resp, err := http.Post(authURL, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return TokenResponse{}, fmt.Errorf("failed to exchange code: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+
+	var tokenResponse TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return TokenResponse{}, err
+	}
+
+	tokenResponse.ExpiresIn = int(time.Now().Unix()) + tokenResponse.ExpiresIn
+
+	return tokenResponse, nil
+}
+
+func openBrowser(url string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", url).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	default:
+		return exec.Command("xdg-open", url).Start()
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+const (
+	defaultConfigPath          = "timesheets.json"
+	defaultExpectedHoursPerDay = 7.5
+	defaultDaysPerWeek         = 5
+
+	// A day is flagged when its hours fall more than shortDayMargin below or
+	// longDayMargin above those expected of it, 6 to 8 for the default day.
+	shortDayMargin = 1.5
+	longDayMargin  = 0.5
+)
+
+// Config holds the FreeAgent app credentials, where the tokens are kept and
+// the users whose timesheets differ from the defaults. The environment
+// variables FREEAGENT_CLIENT_ID, FREEAGENT_CLIENT_SECRET and
+// TIMESHEETS_TOKEN_FILE take precedence over the file.
+//
+//	{
+//	  "clientID": "...",
+//	  "clientSecret": "...",
+//	  "webhookURL": "env:TIMESHEETS_WEBHOOK_URL",
+//	  "smtp": {"host": "smtp.example.com", "username": "...", "password": "env:SMTP_PASSWORD", "from": "timesheets@example.com"},
+//	  "report": {"recipients": ["ops@example.com"]},
+//	  "users": {
+//	    "director@example.com": {"excluded": true},
+//	    "part.time@example.com": {"daysPerWeek": 4, "expectedHoursPerDay": 7.5},
+//	    "short.friday@example.com": {"schedule": {"mon": 7.5, "tue": 7.5, "wed": 7.5, "thu": 7.5, "fri": 6}}
+//	  }
+//	}
+type Config struct {
+	ClientID     string `json:"clientID"`
+	ClientSecret string `json:"clientSecret"`
+	TokenFile    string `json:"tokenFile"`
+	// WebhookURL is a Google Chat or Slack incoming webhook the report is
+	// posted to. Like the alerting webhooks it may be an env: or file:
+	// reference.
+	WebhookURL string `json:"webhookURL"`
+	// SMTP sends the reminders of users without a webhook of their own.
+	SMTP SMTPConfig `json:"smtp"`
+	// Report emails the report as an HTML table.
+	Report ReportConfig `json:"report"`
+	// BankHolidayRegion is the gov.uk division whose bank holidays are not
+	// expected to be worked: england-and-wales by default, scotland,
+	// northern-ireland or none.
+	BankHolidayRegion string `json:"bankHolidayRegion"`
+	// LeaveCalendar is the path or URL of the leaveCalendar of the users.
+	LeaveCalendar string `json:"leaveCalendar"`
+
+	// Users is keyed by the email of the FreeAgent user.
+	Users map[string]UserConfig `json:"users"`
+}
+
+// UserConfig overrides what is expected of one user; zero values keep the
+// defaults.
+type UserConfig struct {
+	Excluded            bool    `json:"excluded"`
+	DaysPerWeek         int     `json:"daysPerWeek"`
+	ExpectedHoursPerDay float64 `json:"expectedHoursPerDay"`
+	// WebhookURL is a chat webhook reaching the user directly, which
+	// reminders prefer over email.
+	WebhookURL string `json:"webhookURL"`
+	// BankHolidayRegion overrides the region of the config.
+	BankHolidayRegion string `json:"bankHolidayRegion"`
+	// Schedule holds the hours the user works on each weekday, keyed mon
+	// to sun, e.g. {"mon": 7.5, "tue": 7.5, "wed": 7.5, "thu": 7.5, "fri": 6}.
+	// It replaces daysPerWeek and expectedHoursPerDay.
+	Schedule map[string]float64 `json:"schedule"`
+}
+
+// weekdays maps the keys of a schedule to their weekday.
+var weekdays = map[string]time.Weekday{
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+	"sun": time.Sunday,
+}
+
+var config Config
+
+// loadConfig reads the config file, which may be missing when the
+// environment provides the credentials.
+func loadConfig(path string) (Config, error) {
+	var cfg Config
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &cfg); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv("FREEAGENT_CLIENT_ID"); v != "" {
+		cfg.ClientID = v
+	}
+	if v := os.Getenv("FREEAGENT_CLIENT_SECRET"); v != "" {
+		cfg.ClientSecret = v
+	}
+	if v := os.Getenv("TIMESHEETS_TOKEN_FILE"); v != "" {
+		cfg.TokenFile = v
+	}
+	if cfg.WebhookURL, err = alerting.ResolveSecret(cfg.WebhookURL); err != nil {
+		return Config{}, fmt.Errorf("failed to resolve webhookURL: %w", err)
+	}
+	if cfg.BankHolidayRegion == "" {
+		cfg.BankHolidayRegion = defaultRegion
+	}
+	if err := checkRegion(cfg.BankHolidayRegion); err != nil {
+		return Config{}, fmt.Errorf("bankHolidayRegion in %s: %w", path, err)
+	}
+	if cfg.TokenFile == "" {
+		cfg.TokenFile = "tokens.json"
+	}
+
+	if cfg.ClientID == "" {
+		return Config{}, fmt.Errorf("FreeAgent client ID is missing, set FREEAGENT_CLIENT_ID or clientID in %s", path)
+	}
+	if cfg.ClientSecret == "" {
+		return Config{}, fmt.Errorf("FreeAgent client secret is missing, set FREEAGENT_CLIENT_SECRET or clientSecret in %s", path)
+	}
+	for email, user := range cfg.Users {
+		if user.DaysPerWeek < 0 || user.DaysPerWeek > 7 {
+			return Config{}, fmt.Errorf("user %s: daysPerWeek %d is outside 0-7", email, user.DaysPerWeek)
+		}
+		if user.ExpectedHoursPerDay < 0 || user.ExpectedHoursPerDay > 24 {
+			return Config{}, fmt.Errorf("user %s: expectedHoursPerDay %g is outside 0-24", email, user.ExpectedHoursPerDay)
+		}
+		if user.BankHolidayRegion != "" {
+			if err := checkRegion(user.BankHolidayRegion); err != nil {
+				return Config{}, fmt.Errorf("user %s: %w", email, err)
+			}
+		}
+		for day, hours := range user.Schedule {
+			if _, ok := weekdays[day]; !ok {
+				return Config{}, fmt.Errorf("user %s: unknown schedule day %q, want mon to sun", email, day)
+			}
+			if hours < 0 || hours > 24 {
+				return Config{}, fmt.Errorf("user %s: %s hours %g are outside 0-24", email, day, hours)
+			}
+		}
+		if user.WebhookURL, err = alerting.ResolveSecret(user.WebhookURL); err != nil {
+			return Config{}, fmt.Errorf("failed to resolve webhookURL of user %s: %w", email, err)
+		}
+		cfg.Users[email] = user
+	}
+	if cfg.SMTP.enabled() && cfg.SMTP.From == "" {
+		return Config{}, fmt.Errorf("smtp from is missing in %s", path)
+	}
+	if len(cfg.Report.Recipients) > 0 && !cfg.SMTP.enabled() {
+		return Config{}, fmt.Errorf("report recipients need smtp in %s", path)
+	}
+	if cfg.SMTP.Password, err = alerting.ResolveSecret(cfg.SMTP.Password); err != nil {
+		return Config{}, fmt.Errorf("failed to resolve smtp password: %w", err)
+	}
+	return cfg, nil
+}
+
+// expectations returns the hours per day and days per week expected of the
+// user with email, and whether they are excluded from the check.
+func (c Config) expectations(email string) (float64, int, bool) {
+	user := c.Users[email]
+	hoursPerDay, daysPerWeek := defaultExpectedHoursPerDay, defaultDaysPerWeek
+	if user.ExpectedHoursPerDay > 0 {
+		hoursPerDay = user.ExpectedHoursPerDay
+	}
+	if user.DaysPerWeek > 0 {
+		daysPerWeek = user.DaysPerWeek
+	}
+	return hoursPerDay, daysPerWeek, user.Excluded
+}
+
+// expectation is what a user is expected to log in a range.
+type expectation struct {
+	Hours float64
+	Days  int
+	// WorkingDays holds the hours expected on each day worked, nil when it
+	// isn't known which days a part timer works.
+	WorkingDays map[string]float64
+}
+
+// expectation returns what the user with email is expected to log from
+// startDate to endDate, leaving out their daysOff: the hours of their
+// schedule on each day, or expectedHoursPerDay on their share of weekdays.
+func (c Config) expectation(email, startDate, endDate string, daysOff map[string]bool) expectation {
+	user := c.Users[email]
+	hoursPerDay, daysPerWeek, _ := c.expectations(email)
+	schedule := make(map[time.Weekday]float64)
+	for day, hours := range user.Schedule {
+		if hours > 0 {
+			schedule[weekdays[day]] = hours
+		}
+	}
+	if len(user.Schedule) == 0 {
+		if daysPerWeek < 5 {
+			days := expectedDays(startDate, endDate, daysPerWeek, daysOff)
+			return expectation{Hours: float64(days) * hoursPerDay, Days: days}
+		}
+		for day := time.Monday; day <= time.Friday; day++ {
+			schedule[day] = hoursPerDay
+		}
+	}
+
+	e := expectation{WorkingDays: scheduledDays(startDate, endDate, schedule, daysOff)}
+	for _, hours := range e.WorkingDays {
+		e.Hours += hours
+		e.Days++
+	}
+	return e
+}
+
+// region returns the bank holiday region of the user with email.
+func (c Config) region(email string) string {
+	if region := c.Users[email].BankHolidayRegion; region != "" {
+		return region
+	}
+	return c.BankHolidayRegion
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpectation(t *testing.T) {
+	const start, end = "2024-06-03", "2024-06-09" // Monday to Sunday
+	tests := []struct {
+		name    string
+		user    UserConfig
+		daysOff map[string]bool
+		want    expectation
+	}{
+		{
+			name: "full timer",
+			want: expectation{Hours: 37.5, Days: 5, WorkingDays: map[string]float64{
+				"2024-06-03": 7.5, "2024-06-04": 7.5, "2024-06-05": 7.5, "2024-06-06": 7.5, "2024-06-07": 7.5,
+			}},
+		},
+		{
+			name:    "full timer with a day off",
+			user:    UserConfig{ExpectedHoursPerDay: 6},
+			daysOff: map[string]bool{"2024-06-05": true, "2024-06-08": true},
+			want: expectation{Hours: 24, Days: 4, WorkingDays: map[string]float64{
+				"2024-06-03": 6, "2024-06-04": 6, "2024-06-06": 6, "2024-06-07": 6,
+			}},
+		},
+		{
+			name: "part timer without schedule",
+			user: UserConfig{DaysPerWeek: 3},
+			want: expectation{Hours: 22.5, Days: 3},
+		},
+		{
+			name:    "part timer without schedule with a day off",
+			user:    UserConfig{DaysPerWeek: 3},
+			daysOff: map[string]bool{"2024-06-05": true},
+			want:    expectation{Hours: 15, Days: 2},
+		},
+		{
+			name: "schedule",
+			user: UserConfig{Schedule: map[string]float64{"mon": 8, "wed": 4, "sat": 2, "tue": 0}},
+			want: expectation{Hours: 14, Days: 3, WorkingDays: map[string]float64{
+				"2024-06-03": 8, "2024-06-05": 4, "2024-06-08": 2,
+			}},
+		},
+		{
+			name:    "schedule with days off",
+			user:    UserConfig{Schedule: map[string]float64{"mon": 8, "wed": 4, "sat": 2}, DaysPerWeek: 5},
+			daysOff: map[string]bool{"2024-06-05": true, "2024-06-06": true},
+			want: expectation{Hours: 10, Days: 2, WorkingDays: map[string]float64{
+				"2024-06-03": 8, "2024-06-08": 2,
+			}},
+		},
+		{
+			name:    "schedule entirely off",
+			user:    UserConfig{Schedule: map[string]float64{"sun": 3}},
+			daysOff: map[string]bool{"2024-06-09": true},
+			want:    expectation{WorkingDays: map[string]float64{}},
+		},
+	}
+	for _, tt := range tests {
+		config := Config{Users: map[string]UserConfig{"user@example.com": tt.user}}
+		if got := config.expectation("user@example.com", start, end, tt.daysOff); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expectation = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func (r userResult) status() string {
+	switch {
+	case r.Err != nil:
+		return "error"
+	case len(r.Issues) > 0:
+		return "issues"
+	}
+	return "ok"
+}
+
+// totalsPath is where the totals of the CSV report at path are written,
+// report.csv giving report-totals.csv.
+func totalsPath(path string) string {
+	return strings.TrimSuffix(path, ".csv") + "-totals.csv"
+}
+
+// writeCSV writes one row per issue of each user to path, and one row of
+// totals per user to totalsPath(path).
+func writeCSV(path, startDate, endDate string, results []userResult) error {
+	issues := [][]string{{"email", "user_id", "from", "to", "issue"}}
+	totals := [][]string{{"email", "user_id", "from", "to", "total_hours", "expected_hours", "issues", "status", "missing_days"}}
+	for _, r := range results {
+		for _, issue := range r.Issues {
+			issues = append(issues, []string{r.Email, r.ID, startDate, endDate, issue})
+		}
+		if r.Err != nil {
+			issues = append(issues, []string{r.Email, r.ID, startDate, endDate, "Error fetching timesheet: " + r.Err.Error()})
+		}
+		totals = append(totals, []string{
+			r.Email, r.ID, startDate, endDate,
+			strconv.FormatFloat(r.TotalHours, 'f', 2, 64),
+			strconv.FormatFloat(r.ExpectedHours, 'f', 2, 64),
+			strconv.Itoa(len(r.Issues)),
+			r.status(),
+			strings.Join(r.MissingDays, "; "),
+		})
+	}
+
+	if err := writeCSVFile(path, issues); err != nil {
+		return err
+	}
+	if err := writeCSVFile(totalsPath(path), totals); err != nil {
+		return err
+	}
+	fmt.Fprintf(logOut, "\nWrote %s and %s\n", path, totalsPath(path))
+	return nil
+}
+
+// jsonReport is the machine-readable report of a check.
+type jsonReport struct {
+	From  string     `json:"from"`
+	To    string     `json:"to"`
+	Users []jsonUser `json:"users"`
+	// Shortfall is the cumulative shortfall of the users.
+	Shortfall float64 `json:"shortfallHours"`
+}
+
+type jsonUser struct {
+	Email         string         `json:"email"`
+	ID            string         `json:"id"`
+	Status        string         `json:"status"`
+	TotalHours    float64        `json:"totalHours"`
+	ExpectedHours float64        `json:"expectedHours"`
+	Shortfall     float64        `json:"shortfallHours"`
+	MissingDays   []string       `json:"missingDays,omitempty"`
+	Projects      []projectHours `json:"projects,omitempty"`
+	Issues        []string       `json:"issues"`
+	Error         string         `json:"error,omitempty"`
+}
+
+// writeJSON writes the results as a jsonReport to w.
+func writeJSON(w io.Writer, startDate, endDate string, results []userResult) error {
+	report := jsonReport{From: startDate, To: endDate, Users: []jsonUser{}}
+	for _, r := range results {
+		user := jsonUser{
+			Email:         r.Email,
+			ID:            r.ID,
+			Status:        r.status(),
+			TotalHours:    r.TotalHours,
+			ExpectedHours: r.ExpectedHours,
+			Shortfall:     r.shortfall(),
+			MissingDays:   r.MissingDays,
+			Projects:      r.Projects,
+			Issues:        r.Issues,
+		}
+		if user.Issues == nil {
+			user.Issues = []string{}
+		}
+		if r.Err != nil {
+			user.Error = r.Err.Error()
+		}
+		report.Users = append(report.Users, user)
+		report.Shortfall += user.Shortfall
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writeCSVFile(path string, rows [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	if err := w.WriteAll(rows); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
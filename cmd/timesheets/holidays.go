@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	bankHolidaysURL = "https://www.gov.uk/bank-holidays.json"
+	// defaultRegion is the gov.uk division of users without one.
+	defaultRegion = "england-and-wales"
+	// noBankHolidays turns the bank holidays off.
+	noBankHolidays = "none"
+)
+
+// bankHolidays maps each gov.uk division (england-and-wales, scotland,
+// northern-ireland) to the dates of its bank holidays.
+type bankHolidays map[string]map[string]bool
+
+// getBankHolidays fetches the bank holidays of every division from gov.uk.
+func getBankHolidays() (bankHolidays, error) {
+	resp, err := http.Get(bankHolidaysURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch bank holidays: %s", resp.Status)
+	}
+
+	var divisions map[string]struct {
+		Events []struct {
+			Title string `json:"title"`
+			Date  string `json:"date"`
+		} `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&divisions); err != nil {
+		return nil, fmt.Errorf("failed to decode bank holidays: %w", err)
+	}
+	holidays := make(bankHolidays)
+	for division, d := range divisions {
+		holidays[division] = make(map[string]bool)
+		for _, event := range d.Events {
+			holidays[division][event.Date] = true
+		}
+	}
+	return holidays, nil
+}
+
+// regions are the gov.uk divisions a region may name besides none.
+var regions = []string{"england-and-wales", "scotland", "northern-ireland"}
+
+// checkRegion fails when region is neither a gov.uk division nor none,
+// whether or not the bank holidays could be fetched.
+func checkRegion(region string) error {
+	if region == noBankHolidays {
+		return nil
+	}
+	for _, r := range regions {
+		if region == r {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown bank holiday region %q, want %s or %s", region, strings.Join(regions, ", "), noBankHolidays)
+}
+
+// region returns the bank holidays of region, nil for none or when they
+// could not be fetched.
+func (h bankHolidays) region(region string) map[string]bool {
+	if region == noBankHolidays {
+		return nil
+	}
+	return h[region]
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// ReportConfig emails the report of every run, so running timesheets from
+// cron, e.g. "0 9 * * 1" for Monday mornings, sends it on a schedule.
+type ReportConfig struct {
+	Recipients []string `json:"recipients"`
+	// Subject defaults to "Timesheets <from> to <to>".
+	Subject string `json:"subject"`
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ok":          userResult.ok,
+	"daysMissing": userResult.daysMissing,
+}).Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif">
+<h2>Timesheets {{.From}} to {{.To}}</h2>
+<table style="border-collapse: collapse" cellpadding="6" border="1">
+<tr><th>User</th><th>Total hours</th><th>Expected hours</th><th>Days missing</th><th>Projects</th><th>Anomalies</th></tr>
+{{range .Results}}<tr{{if not (ok .)}} style="background: #fdecea"{{end}}>
+<td>{{.Email}}</td>
+{{if .Err}}<td colspan="5">Error fetching timesheet: {{.Err}}</td>
+{{else}}<td>{{printf "%.2f" .TotalHours}}</td>
+<td>{{printf "%.2f" .ExpectedHours}}</td>
+<td>{{daysMissing .}}{{with .MissingDays}}<br>{{range .}}{{.}}<br>{{end}}{{end}}</td>
+<td>{{range .Projects}}{{printf "%.2f" .Hours}} {{.Name}}<br>{{range .Tasks}}&nbsp;&nbsp;{{printf "%.2f" .Hours}} {{.Name}}<br>{{end}}{{end}}</td>
+<td>{{range .Issues}}{{.}}<br>{{else}}OK{{end}}</td>
+{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// renderHTML renders the results as an HTML table, one row per user.
+func renderHTML(startDate, endDate string, results []userResult) (string, error) {
+	var b strings.Builder
+	err := reportTemplate.Execute(&b, struct {
+		From, To string
+		Results  []userResult
+	}{startDate, endDate, results})
+	return b.String(), err
+}
+
+// emailReport emails the HTML report to the recipients of report.
+func emailReport(smtp SMTPConfig, report ReportConfig, startDate, endDate string, results []userResult) error {
+	body, err := renderHTML(startDate, endDate, results)
+	if err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+	subject := report.Subject
+	if subject == "" {
+		subject = fmt.Sprintf("Timesheets %s to %s", startDate, endDate)
+	}
+	if err := sendEmail(smtp, report.Recipients, subject, "text/html", body); err != nil {
+		return err
+	}
+	fmt.Fprintln(logOut, "Emailed the report to", strings.Join(report.Recipients, ", "))
+	return nil
+}
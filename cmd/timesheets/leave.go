@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// leave is a stretch of approved holiday, sickness or other absence.
+type leave struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+// leaveCalendar maps the email of each user to their leave. FreeAgent's API
+// doesn't expose leave, so it is kept in a JSON file or at a URL:
+//
+//	{"alice@example.com": [{"from": "2024-05-20", "to": "2024-05-24", "reason": "holiday"}]}
+type leaveCalendar map[string][]leave
+
+// loadLeave reads the leave calendar from a file path or an http(s) URL.
+func loadLeave(source string) (leaveCalendar, error) {
+	var r io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch leave calendar: %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	var calendar leaveCalendar
+	if err := json.NewDecoder(r).Decode(&calendar); err != nil {
+		return nil, fmt.Errorf("failed to parse leave calendar %s: %w", source, err)
+	}
+	for email, stretches := range calendar {
+		for _, l := range stretches {
+			from, err := time.Parse(dateLayout, l.From)
+			if err != nil {
+				return nil, fmt.Errorf("leave of %s: invalid from %q", email, l.From)
+			}
+			to, err := time.Parse(dateLayout, l.To)
+			if err != nil {
+				return nil, fmt.Errorf("leave of %s: invalid to %q", email, l.To)
+			}
+			if to.Before(from) {
+				return nil, fmt.Errorf("leave of %s: %s is before %s", email, l.To, l.From)
+			}
+		}
+	}
+	return calendar, nil
+}
+
+// days returns the dates the user with email is on leave.
+func (c leaveCalendar) days(email string) map[string]bool {
+	days := make(map[string]bool)
+	for _, l := range c[email] {
+		from, _ := time.Parse(dateLayout, l.From)
+		to, _ := time.Parse(dateLayout, l.To)
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			days[d.Format(dateLayout)] = true
+		}
+	}
+	return days
+}
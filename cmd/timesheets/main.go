@@ -2,22 +2,22 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	clientID      = "t-q_gNf12aZbaIAZDtfIwg"
-	clientSecret  = "UBc5eTLPQh7n6ZIStrQRWA"
-	authURL       = "https://api.freeagent.com/v2/token_endpoint"
-	baseURL       = "https://api.freeagent.com/v2"
-	tokenFilePath = "tokens.json"
+	authURL = "https://api.freeagent.com/v2/token_endpoint"
+	baseURL = "https://api.freeagent.com/v2"
 )
 
 type TokenResponse struct {
@@ -29,9 +29,11 @@ type TokenResponse struct {
 }
 
 type Timeslip struct {
-	Date   string `json:"dated_on"`
-	Hours  string `json:"hours"`
-	UserID string `json:"user_id"`
+	Date    string `json:"dated_on"`
+	Hours   string `json:"hours"`
+	UserID  string `json:"user_id"`
+	Project string `json:"project"`
+	Task    string `json:"task"`
 }
 
 type TimeslipsResponse struct {
@@ -50,12 +52,22 @@ type UsersResponse struct {
 
 var tokens TokenResponse
 
+// holidays are the bank holidays of every region, nil when none are used.
+var holidays bankHolidays
+
+// leaveDays is the leave of the users, nil without a calendar.
+var leaveDays leaveCalendar
+
+// logOut receives the progress and errors of a check, stdout unless it is
+// taken by the JSON report.
+var logOut io.Writer = os.Stdout
+
 func refreshToken(refreshToken string) (TokenResponse, error) {
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", refreshToken)
-	data.Set("client_id", clientID)
-	data.Set("client_secret", clientSecret)
+	data.Set("client_id", config.ClientID)
+	data.Set("client_secret", config.ClientSecret)
 
 	req, err := http.NewRequest("POST", authURL, strings.NewReader(data.Encode()))
 	if err != nil {
@@ -87,7 +99,7 @@ func refreshToken(refreshToken string) (TokenResponse, error) {
 }
 
 func saveTokens(tokens TokenResponse) error {
-	file, err := os.Create(tokenFilePath)
+	file, err := os.OpenFile(config.TokenFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -97,7 +109,7 @@ func saveTokens(tokens TokenResponse) error {
 }
 
 func loadTokens() (TokenResponse, error) {
-	file, err := os.Open(tokenFilePath)
+	file, err := os.Open(config.TokenFile)
 	if err != nil {
 		return TokenResponse{}, err
 	}
@@ -111,9 +123,14 @@ func loadTokens() (TokenResponse, error) {
 	return tokenResponse, nil
 }
 
+// tokensMu guards tokens against the workers refreshing them at once.
+var tokensMu sync.Mutex
+
 func getAccessToken() (string, error) {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
 	if tokens.ExpiresIn <= int(time.Now().Unix()) {
-		fmt.Println("Access token expired, refreshing...")
+		fmt.Fprintln(logOut, "Access token expired, refreshing...")
 		var err error
 		tokens, err = refreshToken(tokens.RefreshToken)
 		if err != nil {
@@ -127,48 +144,85 @@ func getAccessToken() (string, error) {
 }
 
 func getTimeslips(userURL, startDate, endDate string) ([]Timeslip, error) {
-	accessToken, err := getAccessToken()
+	query := url.Values{"user": {userURL}, "from_date": {startDate}, "to_date": {endDate}}
+	var timeslips []Timeslip
+	err := getPages("timeslips", query, func(body io.Reader) error {
+		var timeslipsResponse TimeslipsResponse
+		if err := json.NewDecoder(body).Decode(&timeslipsResponse); err != nil {
+			return err
+		}
+		timeslips = append(timeslips, timeslipsResponse.Timeslips...)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to fetch timeslips: %w", err)
 	}
+	return timeslips, nil
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/timeslips?user=%s&from_date=%s&to_date=%s", baseURL, userURL, startDate, endDate), nil)
+func getUsers() ([]User, error) {
+	var users []User
+	err := getPages("users", url.Values{}, func(body io.Reader) error {
+		var usersResponse UsersResponse
+		if err := json.NewDecoder(body).Decode(&usersResponse); err != nil {
+			return err
+		}
+		users = append(users, usersResponse.Users...)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to fetch users: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
+	return users, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// checkUsers checks the timesheets of users with a pool of workers, returning
+// the results in the order of users.
+func checkUsers(users []User, startDate, endDate string, workers int) []userResult {
+	if workers < 1 {
+		workers = 1
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch timeslips: %s", resp.Status)
+	results := make([]userResult, len(users))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = checkUser(users[i], startDate, endDate)
+			}
+		}()
 	}
-
-	var timeslipsResponse TimeslipsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&timeslipsResponse); err != nil {
-		return nil, err
+	for i := range users {
+		jobs <- i
 	}
-
-	return timeslipsResponse.Timeslips, nil
+	close(jobs)
+	wg.Wait()
+	return results
 }
 
-func lastFullWeek() (string, string) {
-	now := time.Now()
-	weekday := int(now.Weekday())
-	if weekday == 0 {
-		weekday = 7
+func checkUser(user User, startDate, endDate string) userResult {
+	timeslips, err := getTimeslips(user.URL, startDate, endDate)
+	if err != nil {
+		return userResult{Email: user.Email, ID: user.ID, Err: err}
+	}
+	daysOff := leaveDays.days(user.Email)
+	regionHolidays := holidays.region(config.region(user.Email))
+	for date := range regionHolidays {
+		daysOff[date] = true
 	}
-	startOfWeek := now.AddDate(0, 0, -weekday-7) // Last week's Monday
-	endOfWeek := startOfWeek.AddDate(0, 0, 6)    // Last week's Sunday
-	return startOfWeek.Format("2006-01-02"), endOfWeek.Format("2006-01-02")
+	result := checkTimesheet(timeslips, startDate, endDate, config.expectation(user.Email, startDate, endDate, daysOff))
+	result.Email, result.ID = user.Email, user.ID
+	var projectIssues []string
+	result.Projects, projectIssues = projectBreakdown(timeslips, startDate, endDate)
+	result.Issues = append(result.Issues, projectIssues...)
+	return result
 }
 
-func checkTimesheet(timeslips []Timeslip, startDate, endDate string, expectedHoursPerDay float64, daysPerWeek int) []string {
+// checkTimesheet returns the hours and issues of the timeslips of one user in
+// the period; the caller fills in who they are.
+func checkTimesheet(timeslips []Timeslip, startDate, endDate string, expected expectation) userResult {
 	totalHours := 0.0
 	hoursPerDay := make(map[string]float64)
 	var issues []string
@@ -185,121 +239,202 @@ func checkTimesheet(timeslips []Timeslip, startDate, endDate string, expectedHou
 		}
 	}
 
-	expectedTotalHours := expectedHoursPerDay * float64(daysPerWeek)
+	if totalHours < expected.Hours {
+		issues = append(issues, fmt.Sprintf("Total hours %.2f is less than expected %.2f", totalHours, expected.Hours))
+	}
 
-	if totalHours < expectedTotalHours {
-		issues = append(issues, fmt.Sprintf("Total hours %.2f is less than expected %.2f", totalHours, expectedTotalHours))
+	missingDays := missingDays(expected.WorkingDays, hoursPerDay, time.Now())
+	if len(missingDays) > 0 {
+		issues = append(issues, "Missing: "+strings.Join(missingDays, ", "))
 	}
 
-	for date, hours := range hoursPerDay {
-		if hours < 6 {
-			issues = append(issues, fmt.Sprintf("Date: %s has less than 6 hours: %.2f hours", date, hours))
-		} else if hours > 8 {
-			issues = append(issues, fmt.Sprintf("Date: %s has more than 8 hours: %.2f hours", date, hours))
+	dates := make([]string, 0, len(hoursPerDay))
+	for date := range hoursPerDay {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	for _, date := range dates {
+		hours := hoursPerDay[date]
+		// Days without a known expectation are held to the default day.
+		dayHours, ok := expected.WorkingDays[date]
+		if !ok {
+			dayHours = defaultExpectedHoursPerDay
+		}
+		if low := dayHours - shortDayMargin; hours < low {
+			issues = append(issues, fmt.Sprintf("Date: %s has less than %g hours: %.2f hours", date, low, hours))
+		} else if high := dayHours + longDayMargin; hours > high {
+			issues = append(issues, fmt.Sprintf("Date: %s has more than %g hours: %.2f hours", date, high, hours))
 		}
 	}
 
-	return issues
+	return userResult{
+		Issues:        issues,
+		TotalHours:    totalHours,
+		ExpectedHours: expected.Hours,
+		DaysLogged:    len(hoursPerDay),
+		ExpectedDays:  expected.Days,
+		MissingDays:   missingDays,
+	}
+}
+
+// missingDays returns the working days up to now without any hours, like
+// "Tue 2024-06-04", nil when the working days are unknown.
+func missingDays(workingDays, hoursPerDay map[string]float64, now time.Time) []string {
+	if workingDays == nil {
+		return nil
+	}
+	today := now.Format(dateLayout)
+	missing := []string{}
+	for date := range workingDays {
+		if hoursPerDay[date] == 0 && date <= today {
+			missing = append(missing, date)
+		}
+	}
+	sort.Strings(missing)
+	for i, date := range missing {
+		if d, err := time.Parse(dateLayout, date); err == nil {
+			missing[i] = d.Format("Mon ") + date
+		}
+	}
+	return missing
 }
 
 func main() {
 	var err error
 
-	tokens, err = loadTokens()
-	if err != nil {
-		fmt.Println("Error loading tokens:", err)
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		if err := runAuth(os.Args[2:]); err != nil {
+			fmt.Println("Error authorizing:", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	accessToken, err := getAccessToken()
+	configPath := flag.String("config", defaultConfigPath, "Path to the config file with the FreeAgent credentials")
+	remind := flag.Bool("remind", false, "Remind each user with issues directly, on their webhook or by email")
+	out := flag.String("out", "", "Write the issues to this CSV file, and the totals of each user next to it")
+	jsonOutput := flag.Bool("json", false, "Print the report as JSON, moving the progress to stderr")
+	from := flag.String("from", "", "First day to check, YYYY-MM-DD, instead of the period; needs -to")
+	to := flag.String("to", "", "Last day to check, YYYY-MM-DD")
+	period := flag.String("period", "last-week", "Period to check: last-week, this-week, last-month, this-month, month=YYYY-MM, last-quarter, this-quarter or quarter=YYYY-QN")
+	summaryTable := flag.Bool("summary", false, "Print the actual and expected hours of each user over the period with their shortfall, for month and quarter audits")
+	requestsPerMinute := flag.Int("requests-per-minute", 100, "Client-side budget of FreeAgent requests, below its rate limit of 120 a minute; 0 disables it")
+	workers := flag.Int("workers", 4, "How many users to fetch the timeslips of at once")
+	flag.Parse()
+	budget = newRequestBudget(*requestsPerMinute)
+	if *jsonOutput {
+		logOut = os.Stderr
+	}
+	if config, err = loadConfig(*configPath); err != nil {
+		fmt.Fprintln(logOut, "Error loading config:", err)
+		os.Exit(1)
+	}
+	startDate, endDate, err := dateRange(*from, *to, *period, time.Now())
 	if err != nil {
-		fmt.Println("Error getting access token:", err)
-		return
+		fmt.Fprintln(logOut, "Error parsing the date range:", err)
+		os.Exit(2)
 	}
 
-	// Fetch users
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/users", baseURL), nil)
+	tokens, err = loadTokens()
 	if err != nil {
-		fmt.Println("Error creating request:", err)
+		fmt.Fprintln(logOut, "Error loading tokens:", err)
+		fmt.Fprintln(logOut, "Run timesheets auth to authorize with FreeAgent")
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	resp, err := client.Do(req)
+	users, err := getUsers()
 	if err != nil {
-		fmt.Println("Error making request:", err)
+		fmt.Fprintln(logOut, "Error fetching users:", err)
 		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Failed to fetch users:", resp.Status)
-		return
+	var checked []User
+	for _, user := range users {
+		if _, _, excluded := config.expectations(user.Email); !excluded {
+			checked = append(checked, user)
+		}
 	}
-
-	var usersResponse UsersResponse
-	if err := json.NewDecoder(resp.Body).Decode(&usersResponse); err != nil {
-		fmt.Println("Error decoding response:", err)
-		return
+	needHolidays := false
+	for _, user := range checked {
+		if config.region(user.Email) != noBankHolidays {
+			needHolidays = true
+		}
 	}
-
-	// Define exclusion list by email
-	exclusionList := []string{
-		"scott@revitt.consulting",
-		"jake@revitt.consulting",
-		"leon@revitt.consulting",
-		"peter@revitt.consulting",
-		"zac@biaccountancy.com",
+	if needHolidays {
+		if holidays, err = getBankHolidays(); err != nil {
+			fmt.Fprintln(logOut, "Error fetching bank holidays, expecting every weekday to be worked:", err)
+		}
 	}
 
-	// Define override list for part-time users
-	overrideList := map[string]struct {
-		DaysPerWeek         int
-		ExpectedHoursPerDay float64
-	}{
-		"max.bb@revitt.consulting": {DaysPerWeek: 4, ExpectedHoursPerDay: 7.5},
+	if config.LeaveCalendar != "" {
+		if leaveDays, err = loadLeave(config.LeaveCalendar); err != nil {
+			fmt.Fprintln(logOut, "Error loading the leave calendar:", err)
+			os.Exit(1)
+		}
 	}
 
-	startDate, endDate := lastFullWeek()
+	if projects, err = getProjects(); err != nil {
+		fmt.Fprintln(logOut, "Error fetching projects, skipping the project breakdown:", err)
+	} else if tasks, err = getTasks(); err != nil {
+		fmt.Fprintln(logOut, "Error fetching tasks, skipping the task breakdown:", err)
+	}
 
-	for _, user := range usersResponse.Users {
-		if contains(exclusionList, user.Email) {
-			continue
+	results := checkUsers(checked, startDate, endDate, *workers)
+	for _, result := range results {
+		fmt.Fprintf(logOut, "\nChecking timesheet for user: %s (ID: %s)\n", result.Email, result.ID)
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(logOut, "  Error fetching timesheet: %s\n", result.Err)
+		case len(result.Issues) > 0:
+			fmt.Fprintf(logOut, "  Issues found:\n")
+			for _, issue := range result.Issues {
+				fmt.Fprintf(logOut, "    - %s\n", issue)
+			}
+		default:
+			fmt.Fprintf(logOut, "  Status: OK\n")
 		}
-
-		expectedHoursPerDay := 7.5
-		daysPerWeek := 5
-
-		if override, found := overrideList[user.Email]; found {
-			expectedHoursPerDay = override.ExpectedHoursPerDay
-			daysPerWeek = override.DaysPerWeek
+		if len(result.Projects) > 0 {
+			fmt.Fprintf(logOut, "  Projects:\n")
+			for _, p := range result.Projects {
+				fmt.Fprintf(logOut, "    %6.2f  %s\n", p.Hours, p.Name)
+				for _, t := range p.Tasks {
+					fmt.Fprintf(logOut, "      %6.2f  %s\n", t.Hours, t.Name)
+				}
+			}
 		}
+	}
 
-		fmt.Printf("\nChecking timesheet for user: %s (ID: %s)\n", user.Email, user.ID)
-		timeslips, err := getTimeslips(user.URL, startDate, endDate)
-		if err != nil {
-			fmt.Printf("  Error fetching timesheet: %s\n", err)
-			continue
+	if *summaryTable {
+		printShortfall(logOut, startDate, endDate, results)
+	}
+	if *jsonOutput {
+		if err := writeJSON(os.Stdout, startDate, endDate, results); err != nil {
+			fmt.Fprintln(logOut, "Error writing JSON:", err)
+			os.Exit(1)
 		}
-
-		issues := checkTimesheet(timeslips, startDate, endDate, expectedHoursPerDay, daysPerWeek)
-		if len(issues) > 0 {
-			fmt.Printf("  Issues found:\n")
-			for _, issue := range issues {
-				fmt.Printf("    - %s\n", issue)
-			}
-		} else {
-			fmt.Printf("  Status: OK\n")
+	}
+	if *out != "" {
+		if err := writeCSV(*out, startDate, endDate, results); err != nil {
+			fmt.Fprintln(logOut, "Error writing CSV:", err)
+			os.Exit(1)
 		}
 	}
-}
-
-func contains(slice []string, item string) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
+	if config.WebhookURL != "" {
+		if err := sendReport(config.WebhookURL, startDate, endDate, results); err != nil {
+			fmt.Fprintln(logOut, "Error sending report:", err)
+			os.Exit(1)
+		}
+	}
+	if len(config.Report.Recipients) > 0 {
+		if err := emailReport(config.SMTP, config.Report, startDate, endDate, results); err != nil {
+			fmt.Fprintln(logOut, "Error emailing report:", err)
+			os.Exit(1)
+		}
+	}
+	if *remind {
+		if failed := sendReminders(results, startDate, endDate); failed > 0 {
+			fmt.Fprintf(logOut, "Error sending reminders: %d failed\n", failed)
+			os.Exit(1)
 		}
 	}
-	return false
 }
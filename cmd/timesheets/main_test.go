@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMissingDays(t *testing.T) {
+	workingDays := map[string]float64{"2024-06-03": 8, "2024-06-04": 8, "2024-06-05": 4, "2024-06-10": 8}
+	now := time.Date(2024, 6, 5, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		workingDays map[string]float64
+		hours       map[string]float64
+		want        []string
+	}{
+		{"unknown working days", nil, nil, nil},
+		{"nothing logged", workingDays, nil, []string{"Mon 2024-06-03", "Tue 2024-06-04", "Wed 2024-06-05"}},
+		{"some logged", workingDays, map[string]float64{"2024-06-04": 3, "2024-06-06": 8}, []string{"Mon 2024-06-03", "Wed 2024-06-05"}},
+		{"all logged", workingDays, map[string]float64{"2024-06-03": 8, "2024-06-04": 1, "2024-06-05": 0.5}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := missingDays(tt.workingDays, tt.hours, now); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: missingDays = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// startOfWeek returns the Monday of the week of t, at midnight.
+func startOfWeek(t time.Time) time.Time {
+	weekday := int(t.Weekday())
+	if weekday == 0 {
+		weekday = 7
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()-weekday+1, 0, 0, 0, 0, t.Location())
+}
+
+// parsePeriod returns the first and last day of a preset relative to now:
+// last-week, this-week, last-month, this-month, month=2024-05,
+// last-quarter, this-quarter or quarter=2024-Q2.
+func parsePeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	quarterStart := time.Date(now.Year(), (now.Month()-1)/3*3+1, 1, 0, 0, 0, 0, now.Location())
+	switch {
+	case period == "last-week":
+		start := startOfWeek(now).AddDate(0, 0, -7)
+		return start, start.AddDate(0, 0, 6), nil
+	case period == "this-week":
+		start := startOfWeek(now)
+		return start, start.AddDate(0, 0, 6), nil
+	case period == "last-month":
+		start := monthStart.AddDate(0, -1, 0)
+		return start, monthStart.AddDate(0, 0, -1), nil
+	case period == "this-month":
+		return monthStart, monthStart.AddDate(0, 1, -1), nil
+	case strings.HasPrefix(period, "month="):
+		start, err := time.ParseInLocation("2006-01", strings.TrimPrefix(period, "month="), now.Location())
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid month in %q, want e.g. month=2024-05", period)
+		}
+		return start, start.AddDate(0, 1, -1), nil
+	case period == "last-quarter":
+		return quarterStart.AddDate(0, -3, 0), quarterStart.AddDate(0, 0, -1), nil
+	case period == "this-quarter":
+		return quarterStart, quarterStart.AddDate(0, 3, -1), nil
+	case strings.HasPrefix(period, "quarter="):
+		var year, quarter int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(period, "quarter="), "%4d-Q%1d", &year, &quarter); err != nil || quarter < 1 || quarter > 4 {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid quarter in %q, want e.g. quarter=2024-Q2", period)
+		}
+		start := time.Date(year, time.Month(quarter*3-2), 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 3, -1), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown period %q, want last-week, this-week, last-month, this-month, month=YYYY-MM, last-quarter, this-quarter or quarter=YYYY-QN", period)
+}
+
+// dateRange resolves the flags into the first and last day to check: from
+// and to if given, the period otherwise.
+func dateRange(from, to, period string, now time.Time) (string, string, error) {
+	if from == "" && to == "" {
+		start, end, err := parsePeriod(period, now)
+		if err != nil {
+			return "", "", err
+		}
+		return start.Format(dateLayout), end.Format(dateLayout), nil
+	}
+	if from == "" || to == "" {
+		return "", "", fmt.Errorf("-from and -to go together")
+	}
+	start, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid -from %q, want YYYY-MM-DD", from)
+	}
+	end, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid -to %q, want YYYY-MM-DD", to)
+	}
+	if end.Before(start) {
+		return "", "", fmt.Errorf("-to %s is before -from %s", to, from)
+	}
+	return from, to, nil
+}
+
+// scheduledDays returns the days of the range worked on schedule, with the
+// hours of each, leaving out daysOff.
+func scheduledDays(startDate, endDate string, schedule map[time.Weekday]float64, daysOff map[string]bool) map[string]float64 {
+	days := make(map[string]float64)
+	start, err := time.Parse(dateLayout, startDate)
+	if err != nil {
+		return days
+	}
+	end, err := time.Parse(dateLayout, endDate)
+	if err != nil {
+		return days
+	}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format(dateLayout)
+		if hours := schedule[d.Weekday()]; hours > 0 && !daysOff[date] {
+			days[date] = hours
+		}
+	}
+	return days
+}
+
+// expectedDays is how many days of the range a user working daysPerWeek
+// days is expected to log: their share of its weekdays that aren't days off,
+// all of them for a full timer.
+func expectedDays(startDate, endDate string, daysPerWeek int, daysOff map[string]bool) int {
+	start, err := time.Parse(dateLayout, startDate)
+	if err != nil {
+		return daysPerWeek
+	}
+	end, err := time.Parse(dateLayout, endDate)
+	if err != nil {
+		return daysPerWeek
+	}
+	weekdays := 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday && !daysOff[d.Format(dateLayout)] {
+			weekdays++
+		}
+	}
+	return int(math.Round(float64(weekdays) * float64(daysPerWeek) / 5))
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func date(t *testing.T, s string) time.Time {
+	t.Helper()
+	d, err := time.Parse(dateLayout, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestStartOfWeek(t *testing.T) {
+	tests := []struct {
+		day  string
+		want string
+	}{
+		{"2024-06-03", "2024-06-03"}, // Monday
+		{"2024-06-05", "2024-06-03"},
+		{"2024-06-08", "2024-06-03"},
+		{"2024-06-09", "2024-06-03"}, // Sunday ends the week
+		{"2024-01-02", "2024-01-01"},
+		{"2025-01-01", "2024-12-30"}, // across the year
+	}
+	for _, tt := range tests {
+		if got := startOfWeek(date(t, tt.day).Add(15 * time.Hour)).Format(dateLayout); got != tt.want {
+			t.Errorf("startOfWeek(%s) = %s, want %s", tt.day, got, tt.want)
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		period string
+		now    string
+		start  string
+		end    string
+	}{
+		{"last-week", "2024-06-09", "2024-05-27", "2024-06-02"},
+		{"this-week", "2024-06-09", "2024-06-03", "2024-06-09"},
+		{"last-week", "2024-06-10", "2024-06-03", "2024-06-09"},
+		{"last-month", "2024-06-09", "2024-05-01", "2024-05-31"},
+		{"last-month", "2024-01-15", "2023-12-01", "2023-12-31"},
+		{"this-month", "2024-02-10", "2024-02-01", "2024-02-29"},
+		{"month=2023-02", "2024-06-09", "2023-02-01", "2023-02-28"},
+		{"this-quarter", "2024-06-30", "2024-04-01", "2024-06-30"},
+		{"this-quarter", "2024-07-01", "2024-07-01", "2024-09-30"},
+		{"last-quarter", "2024-04-01", "2024-01-01", "2024-03-31"},
+		{"last-quarter", "2024-03-31", "2023-10-01", "2023-12-31"},
+		{"last-quarter", "2025-01-15", "2024-10-01", "2024-12-31"},
+		{"quarter=2024-Q1", "2024-06-09", "2024-01-01", "2024-03-31"},
+		{"quarter=2024-Q4", "2024-06-09", "2024-10-01", "2024-12-31"},
+	}
+	for _, tt := range tests {
+		start, end, err := parsePeriod(tt.period, date(t, tt.now).Add(15*time.Hour))
+		if err != nil {
+			t.Errorf("parsePeriod(%s) on %s: %v", tt.period, tt.now, err)
+			continue
+		}
+		if got := start.Format(dateLayout) + " " + end.Format(dateLayout); got != tt.start+" "+tt.end {
+			t.Errorf("parsePeriod(%s) on %s = %s, want %s %s", tt.period, tt.now, got, tt.start, tt.end)
+		}
+	}
+
+	for _, period := range []string{"", "fortnight", "month=2024-13", "month=June", "quarter=2024-Q5", "quarter=2024-Q0", "quarter=Q2"} {
+		if _, _, err := parsePeriod(period, time.Now()); err == nil {
+			t.Errorf("parsePeriod(%q) should fail", period)
+		}
+	}
+}
+
+func TestDateRange(t *testing.T) {
+	now := date(t, "2024-06-09")
+	tests := []struct {
+		from, to, period string
+		start, end       string
+		wantErr          bool
+	}{
+		{"", "", "last-month", "2024-05-01", "2024-05-31", false},
+		{"2024-06-01", "2024-06-05", "last-month", "2024-06-01", "2024-06-05", false},
+		{"2024-06-01", "2024-06-01", "", "2024-06-01", "2024-06-01", false},
+		{"2024-06-01", "", "", "", "", true},
+		{"", "2024-06-01", "", "", "", true},
+		{"2024-06-05", "2024-06-01", "", "", "", true},
+		{"01/06/2024", "2024-06-05", "", "", "", true},
+		{"", "", "fortnight", "", "", true},
+	}
+	for _, tt := range tests {
+		start, end, err := dateRange(tt.from, tt.to, tt.period, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("dateRange(%q, %q, %q) error = %v, want error %v", tt.from, tt.to, tt.period, err, tt.wantErr)
+			continue
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("dateRange(%q, %q, %q) = %s %s, want %s %s", tt.from, tt.to, tt.period, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestExpectedDays(t *testing.T) {
+	tests := []struct {
+		start, end  string
+		daysPerWeek int
+		daysOff     map[string]bool
+		want        int
+	}{
+		{"2024-06-03", "2024-06-09", 5, nil, 5},
+		{"2024-06-03", "2024-06-09", 3, nil, 3},
+		{"2024-06-03", "2024-06-09", 3, map[string]bool{"2024-06-05": true}, 2},
+		{"2024-06-08", "2024-06-09", 5, nil, 0},
+		{"2024-06-01", "2024-06-30", 2, nil, 8},
+	}
+	for _, tt := range tests {
+		if got := expectedDays(tt.start, tt.end, tt.daysPerWeek, tt.daysOff); got != tt.want {
+			t.Errorf("expectedDays(%s, %s, %d, %v) = %d, want %d", tt.start, tt.end, tt.daysPerWeek, tt.daysOff, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+type Project struct {
+	URL    string `json:"url"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+type ProjectsResponse struct {
+	Projects []Project `json:"projects"`
+}
+
+type Task struct {
+	URL     string `json:"url"`
+	Name    string `json:"name"`
+	Project string `json:"project"`
+	Status  string `json:"status"`
+}
+
+type TasksResponse struct {
+	Tasks []Task `json:"tasks"`
+}
+
+// projects maps the URLs of all projects, archived ones included, to them;
+// nil when they could not be fetched.
+var projects map[string]Project
+
+// tasks maps the URLs of all tasks to them; nil when they could not be
+// fetched, which leaves out the breakdown per task.
+var tasks map[string]Task
+
+func getProjects() (map[string]Project, error) {
+	all := make(map[string]Project)
+	err := getPages("projects", url.Values{"view": {"all"}}, func(body io.Reader) error {
+		var projectsResponse ProjectsResponse
+		if err := json.NewDecoder(body).Decode(&projectsResponse); err != nil {
+			return err
+		}
+		for _, p := range projectsResponse.Projects {
+			all[p.URL] = p
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch projects: %w", err)
+	}
+	return all, nil
+}
+
+func getTasks() (map[string]Task, error) {
+	all := make(map[string]Task)
+	err := getPages("tasks", url.Values{"view": {"all"}}, func(body io.Reader) error {
+		var tasksResponse TasksResponse
+		if err := json.NewDecoder(body).Decode(&tasksResponse); err != nil {
+			return err
+		}
+		for _, t := range tasksResponse.Tasks {
+			all[t.URL] = t
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tasks: %w", err)
+	}
+	return all, nil
+}
+
+// projectHours are the hours a user booked to one project, and to each of
+// its tasks.
+type projectHours struct {
+	Name   string      `json:"name"`
+	Status string      `json:"status"`
+	Hours  float64     `json:"hours"`
+	Tasks  []taskHours `json:"tasks,omitempty"`
+}
+
+// taskHours are the hours a user booked to one task.
+type taskHours struct {
+	Name  string  `json:"name"`
+	Hours float64 `json:"hours"`
+}
+
+// projectBreakdown sums the hours of the timeslips in the range per project
+// and task, most hours first, with issues for those booked to archived or
+// unknown projects.
+func projectBreakdown(timeslips []Timeslip, startDate, endDate string) ([]projectHours, []string) {
+	if projects == nil {
+		return nil, nil
+	}
+	hours := make(map[string]float64)
+	taskHoursOf := make(map[string]map[string]float64)
+	for _, timeslip := range timeslips {
+		h, err := strconv.ParseFloat(timeslip.Hours, 64)
+		if err != nil || timeslip.Date < startDate || timeslip.Date > endDate {
+			continue
+		}
+		hours[timeslip.Project] += h
+		if taskHoursOf[timeslip.Project] == nil {
+			taskHoursOf[timeslip.Project] = make(map[string]float64)
+		}
+		taskHoursOf[timeslip.Project][timeslip.Task] += h
+	}
+
+	var breakdown []projectHours
+	for projectURL, h := range hours {
+		p, ok := projects[projectURL]
+		if !ok {
+			p = Project{Name: projectURL, Status: "Unknown"}
+			if projectURL == "" {
+				p.Name = "No project"
+			}
+		}
+		breakdown = append(breakdown, projectHours{Name: p.Name, Status: p.Status, Hours: h, Tasks: taskBreakdown(taskHoursOf[projectURL])})
+	}
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].Hours != breakdown[j].Hours {
+			return breakdown[i].Hours > breakdown[j].Hours
+		}
+		return breakdown[i].Name < breakdown[j].Name
+	})
+
+	var issues []string
+	for _, p := range breakdown {
+		switch p.Status {
+		case "Active":
+		case "Unknown":
+			issues = append(issues, fmt.Sprintf("%.2f hours booked to unknown project %s", p.Hours, p.Name))
+		default:
+			issues = append(issues, fmt.Sprintf("%.2f hours booked to archived project %s (%s)", p.Hours, p.Name, p.Status))
+		}
+	}
+	return breakdown, issues
+}
+
+// taskBreakdown names the tasks of hours, keyed by task URL, most hours
+// first; nil when the tasks could not be fetched.
+func taskBreakdown(hours map[string]float64) []taskHours {
+	if tasks == nil {
+		return nil
+	}
+	var breakdown []taskHours
+	for taskURL, h := range hours {
+		name := taskURL
+		if t, ok := tasks[taskURL]; ok {
+			name = t.Name
+		} else if taskURL == "" {
+			name = "No task"
+		}
+		breakdown = append(breakdown, taskHours{Name: name, Hours: h})
+	}
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].Hours != breakdown[j].Hours {
+			return breakdown[i].Hours > breakdown[j].Hours
+		}
+		return breakdown[i].Name < breakdown[j].Name
+	})
+	return breakdown
+}
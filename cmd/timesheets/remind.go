@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig is the mail server reminders are sent through.
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	// Password may be an env: or file: reference.
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+func (c SMTPConfig) enabled() bool {
+	return c.Host != ""
+}
+
+// reminder is the text asking a user to fix their timesheet.
+func reminder(r userResult, startDate, endDate string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi,\n\nYour FreeAgent timesheet for %s to %s needs attention:\n\n", startDate, endDate)
+	for _, issue := range r.Issues {
+		fmt.Fprintf(&b, "  - %s\n", issue)
+	}
+	b.WriteString("\nPlease update your timeslips in FreeAgent.\n")
+	return b.String()
+}
+
+// sendReminders nudges each user with issues directly: on their own webhook
+// if they have one, by email otherwise. Users whose timesheet could not be
+// fetched are left to the report. It returns how many reminders failed.
+func sendReminders(results []userResult, startDate, endDate string) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil || len(r.Issues) == 0 {
+			continue
+		}
+		user := config.Users[r.Email]
+		text := reminder(r, startDate, endDate)
+		var err error
+		switch {
+		case user.WebhookURL != "":
+			title := fmt.Sprintf("Timesheet reminder %s to %s", startDate, endDate)
+			err = postMessage(user.WebhookURL, title, text, "WARNING", nil)
+		case config.SMTP.enabled():
+			err = sendEmail(config.SMTP, []string{r.Email}, "Timesheet reminder", "text/plain", text)
+		default:
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(logOut, "Error sending reminder to %s: %s\n", r.Email, err)
+			failed++
+			continue
+		}
+		fmt.Fprintln(logOut, "Sent reminder to", r.Email)
+	}
+	return failed
+}
+
+// sendEmail sends an email with a body of contentType, authenticating when a
+// username is configured.
+func sendEmail(c SMTPConfig, to []string, subject, contentType, body string) error {
+	port := c.Port
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(port))
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s; charset=utf-8\r\n\r\n", contentType)
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(addr, auth, c.From, to, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
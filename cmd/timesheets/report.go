@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/revitteth/scripts/internal/alerting"
+)
+
+// userResult is the outcome of checking the timesheet of one user.
+type userResult struct {
+	Email  string
+	ID     string
+	Issues []string
+	// TotalHours and ExpectedHours are the hours logged and expected in
+	// the period.
+	TotalHours    float64
+	ExpectedHours float64
+	// DaysLogged and ExpectedDays are the days with timeslips and the days
+	// expected to have them.
+	DaysLogged   int
+	ExpectedDays int
+	// MissingDays are the working days without timeslips, like
+	// "Tue 2024-06-04", nil when it isn't known which days the user works.
+	MissingDays []string
+	// Projects breaks the hours down per project, most first.
+	Projects []projectHours
+	// Err is set when the timesheet could not be fetched.
+	Err error
+}
+
+func (r userResult) ok() bool {
+	return r.Err == nil && len(r.Issues) == 0
+}
+
+// shortfall is how many of the expected hours were not logged.
+func (r userResult) shortfall() float64 {
+	if r.TotalHours >= r.ExpectedHours {
+		return 0
+	}
+	return r.ExpectedHours - r.TotalHours
+}
+
+// daysMissing is how many of the expected days have no timeslips.
+func (r userResult) daysMissing() int {
+	if r.MissingDays != nil {
+		return len(r.MissingDays)
+	}
+	if r.DaysLogged >= r.ExpectedDays {
+		return 0
+	}
+	return r.ExpectedDays - r.DaysLogged
+}
+
+// summary renders the results grouped per user as plain text, listing only
+// the users with issues.
+func summary(results []userResult) string {
+	var b strings.Builder
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(&b, "%s\n  - Error fetching timesheet: %s\n", r.Email, r.Err)
+		case len(r.Issues) > 0:
+			fmt.Fprintf(&b, "%s\n", r.Email)
+			for _, issue := range r.Issues {
+				fmt.Fprintf(&b, "  - %s\n", issue)
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return "All timesheets are complete.\n"
+	}
+	return b.String()
+}
+
+// printShortfall prints the actual and expected hours of each user over the
+// period, and the cumulative shortfall of them all.
+func printShortfall(w io.Writer, startDate, endDate string, results []userResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "\nHours %s to %s\n", startDate, endDate)
+	fmt.Fprintln(tw, "User\tActual\tExpected\tShortfall")
+	var actual, expected, shortfall float64
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\n", r.Email)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%.2f\n", r.Email, r.TotalHours, r.ExpectedHours, r.shortfall())
+		actual += r.TotalHours
+		expected += r.ExpectedHours
+		shortfall += r.shortfall()
+	}
+	fmt.Fprintf(tw, "Total\t%.2f\t%.2f\t%.2f\n", actual, expected, shortfall)
+	tw.Flush()
+}
+
+// sendReport posts the summary of a check to a Slack or Google Chat webhook.
+func sendReport(webhookURL, startDate, endDate string, results []userResult) error {
+	severity := "INFO"
+	for _, r := range results {
+		if !r.ok() {
+			severity = "WARNING"
+			break
+		}
+	}
+	title := fmt.Sprintf("Timesheets %s to %s", startDate, endDate)
+	metadata := map[string]string{"Checked": fmt.Sprintf("%d users", len(results))}
+	return postMessage(webhookURL, title, summary(results), severity, metadata)
+}
+
+// postMessage posts text to a Slack or Google Chat webhook, telling them
+// apart by the host of the URL. Google Chat gets the card of the alerts.
+func postMessage(webhookURL, title, text, severity string, metadata map[string]string) error {
+	client, err := alerting.NewHTTPClient(alerting.HTTPClientConfig{})
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if u.Host != "hooks.slack.com" {
+		alert := alerting.Alert{
+			Time:     time.Now(),
+			Prefix:   title,
+			Pattern:  "timesheets",
+			Severity: severity,
+			Log:      text,
+			Metadata: metadata,
+		}
+		alert.Hostname, _ = os.Hostname()
+		if err := alerting.PostGoogleChatAlert(client, webhookURL, alert); err != nil {
+			return fmt.Errorf("failed to post to Google Chat: %w", err)
+		}
+		return nil
+	}
+
+	message, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("*%s*\n```\n%s```", title, text),
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("failed to post to Slack: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ActionExec = "exec"
+
+	defaultActionTimeout = time.Minute
+)
+
+func ValidateActions(actions []ActionConfig) error {
+	for _, action := range actions {
+		switch action.Type {
+		case ActionExec:
+			if len(action.Command) == 0 {
+				return fmt.Errorf("exec action requires a command")
+			}
+		default:
+			return fmt.Errorf("unknown action type %q", action.Type)
+		}
+	}
+	return nil
+}
+
+// RegexCaptures returns the submatches of re in log keyed by group name, or by
+// group index for unnamed groups.
+func RegexCaptures(re *regexp.Regexp, log string) map[string]string {
+	captures := make(map[string]string)
+	m := re.FindStringSubmatch(log)
+	for i, name := range re.SubexpNames() {
+		if i == 0 || i >= len(m) {
+			continue
+		}
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		captures[name] = m[i]
+	}
+	return captures
+}
+
+var envNameRegex = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+func envName(key string) string {
+	return envNameRegex.ReplaceAllString(strings.ToUpper(key), "_")
+}
+
+// RunExecAction runs the configured command with details of the alert in its
+// environment. It is intended to be run in its own goroutine.
+func RunExecAction(action ActionConfig, alert Alert, captures map[string]string) {
+	timeout := defaultActionTimeout
+	if action.TimeoutSeconds > 0 {
+		timeout = time.Duration(action.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, action.Command[0], action.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"ALERT_HOSTNAME="+alert.Hostname,
+		"ALERT_PATTERN="+alert.Pattern,
+		"ALERT_SEVERITY="+alert.Severity,
+		"ALERT_LINE="+alert.Log,
+	)
+	for name, value := range captures {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("ALERT_CAPTURE_%s=%s", envName(name), value))
+	}
+	for key, value := range alert.Metadata {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("ALERT_META_%s=%s", envName(key), value))
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running exec action %v: %v\n%s", action.Command, err, output)
+	}
+}
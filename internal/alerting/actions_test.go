@@ -0,0 +1,47 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidateActions(t *testing.T) {
+	if err := ValidateActions([]ActionConfig{{Type: ActionExec, Command: []string{"true"}}}); err != nil {
+		t.Errorf("valid exec action: %v", err)
+	}
+	if err := ValidateActions([]ActionConfig{{Type: ActionExec}}); err == nil {
+		t.Error("exec action without a command should fail")
+	}
+	if err := ValidateActions([]ActionConfig{{Type: "page"}}); err == nil {
+		t.Error("unknown action type should fail")
+	}
+}
+
+func TestRegexCaptures(t *testing.T) {
+	re := regexp.MustCompile(`batch (?P<batch>\d+) stage (\w+)`)
+	captures := RegexCaptures(re, "bad batch 7 stage execute")
+	if captures["batch"] != "7" || captures["2"] != "execute" {
+		t.Errorf("captures = %v", captures)
+	}
+}
+
+func TestRunExecAction(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	action := ActionConfig{
+		Type:    ActionExec,
+		Command: []string{"sh", "-c", `echo "$ALERT_PATTERN $ALERT_CAPTURE_BATCH $ALERT_CAPTURE_BLOCK_NUM $ALERT_META_GIT_REVISION" > "$OUT"`},
+	}
+	t.Setenv("OUT", out)
+	RunExecAction(action, Alert{Pattern: "bad batch", Metadata: map[string]string{"git revision": "abc123"}}, map[string]string{"batch": "7", "block-num": "42"})
+
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(content)); got != "bad batch 7 42 abc123" {
+		t.Errorf("action output = %q", got)
+	}
+}
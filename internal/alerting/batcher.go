@@ -0,0 +1,108 @@
+package alerting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBatchLines caps how many matched lines are included in a combined message.
+const maxBatchLines = 20
+
+// AlertBatcher collects matches per pattern for a fixed window and hands them
+// to flush as a single batch once the window started by the first match elapses.
+// A batch takes the stream of its first match.
+type AlertBatcher struct {
+	window  time.Duration
+	flush   func(pattern, stream string, logs []string)
+	pending map[string][]string
+	streams map[string]string
+	started map[string]time.Time
+	// clock, when set, replaces timers: windows are measured in the clock's
+	// time and only expire when flushExpired is called, e.g. when replaying logs.
+	clock func() time.Time
+	mu    sync.Mutex
+}
+
+func NewAlertBatcher(window time.Duration, flush func(pattern, stream string, logs []string)) *AlertBatcher {
+	return &AlertBatcher{
+		window:  window,
+		flush:   flush,
+		pending: make(map[string][]string),
+		streams: make(map[string]string),
+		started: make(map[string]time.Time),
+	}
+}
+
+func (b *AlertBatcher) Add(pattern, stream, log string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.pending[pattern]; !exists {
+		b.streams[pattern] = stream
+		if b.clock != nil {
+			b.started[pattern] = b.clock()
+		} else {
+			time.AfterFunc(b.window, func() { b.flushPattern(pattern) })
+		}
+	}
+	b.pending[pattern] = append(b.pending[pattern], log)
+}
+
+func (b *AlertBatcher) flushExpired(now time.Time) {
+	b.mu.Lock()
+	var expired []string
+	for pattern, started := range b.started {
+		if now.Sub(started) >= b.window {
+			expired = append(expired, pattern)
+		}
+	}
+	b.mu.Unlock()
+	sort.Strings(expired)
+	for _, pattern := range expired {
+		b.flushPattern(pattern)
+	}
+}
+
+func (b *AlertBatcher) flushPattern(pattern string) {
+	b.mu.Lock()
+	logs := b.pending[pattern]
+	stream := b.streams[pattern]
+	delete(b.pending, pattern)
+	delete(b.streams, pattern)
+	delete(b.started, pattern)
+	b.mu.Unlock()
+	if len(logs) > 0 {
+		b.flush(pattern, stream, logs)
+	}
+}
+
+// Flush sends every pending batch immediately, e.g. when the input is closed.
+func (b *AlertBatcher) Flush() {
+	b.mu.Lock()
+	patterns := make([]string, 0, len(b.pending))
+	for pattern := range b.pending {
+		patterns = append(patterns, pattern)
+	}
+	b.mu.Unlock()
+	for _, pattern := range patterns {
+		b.flushPattern(pattern)
+	}
+}
+
+// CombineLogs joins the lines of a batch into a single message body.
+func CombineLogs(logs []string) string {
+	if len(logs) == 1 {
+		return logs[0]
+	}
+	shown := logs
+	if len(shown) > maxBatchLines {
+		shown = shown[:maxBatchLines]
+	}
+	combined := fmt.Sprintf("%d matches:\n%s", len(logs), strings.Join(shown, "\n"))
+	if len(logs) > len(shown) {
+		combined = fmt.Sprintf("%s\n... and %d more", combined, len(logs)-len(shown))
+	}
+	return combined
+}
@@ -0,0 +1,67 @@
+package alerting
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAlertBatcherFlushesAfterWindow(t *testing.T) {
+	flushed := make(chan []string, 1)
+	b := NewAlertBatcher(20*time.Millisecond, func(pattern, stream string, logs []string) {
+		flushed <- logs
+	})
+
+	b.Add("p", "", "one")
+	b.Add("p", "", "two")
+
+	select {
+	case logs := <-flushed:
+		if len(logs) != 2 || logs[0] != "one" || logs[1] != "two" {
+			t.Fatalf("flushed %v, want [one two]", logs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("batch was not flushed after the window elapsed")
+	}
+}
+
+func TestAlertBatcherFlush(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[string]int)
+	b := NewAlertBatcher(time.Hour, func(pattern, stream string, logs []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got[pattern] = len(logs)
+	})
+
+	b.Add("a", "", "1")
+	b.Add("a", "", "2")
+	b.Add("b", "", "3")
+	b.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got["a"] != 2 || got["b"] != 1 {
+		t.Fatalf("flushed batches %v, want a:2 b:1", got)
+	}
+}
+
+func TestCombineLogs(t *testing.T) {
+	if got := CombineLogs([]string{"only"}); got != "only" {
+		t.Errorf("CombineLogs single = %q, want %q", got, "only")
+	}
+
+	logs := make([]string, maxBatchLines+5)
+	for i := range logs {
+		logs[i] = fmt.Sprintf("line %d", i)
+	}
+	got := CombineLogs(logs)
+	if !strings.HasPrefix(got, fmt.Sprintf("%d matches:\n", len(logs))) {
+		t.Errorf("CombineLogs missing count header: %q", got)
+	}
+	if !strings.HasSuffix(got, "... and 5 more") {
+		t.Errorf("CombineLogs missing truncation marker: %q", got)
+	}
+}
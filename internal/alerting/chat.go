@@ -0,0 +1,242 @@
+package alerting
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const defaultHTTPTimeout = 10 * time.Second
+
+// NewHTTPClient builds the client used for outbound webhooks. Without an
+// explicit proxy the standard HTTP(S)_PROXY environment variables apply.
+func NewHTTPClient(cfg HTTPClientConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %w", cfg.ProxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if cfg.CABundle != "" {
+		pem, err := os.ReadFile(cfg.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", cfg.CABundle, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	timeout := defaultHTTPTimeout
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// Alert is a single outbound notification for a matched pattern.
+type Alert struct {
+	Time             time.Time         `json:"time"`
+	Hostname         string            `json:"hostname"`
+	Prefix           string            `json:"prefix,omitempty"`
+	Service          string            `json:"service,omitempty"`
+	Pattern          string            `json:"pattern"`
+	Stream           string            `json:"stream,omitempty"`
+	Severity         string            `json:"severity"`
+	Log              string            `json:"log"`
+	RunbookURL       string            `json:"runbookURL,omitempty"`
+	AckURL           string            `json:"ackURL,omitempty"`
+	ThreadKey        string            `json:"threadKey,omitempty"`
+	SuppressionCount int               `json:"suppressionCount"`
+	TotalMatches     int64             `json:"totalMatches"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	// Module and Fields are parsed from the first matched erigon log line.
+	Module string            `json:"module,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// ThreadKey derives a stable Google Chat thread key from a pattern so that
+// repeated alerts for the same issue are grouped into one thread.
+func ThreadKey(pattern string) string {
+	sum := sha256.Sum256([]byte(pattern))
+	return "alert-" + hex.EncodeToString(sum[:8])
+}
+
+// threadedWebhookURL asks Chat to reply in the keyed thread, starting a new
+// thread if it doesn't exist yet.
+func threadedWebhookURL(webhookURL string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func decoratedText(label, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"decoratedText": map[string]interface{}{
+			"topLabel": label,
+			"text":     html.EscapeString(text),
+		},
+	}
+}
+
+func linkButton(text, url string) map[string]interface{} {
+	return map[string]interface{}{
+		"text":    text,
+		"onClick": map[string]interface{}{"openLink": map[string]interface{}{"url": url}},
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// BuildChatCard renders an alert as a Google Chat cardsV2 message.
+func BuildChatCard(alert Alert) map[string]interface{} {
+	details := []interface{}{
+		decoratedText("Host", alert.Hostname),
+	}
+	if alert.Service != "" {
+		details = append(details, decoratedText("Service", alert.Service))
+	}
+	details = append(details,
+		decoratedText("Pattern", alert.Pattern),
+		decoratedText("Severity", alert.Severity),
+	)
+	if alert.Stream != "" {
+		details = append(details, decoratedText("Stream", alert.Stream))
+	}
+	if alert.SuppressionCount > 0 {
+		details = append(details, decoratedText("Suppressed", fmt.Sprintf("%d duplicate(s)", alert.SuppressionCount)))
+	}
+	if alert.TotalMatches > 1 {
+		details = append(details, decoratedText("Total matches", strconv.FormatInt(alert.TotalMatches, 10)))
+	}
+	for _, key := range sortedKeys(alert.Metadata) {
+		details = append(details, decoratedText(key, alert.Metadata[key]))
+	}
+	if alert.Module != "" {
+		details = append(details, decoratedText("Module", alert.Module))
+	}
+	for _, key := range sortedKeys(alert.Fields) {
+		details = append(details, decoratedText(key, alert.Fields[key]))
+	}
+
+	sections := []interface{}{
+		map[string]interface{}{"widgets": details},
+		map[string]interface{}{
+			"header": "Matched line",
+			"widgets": []interface{}{
+				map[string]interface{}{
+					"textParagraph": map[string]interface{}{"text": html.EscapeString(alert.Log)},
+				},
+			},
+		},
+	}
+	var buttons []interface{}
+	if alert.RunbookURL != "" {
+		buttons = append(buttons, linkButton("View runbook", alert.RunbookURL))
+	}
+	if alert.AckURL != "" {
+		buttons = append(buttons, linkButton("Acknowledge", alert.AckURL))
+	}
+	if len(buttons) > 0 {
+		sections = append(sections, map[string]interface{}{
+			"widgets": []interface{}{
+				map[string]interface{}{
+					"buttonList": map[string]interface{}{"buttons": buttons},
+				},
+			},
+		})
+	}
+
+	title := alert.Prefix
+	if title == "" {
+		title = fmt.Sprintf("%s alert", alert.Severity)
+	}
+	return map[string]interface{}{
+		"cardsV2": []interface{}{
+			map[string]interface{}{
+				"cardId": "alert",
+				"card": map[string]interface{}{
+					"header": map[string]interface{}{
+						"title":    title,
+						"subtitle": alert.Hostname,
+					},
+					"sections": sections,
+				},
+			},
+		},
+	}
+}
+
+// SendGoogleChatAlert posts alert to a Google Chat webhook, logging failures
+// to stderr.
+func SendGoogleChatAlert(client *http.Client, webhookURL string, alert Alert) {
+	if err := PostGoogleChatAlert(client, webhookURL, alert); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending alert: %v\n", err)
+	}
+}
+
+// PostGoogleChatAlert posts alert to a Google Chat webhook as a card,
+// threaded by its ThreadKey.
+func PostGoogleChatAlert(client *http.Client, webhookURL string, alert Alert) error {
+	message := BuildChatCard(alert)
+	if alert.ThreadKey != "" {
+		message["thread"] = map[string]string{"threadKey": alert.ThreadKey}
+		var err error
+		webhookURL, err = threadedWebhookURL(webhookURL)
+		if err != nil {
+			return fmt.Errorf("failed to parse webhook URL: %w", err)
+		}
+	}
+	messageBytes, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to create JSON message: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(messageBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("Google Chat responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package alerting
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendGoogleChatAlert(t *testing.T) {
+	var body map[string]interface{}
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("invalid JSON body: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SendGoogleChatAlert(client, server.URL+"/hook?key=abc", Alert{
+		Hostname:   "node-1",
+		Pattern:    "bad batch",
+		Severity:   "CRITICAL",
+		Log:        "<bad> batch",
+		RunbookURL: "https://runbooks.example/bad-batch",
+		ThreadKey:  ThreadKey("bad batch"),
+	})
+
+	if !strings.Contains(query, "key=abc") || !strings.Contains(query, "messageReplyOption=REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD") {
+		t.Errorf("query = %q, want original key and reply option", query)
+	}
+	thread, _ := body["thread"].(map[string]interface{})
+	if thread["threadKey"] != ThreadKey("bad batch") {
+		t.Errorf("thread = %v", body["thread"])
+	}
+	encoded, _ := json.Marshal(body["cardsV2"])
+	for _, want := range []string{"node-1", "CRITICAL", "\\u0026lt;bad\\u0026gt; batch", "https://runbooks.example/bad-batch"} {
+		if !strings.Contains(string(encoded), want) {
+			t.Errorf("card missing %q: %s", want, encoded)
+		}
+	}
+}
+
+func TestPostGoogleChatAlertFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid card", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient(HTTPClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PostGoogleChatAlert(client, server.URL, Alert{Pattern: "bad batch"}); err == nil {
+		t.Error("rejected alert returned no error")
+	}
+}
+
+func TestThreadKeyIsStablePerPattern(t *testing.T) {
+	if ThreadKey("a") != ThreadKey("a") {
+		t.Error("ThreadKey should be deterministic")
+	}
+	if ThreadKey("a") == ThreadKey("b") {
+		t.Error("ThreadKey should differ between patterns")
+	}
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	client, err := NewHTTPClient(HTTPClientConfig{TimeoutSeconds: 3, ProxyURL: "http://proxy.example:3128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Timeout.Seconds() != 3 {
+		t.Errorf("Timeout = %v, want 3s", client.Timeout)
+	}
+	req, _ := http.NewRequest("POST", "https://chat.googleapis.com/v1/spaces", nil)
+	proxy, err := client.Transport.(*http.Transport).Proxy(req)
+	if err != nil || proxy == nil || proxy.Host != "proxy.example:3128" {
+		t.Errorf("proxy = %v, %v", proxy, err)
+	}
+
+	if _, err := NewHTTPClient(HTTPClientConfig{CABundle: "/does/not/exist.pem"}); err == nil {
+		t.Error("missing CA bundle should fail")
+	}
+}
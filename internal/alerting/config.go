@@ -0,0 +1,210 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultSeverity = "ERROR"
+)
+
+type PatternConfig struct {
+	Pattern        string `json:"pattern"`
+	TimeoutMinutes int    `json:"timeoutMinutes"`
+	Severity       string `json:"severity"`
+	RunbookURL     string `json:"runbookURL"`
+	MinLevel       string `json:"minLevel"`
+	// WebhookURL routes the pattern's alerts to another webhook than the
+	// config's.
+	WebhookURL string `json:"webhookURL"`
+
+	// Module and Fields are regexes the module and key=val fields of an
+	// erigon log line must match, e.g. {"module": "Execution"}.
+	Module string            `json:"module"`
+	Fields map[string]string `json:"fields"`
+
+	CaseInsensitive bool `json:"caseInsensitive"`
+	Multiline       bool `json:"multiline"`
+	WholeWord       bool `json:"wholeWord"`
+	Literal         bool `json:"literal"`
+
+	OutputFile string         `json:"outputFile"`
+	Actions    []ActionConfig `json:"actions"`
+	SampleRate int            `json:"sampleRate"`
+}
+
+type ActionConfig struct {
+	Type           string   `json:"type"`
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+}
+
+type HTTPClientConfig struct {
+	ProxyURL       string `json:"proxyURL"`
+	CABundle       string `json:"caBundle"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+type LogRotationConfig struct {
+	MaxSizeMB     int  `json:"maxSizeMB"`
+	IntervalHours int  `json:"intervalHours"`
+	MaxBackups    int  `json:"maxBackups"`
+	Compress      bool `json:"compress"`
+}
+
+type EnrichmentConfig struct {
+	Environment string            `json:"environment"`
+	GitRepo     string            `json:"gitRepo"`
+	EnvVars     []string          `json:"envVars"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// SharedStateConfig points at a Redis server holding cooldowns shared by all
+// hosts running the same service, so the fleet alerts once per key.
+type SharedStateConfig struct {
+	RedisAddr      string `json:"redisAddr"`
+	Password       string `json:"password"`
+	DB             int    `json:"db"`
+	KeyPrefix      string `json:"keyPrefix"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+// EventConfig overrides the severity and webhook of an event the supervising
+// program raises, such as erigon-runner's crash or stall alerts.
+type EventConfig struct {
+	Severity   string `json:"severity"`
+	WebhookURL string `json:"webhookURL"`
+}
+
+type Config struct {
+	WebhookURL            string            `json:"webhookURL"`
+	Patterns              []PatternConfig   `json:"patterns"`
+	LogFile               string            `json:"logFile"`
+	AlertCooldownMinutes  int               `json:"alertCooldownMinutes"`
+	DefaultTimeoutMinutes int               `json:"defaultTimeoutMinutes"`
+	DedupByFingerprint    bool              `json:"dedupByFingerprint"`
+	BatchWindowSeconds    int               `json:"batchWindowSeconds"`
+	ThreadByPattern       bool              `json:"threadByPattern"`
+	HTTPClient            HTTPClientConfig  `json:"httpClient"`
+	LogLevelThreshold     string            `json:"logLevelThreshold"`
+	GrokPatterns          map[string]string `json:"grokPatterns"`
+	LogRotation           LogRotationConfig `json:"logRotation"`
+	Enrichment            EnrichmentConfig  `json:"enrichment"`
+	ControlAddr           string            `json:"controlAddr"`
+	ControlToken          string            `json:"controlToken"`
+	AckURL                string            `json:"ackURL"`
+	AckSilenceMinutes     int               `json:"ackSilenceMinutes"`
+	HistoryFile           string            `json:"historyFile"`
+	MaxLineBytes          int               `json:"maxLineBytes"`
+	AlertOnStderr         bool              `json:"alertOnStderr"`
+	SharedState           SharedStateConfig `json:"sharedState"`
+	Services              []ServiceConfig   `json:"services"`
+
+	// SeverityWebhooks routes the alerts of a severity, e.g. CRITICAL, that
+	// no pattern or event routes elsewhere.
+	SeverityWebhooks map[string]string      `json:"severityWebhooks"`
+	Events           map[string]EventConfig `json:"events"`
+
+	Thresholds []ThresholdConfig `json:"thresholds"`
+}
+
+// ServiceConfig is a named, independently alerted input. Each service has its
+// own patterns, sinks and cooldown state.
+type ServiceConfig struct {
+	Name  string `json:"name"`
+	Input string `json:"input"`
+	Config
+}
+
+func ReadConfig(filePath string) (*Config, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", filePath, err)
+	}
+	var config Config
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", filePath, err)
+	}
+	if err := resolveSecrets(&config); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range config.Services {
+		service := &config.Services[i]
+		if service.Name == "" {
+			return nil, fmt.Errorf("service %d has no name", i)
+		}
+		if names[service.Name] {
+			return nil, fmt.Errorf("duplicate service name %s", service.Name)
+		}
+		names[service.Name] = true
+		if len(service.Services) > 0 {
+			return nil, fmt.Errorf("service %s: services cannot be nested", service.Name)
+		}
+		if err := resolveSecrets(&service.Config); err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+	}
+	return &config, nil
+}
+
+func resolveSecrets(config *Config) error {
+	var err error
+	if config.WebhookURL, err = ResolveSecret(config.WebhookURL); err != nil {
+		return fmt.Errorf("failed to resolve webhookURL: %w", err)
+	}
+	for i := range config.Patterns {
+		pattern := &config.Patterns[i]
+		if pattern.WebhookURL, err = ResolveSecret(pattern.WebhookURL); err != nil {
+			return fmt.Errorf("failed to resolve webhookURL of pattern %s: %w", pattern.Pattern, err)
+		}
+	}
+	for severity, url := range config.SeverityWebhooks {
+		if config.SeverityWebhooks[severity], err = ResolveSecret(url); err != nil {
+			return fmt.Errorf("failed to resolve webhook of severity %s: %w", severity, err)
+		}
+	}
+	for name, event := range config.Events {
+		if event.WebhookURL, err = ResolveSecret(event.WebhookURL); err != nil {
+			return fmt.Errorf("failed to resolve webhookURL of event %s: %w", name, err)
+		}
+		config.Events[name] = event
+	}
+	if config.HTTPClient.ProxyURL, err = ResolveSecret(config.HTTPClient.ProxyURL); err != nil {
+		return fmt.Errorf("failed to resolve proxyURL: %w", err)
+	}
+	if config.SharedState.Password, err = ResolveSecret(config.SharedState.Password); err != nil {
+		return fmt.Errorf("failed to resolve sharedState password: %w", err)
+	}
+	if config.ControlToken, err = ResolveSecret(config.ControlToken); err != nil {
+		return fmt.Errorf("failed to resolve controlToken: %w", err)
+	}
+	return nil
+}
+
+// ResolveSecret expands "env:NAME" and "file:/path" references so secrets
+// don't have to be stored in the config file itself. Other values are
+// returned unchanged.
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		path := strings.TrimPrefix(value, "file:")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %w", path, err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	}
+	return value, nil
+}
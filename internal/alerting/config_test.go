@@ -0,0 +1,112 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("ALERTING_TEST_WEBHOOK", "https://chat.example/hook")
+	secretFile := filepath.Join(t.TempDir(), "webhook")
+	if err := os.WriteFile(secretFile, []byte("https://chat.example/from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"https://plain.example", "https://plain.example"},
+		{"env:ALERTING_TEST_WEBHOOK", "https://chat.example/hook"},
+		{"file:" + secretFile, "https://chat.example/from-file"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := ResolveSecret(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveSecret(%q) = %q, %v; want %q", tt.value, got, err, tt.want)
+		}
+	}
+
+	if _, err := ResolveSecret("env:ALERTING_TEST_UNSET"); err == nil {
+		t.Error("unset environment variable should fail")
+	}
+	if _, err := ResolveSecret("file:/does/not/exist"); err == nil {
+		t.Error("missing secret file should fail")
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	t.Setenv("ALERTING_TEST_WEBHOOK", "https://chat.example/hook")
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+		"webhookURL": "env:ALERTING_TEST_WEBHOOK",
+		"patterns": [{"pattern": "bad batch", "timeoutMinutes": 5, "severity": "CRITICAL", "webhookURL": "env:ALERTING_TEST_WEBHOOK"}],
+		"defaultTimeoutMinutes": 10,
+		"severityWebhooks": {"CRITICAL": "env:ALERTING_TEST_WEBHOOK"},
+		"events": {"crash": {"severity": "CRITICAL", "webhookURL": "env:ALERTING_TEST_WEBHOOK"}}
+	}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.WebhookURL != "https://chat.example/hook" {
+		t.Errorf("WebhookURL = %q", config.WebhookURL)
+	}
+	if len(config.Patterns) != 1 || config.Patterns[0].Severity != "CRITICAL" || config.Patterns[0].WebhookURL != "https://chat.example/hook" {
+		t.Errorf("Patterns = %+v", config.Patterns)
+	}
+	if config.SeverityWebhooks["CRITICAL"] != "https://chat.example/hook" {
+		t.Errorf("SeverityWebhooks = %v", config.SeverityWebhooks)
+	}
+	if event := config.Events["crash"]; event.Severity != "CRITICAL" || event.WebhookURL != "https://chat.example/hook" {
+		t.Errorf("Events = %+v", config.Events)
+	}
+
+	if _, err := ReadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing config file should fail")
+	}
+}
+
+func TestReadConfigServices(t *testing.T) {
+	t.Setenv("ALERTING_TEST_WEBHOOK", "https://chat.example/hook")
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	config, err := ReadConfig(write(`{"services": [
+		{"name": "erigon", "input": "/var/log/erigon.log", "webhookURL": "env:ALERTING_TEST_WEBHOOK", "patterns": [{"pattern": "bad batch"}]},
+		{"name": "prover", "patterns": [{"pattern": "oom"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Services) != 2 {
+		t.Fatalf("Services = %+v", config.Services)
+	}
+	erigon := config.Services[0]
+	if erigon.Input != "/var/log/erigon.log" || erigon.WebhookURL != "https://chat.example/hook" || erigon.Patterns[0].Pattern != "bad batch" {
+		t.Errorf("erigon service = %+v", erigon)
+	}
+
+	invalid := []string{
+		`{"services": [{"patterns": []}]}`,
+		`{"services": [{"name": "a"}, {"name": "a"}]}`,
+		`{"services": [{"name": "a", "services": [{"name": "b"}]}]}`,
+	}
+	for _, content := range invalid {
+		if _, err := ReadConfig(write(content)); err == nil {
+			t.Errorf("ReadConfig(%s) should fail", content)
+		}
+	}
+}
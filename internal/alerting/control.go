@@ -0,0 +1,185 @@
+package alerting
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAckSilence is how long an acknowledged pattern stays silenced unless
+// configured otherwise.
+const DefaultAckSilence = time.Hour
+
+// ControlHandler exposes the suppression state of an AlertManager over HTTP:
+//
+//	GET    /cooldowns                         list active cooldowns and silences
+//	POST   /cooldowns/reset?pattern=P         reset every cooldown of P
+//	POST   /silence?pattern=P&minutes=N       silence P for N minutes
+//	DELETE /silence?pattern=P                 lift a silence early
+//	GET    /ack?pattern=P                     form linked from chat alerts
+//	POST   /ack?pattern=P&by=NAME[&minutes=N] acknowledge and silence P
+//
+// Acknowledgements are recorded in history. With a token, requests changing
+// the state must give it as an "Authorization: Bearer" header, or as the
+// token field of the ack form.
+func ControlHandler(am *AlertManager, history *History, ackSilence time.Duration, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/cooldowns", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"cooldowns": am.Cooldowns(),
+			"silences":  am.Silences(),
+		})
+	})
+
+	mux.HandleFunc("/cooldowns/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pattern, ok := knownPattern(w, r, am)
+		if !ok {
+			return
+		}
+		writeJSON(w, map[string]interface{}{"pattern": pattern, "reset": am.ResetCooldown(pattern)})
+	})
+
+	mux.HandleFunc("/silence", func(w http.ResponseWriter, r *http.Request) {
+		pattern, ok := knownPattern(w, r, am)
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			minutes, err := strconv.Atoi(r.URL.Query().Get("minutes"))
+			if err != nil || minutes <= 0 {
+				http.Error(w, "minutes must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			until := am.Silence(pattern, time.Duration(minutes)*time.Minute)
+			writeJSON(w, map[string]interface{}{"pattern": pattern, "until": until})
+		case http.MethodDelete:
+			am.Unsilence(pattern)
+			writeJSON(w, map[string]interface{}{"pattern": pattern})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/ack", func(w http.ResponseWriter, r *http.Request) {
+		pattern, ok := knownPattern(w, r, am)
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			ackForm.Execute(w, map[string]interface{}{"Pattern": pattern, "Minutes": int(ackSilence.Minutes()), "Token": token != ""})
+		case http.MethodPost:
+			by := r.FormValue("by")
+			if by == "" {
+				http.Error(w, "by is required", http.StatusBadRequest)
+				return
+			}
+			silence := ackSilence
+			if m := r.FormValue("minutes"); m != "" {
+				minutes, err := strconv.Atoi(m)
+				if err != nil || minutes <= 0 {
+					http.Error(w, "minutes must be a positive integer", http.StatusBadRequest)
+					return
+				}
+				silence = time.Duration(minutes) * time.Minute
+			}
+			until := am.Silence(pattern, silence)
+			history.Record(HistoryEvent{Type: HistoryAck, Pattern: pattern, By: by, Until: &until})
+			writeJSON(w, map[string]interface{}{"pattern": pattern, "by": by, "until": until})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if given == "" {
+				given = r.PostFormValue("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// checkControlAddr refuses to expose the control API beyond the host without
+// a token, as anyone reaching it could silence every alert.
+func checkControlAddr(addr, token string) error {
+	if token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid controlAddr %s: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("controlAddr %s is not a loopback address, set controlToken to expose it", addr)
+	}
+	return nil
+}
+
+var ackForm = template.Must(template.New("ack").Parse(`<!DOCTYPE html>
+<html><body>
+<h3>Acknowledge alert</h3>
+<form method="post">
+<input type="hidden" name="pattern" value="{{.Pattern}}">
+<p>Pattern: <code>{{.Pattern}}</code></p>
+<p><label>Your name <input name="by" required></label></p>
+<p><label>Silence for <input name="minutes" type="number" min="1" value="{{.Minutes}}"> minutes</label></p>
+{{if .Token}}<p><label>Token <input name="token" type="password" required></label></p>
+{{end}}<button type="submit">Acknowledge</button>
+</form>
+</body></html>
+`))
+
+// AckLink returns the URL of the acknowledgement form for pattern on a
+// control API reachable at baseURL.
+func AckLink(baseURL, pattern string) string {
+	return strings.TrimRight(baseURL, "/") + "/ack?" + url.Values{"pattern": {pattern}}.Encode()
+}
+
+func knownPattern(w http.ResponseWriter, r *http.Request, am *AlertManager) (string, bool) {
+	pattern := r.FormValue("pattern")
+	if pattern == "" {
+		http.Error(w, "pattern is required", http.StatusBadRequest)
+		return "", false
+	}
+	if !am.KnowsPattern(pattern) {
+		http.Error(w, fmt.Sprintf("unknown pattern %q", pattern), http.StatusNotFound)
+		return "", false
+	}
+	return pattern, true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing control API response: %v\n", err)
+	}
+}
@@ -0,0 +1,185 @@
+package alerting
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlHandler(t *testing.T) {
+	am := NewAlertManager(time.Hour, map[string]time.Duration{"bad batch": time.Hour})
+	am.ShouldSendAlert("bad batch", "bad batch")
+	server := httptest.NewServer(ControlHandler(am, nil, time.Hour, ""))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/cooldowns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listing struct {
+		Cooldowns []CooldownState `json:"cooldowns"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&listing)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Cooldowns) != 1 || listing.Cooldowns[0].Pattern != "bad batch" {
+		t.Fatalf("cooldowns = %+v", listing.Cooldowns)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodPost, "/cooldowns/reset?pattern=bad+batch", http.StatusOK},
+		{http.MethodPost, "/cooldowns/reset?pattern=unknown", http.StatusNotFound},
+		{http.MethodGet, "/cooldowns/reset?pattern=bad+batch", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/silence?pattern=bad+batch&minutes=5", http.StatusOK},
+		{http.MethodPost, "/silence?pattern=bad+batch&minutes=0", http.StatusBadRequest},
+		{http.MethodPost, "/silence", http.StatusBadRequest},
+		{http.MethodDelete, "/silence?pattern=bad+batch", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+
+	if send, _ := am.ShouldSendAlert("bad batch", "bad batch"); !send {
+		t.Error("alert should be sent after reset and unsilence")
+	}
+}
+
+func TestControlHandlerAck(t *testing.T) {
+	historyPath := filepath.Join(t.TempDir(), "history.jsonl")
+	history, err := NewHistory(historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := NewAlertManager(0, map[string]time.Duration{"bad batch": 0})
+	server := httptest.NewServer(ControlHandler(am, history, time.Hour, ""))
+	defer server.Close()
+
+	link := AckLink(server.URL+"/", "bad batch")
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(form), `value="bad batch"`) {
+		t.Errorf("ack form doesn't carry the pattern: %s", form)
+	}
+
+	resp, err = http.PostForm(server.URL+"/ack", url.Values{"pattern": {"bad batch"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("ack without by = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp, err = http.PostForm(server.URL+"/ack", url.Values{"pattern": {"bad batch"}, "by": {"alice"}, "minutes": {"30"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("ack = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if send, _ := am.ShouldSendAlert("bad batch", "bad batch"); send {
+		t.Error("acknowledged pattern should be silenced")
+	}
+
+	history.Close()
+	content, err := os.ReadFile(historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event HistoryEvent
+	if err := json.Unmarshal(content, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != HistoryAck || event.By != "alice" || event.Until == nil {
+		t.Errorf("history event = %+v", event)
+	}
+}
+
+func TestControlHandlerToken(t *testing.T) {
+	am := NewAlertManager(time.Hour, map[string]time.Duration{"bad batch": time.Hour})
+	server := httptest.NewServer(ControlHandler(am, nil, time.Hour, "secret"))
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		auth   string
+		status int
+	}{
+		{http.MethodGet, "/cooldowns", "", http.StatusOK},
+		{http.MethodPost, "/silence?pattern=bad+batch&minutes=5", "", http.StatusUnauthorized},
+		{http.MethodPost, "/silence?pattern=bad+batch&minutes=5", "Bearer wrong", http.StatusUnauthorized},
+		{http.MethodPost, "/silence?pattern=bad+batch&minutes=5", "Bearer secret", http.StatusOK},
+		{http.MethodPost, "/cooldowns/reset?pattern=bad+batch", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s with %q = %d, want %d", tt.method, tt.path, tt.auth, resp.StatusCode, tt.status)
+		}
+	}
+
+	resp, err := http.PostForm(server.URL+"/ack", url.Values{"pattern": {"bad batch"}, "by": {"alice"}, "token": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("ack with the form token = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestCheckControlAddr(t *testing.T) {
+	tests := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"127.0.0.1:9090", "", true},
+		{"localhost:9090", "", true},
+		{"[::1]:9090", "", true},
+		{":9090", "", false},
+		{"0.0.0.0:9090", "", false},
+		{"10.0.0.5:9090", "", false},
+		{":9090", "secret", true},
+	}
+	for _, tt := range tests {
+		if err := checkControlAddr(tt.addr, tt.token); (err == nil) != tt.ok {
+			t.Errorf("checkControlAddr(%q, %q) = %v, want ok %v", tt.addr, tt.token, err, tt.ok)
+		}
+	}
+}
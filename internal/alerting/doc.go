@@ -0,0 +1,4 @@
+// Package alerting implements the log alerting pipeline shared by
+// output_alerts and erigon-runner: pattern matching, cooldown based
+// suppression, batching and the Google Chat sink.
+package alerting
@@ -0,0 +1,28 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSONEmitter writes every fired alert as one JSON object per line, for
+// consumption by jq, vector or another collector.
+type JSONEmitter struct {
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+func NewJSONEmitter(w io.Writer) *JSONEmitter {
+	return &JSONEmitter{enc: json.NewEncoder(w)}
+}
+
+func (e *JSONEmitter) Emit(alert Alert) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(alert); err != nil {
+		fmt.Fprintf(os.Stderr, "Error emitting JSON alert: %v\n", err)
+	}
+}
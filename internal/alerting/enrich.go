@@ -0,0 +1,34 @@
+package alerting
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CollectMetadata gathers the static context attached to every alert. It runs
+// once at startup, so a failing git lookup is reported and skipped.
+func CollectMetadata(cfg EnrichmentConfig) map[string]string {
+	metadata := make(map[string]string)
+	for key, value := range cfg.Labels {
+		metadata[key] = value
+	}
+	if cfg.Environment != "" {
+		metadata["environment"] = cfg.Environment
+	}
+	if cfg.GitRepo != "" {
+		out, err := exec.Command("git", "-C", cfg.GitRepo, "rev-parse", "--short", "HEAD").Output()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading git revision of %s: %v\n", cfg.GitRepo, err)
+		} else {
+			metadata["git revision"] = strings.TrimSpace(string(out))
+		}
+	}
+	for _, name := range cfg.EnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
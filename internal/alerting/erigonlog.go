@@ -0,0 +1,82 @@
+package alerting
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LogLine is an erigon log line split into its parts.
+type LogLine struct {
+	Level   Level
+	Module  string
+	Message string
+	Fields  map[string]string
+}
+
+var (
+	erigonLineRegex  = regexp.MustCompile(`^\[(\w+)\]\s+(?:\[\d{2}-\d{2}\|[\d:.]+\]\s+)?(?:\[([^\]]*)\]\s*)?(.*)$`)
+	stagePrefixRegex = regexp.MustCompile(`^\d+/\d+\s+`)
+	logFieldRegex    = regexp.MustCompile(`(?:^|\s)([\w./-]+)=("(?:[^"\\]|\\.)*"|\S*)`)
+)
+
+// ParseLogLine parses erigon's "[LVL] [01-02|15:04:05.000] [module] msg
+// key=val" format. The module drops a stage's "5/15 " position, so
+// "[5/15 Execution]" becomes "Execution". It reports false for lines in any
+// other format.
+func ParseLogLine(log string) (LogLine, bool) {
+	m := erigonLineRegex.FindStringSubmatch(strings.TrimRight(log, "\r\n"))
+	if m == nil {
+		return LogLine{}, false
+	}
+	level, ok := levelNames[strings.ToUpper(m[1])]
+	if !ok {
+		return LogLine{}, false
+	}
+	line := LogLine{
+		Level:  level,
+		Module: stagePrefixRegex.ReplaceAllString(strings.TrimSpace(m[2]), ""),
+	}
+	rest := m[3]
+	fields := logFieldRegex.FindAllStringSubmatchIndex(rest, -1)
+	if len(fields) == 0 {
+		line.Message = strings.TrimSpace(rest)
+		return line, true
+	}
+	line.Message = strings.TrimSpace(rest[:fields[0][0]])
+	line.Fields = make(map[string]string, len(fields))
+	for _, f := range fields {
+		value := rest[f[4]:f[5]]
+		if strings.HasPrefix(value, `"`) {
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+		}
+		line.Fields[rest[f[2]:f[3]]] = value
+	}
+	return line, true
+}
+
+// compileFieldFilters compiles the module and field regexes of a pattern.
+func compileFieldFilters(pc PatternConfig) (*regexp.Regexp, map[string]*regexp.Regexp, error) {
+	var module *regexp.Regexp
+	if pc.Module != "" {
+		var err error
+		if module, err = regexp.Compile(pc.Module); err != nil {
+			return nil, nil, fmt.Errorf("invalid module: %w", err)
+		}
+	}
+	var fields map[string]*regexp.Regexp
+	for key, expr := range pc.Fields {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid field %s: %w", key, err)
+		}
+		if fields == nil {
+			fields = make(map[string]*regexp.Regexp, len(pc.Fields))
+		}
+		fields[key] = regex
+	}
+	return module, fields, nil
+}
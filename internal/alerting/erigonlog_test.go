@@ -0,0 +1,73 @@
+package alerting
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		log  string
+		want LogLine
+		ok   bool
+	}{
+		{
+			log: `[INFO] [06-04|12:00:00.000] [5/15 Execution] Executed blocks          number=1234 blk/s=10.5 tx/s=1000`,
+			want: LogLine{
+				Level:   LevelInfo,
+				Module:  "Execution",
+				Message: "Executed blocks",
+				Fields:  map[string]string{"number": "1234", "blk/s": "10.5", "tx/s": "1000"},
+			},
+			ok: true,
+		},
+		{
+			log: `[EROR] [06-04|12:00:00.000] [txpool] Failed to add tx err="nonce too low" hash=0xab`,
+			want: LogLine{
+				Level:   LevelError,
+				Module:  "txpool",
+				Message: "Failed to add tx",
+				Fields:  map[string]string{"err": "nonce too low", "hash": "0xab"},
+			},
+			ok: true,
+		},
+		{
+			log:  `[WARN] [06-04|12:00:00.000] Peer dropped`,
+			want: LogLine{Level: LevelWarn, Message: "Peer dropped"},
+			ok:   true,
+		},
+		{log: "plain text line"},
+		{log: "[NOTE] not a level"},
+	}
+	for _, tt := range tests {
+		got, ok := ParseLogLine(tt.log)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseLogLine(%q) = %+v, %v, want %+v, %v", tt.log, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSearchLogStructured(t *testing.T) {
+	rules := []Rule{{
+		Pattern: "slow execution",
+		Regex:   mustCompile(t, "Executed blocks"),
+		Module:  mustCompile(t, "^Execution$"),
+		Fields:  map[string]*regexp.Regexp{"blk/s": mustCompile(t, `^0\.`)},
+	}}
+	if match, _ := SearchLog("[INFO] [06-04|12:00:00.000] [5/15 Execution] Executed blocks number=1 blk/s=0.5", rules); !match {
+		t.Error("slow execution should match")
+	}
+	if match, _ := SearchLog("[INFO] [06-04|12:00:00.000] [5/15 Execution] Executed blocks number=1 blk/s=12.5", rules); match {
+		t.Error("field filter should reject a fast execution")
+	}
+	if match, _ := SearchLog("[INFO] [06-04|12:00:00.000] [txpool] Executed blocks blk/s=0.5", rules); match {
+		t.Error("module filter should reject other modules")
+	}
+	if match, _ := SearchLog("Executed blocks blk/s=0.5", rules); match {
+		t.Error("unparsed lines should not match structured rules")
+	}
+	if match, _ := NewMatcher(rules).Match("[INFO] [06-04|12:00:00.000] [5/15 Execution] Executed blocks blk/s=0.5"); !match {
+		t.Error("Matcher should apply the same filters")
+	}
+}
@@ -0,0 +1,21 @@
+package alerting
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	timestampRegex = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?|\d{2}-\d{2}\|\d{2}:\d{2}:\d{2}(\.\d+)?`)
+	hexRegex       = regexp.MustCompile(`0[xX][0-9a-fA-F]+|\b[0-9a-fA-F]{16,}\b`)
+	numberRegex    = regexp.MustCompile(`\d+(\.\d+)?`)
+)
+
+// Fingerprint normalizes a log line by stripping the parts that vary between
+// occurrences of the same underlying error (timestamps, hashes, block numbers).
+func Fingerprint(log string) string {
+	log = timestampRegex.ReplaceAllString(log, "<ts>")
+	log = hexRegex.ReplaceAllString(log, "<hex>")
+	log = numberRegex.ReplaceAllString(log, "<n>")
+	return strings.Join(strings.Fields(log), " ")
+}
@@ -0,0 +1,32 @@
+package alerting
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		log  string
+		want string
+	}{
+		{
+			log:  "[EROR] [06-04|12:34:56.789] bad batch 1234",
+			want: "[EROR] [<ts>] bad batch <n>",
+		},
+		{
+			log:  "2024-06-04T12:34:56Z failed block=99 hash=0x1f2e3d",
+			want: "<ts> failed block=<n> hash=<hex>",
+		},
+		{
+			log:  "peer a1b2c3d4e5f6a7b8c9d0 dropped after 1.5 seconds",
+			want: "peer <hex> dropped after <n> seconds",
+		},
+		{
+			log:  "  extra   whitespace  ",
+			want: "extra whitespace",
+		},
+	}
+	for _, tt := range tests {
+		if got := Fingerprint(tt.log); got != tt.want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", tt.log, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package alerting
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// grokLibrary holds the named sub-patterns that rules can reference as
+// %{NAME} or, to capture the match, %{NAME:field}.
+var grokLibrary = map[string]string{
+	"INT":               `[+-]?\d+`,
+	"NUMBER":            `[+-]?\d+(?:\.\d+)?`,
+	"WORD":              `\w+`,
+	"HEX":               `0[xX][0-9a-fA-F]+`,
+	"HEX_HASH":          `0[xX][0-9a-fA-F]{64}`,
+	"ETH_ADDRESS":       `0[xX][0-9a-fA-F]{40}`,
+	"UUID":              `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	"IPV4":              `(?:\d{1,3}\.){3}\d{1,3}`,
+	"IPV6":              `(?:[0-9a-fA-F]{0,4}:){2,7}[0-9a-fA-F]{0,4}`,
+	"IP":                `(?:%{IPV4}|%{IPV6})`,
+	"PORT":              `\d{1,5}`,
+	"HOSTPORT":          `(?:%{IP}|[\w.-]+):%{PORT}`,
+	"DURATION":          `(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h))+`,
+	"TIMESTAMP_ISO8601": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:?\d{2})?`,
+	"ERIGON_TIMESTAMP":  `\d{2}-\d{2}\|\d{2}:\d{2}:\d{2}(?:\.\d+)?`,
+	"LOGLEVEL":          `(?:TRACE|TRCE|DEBUG|DBUG|INFO|WARN|WARNING|ERROR|EROR|CRIT)`,
+}
+
+var grokRefRegex = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+// maxGrokDepth bounds nested references so a cyclic custom pattern fails
+// instead of expanding forever.
+const maxGrokDepth = 10
+
+// ExpandGrok replaces %{NAME} references with their regex, looking names up
+// in custom before the bundled library.
+func ExpandGrok(pattern string, custom map[string]string) (string, error) {
+	original := pattern
+	for depth := 0; grokRefRegex.MatchString(pattern); depth++ {
+		if depth == maxGrokDepth {
+			return "", fmt.Errorf("grok references nested deeper than %d levels in %q", maxGrokDepth, original)
+		}
+		var expandErr error
+		pattern = grokRefRegex.ReplaceAllStringFunc(pattern, func(ref string) string {
+			m := grokRefRegex.FindStringSubmatch(ref)
+			sub, ok := custom[m[1]]
+			if !ok {
+				sub, ok = grokLibrary[m[1]]
+			}
+			if !ok {
+				expandErr = fmt.Errorf("unknown grok pattern %%{%s}", m[1])
+				return ref
+			}
+			if m[2] != "" {
+				return fmt.Sprintf("(?P<%s>%s)", m[2], sub)
+			}
+			return "(?:" + sub + ")"
+		})
+		if expandErr != nil {
+			return "", expandErr
+		}
+	}
+	return pattern, nil
+}
+
+// validateMatchOptions rejects option combinations that would silently do
+// nothing or contradict each other.
+func validateMatchOptions(pc PatternConfig) error {
+	if pc.Literal && grokRefRegex.MatchString(pc.Pattern) {
+		return fmt.Errorf("literal patterns cannot use grok references")
+	}
+	if pc.Multiline && !pc.Literal && !strings.ContainsAny(pc.Pattern, "^$") {
+		return fmt.Errorf("multiline has no effect without ^ or $ anchors")
+	}
+	if pc.Multiline && pc.Literal {
+		return fmt.Errorf("multiline cannot be combined with literal")
+	}
+	if pc.CaseInsensitive && strings.HasPrefix(pc.Pattern, "(?") && !pc.Literal {
+		return fmt.Errorf("caseInsensitive cannot be combined with inline flags in the pattern")
+	}
+	return nil
+}
+
+func CompilePattern(pc PatternConfig, custom map[string]string) (*regexp.Regexp, error) {
+	if err := validateMatchOptions(pc); err != nil {
+		return nil, err
+	}
+
+	expanded := regexp.QuoteMeta(pc.Pattern)
+	if !pc.Literal {
+		var err error
+		expanded, err = ExpandGrok(pc.Pattern, custom)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pc.WholeWord {
+		expanded = `\b(?:` + expanded + `)\b`
+	}
+
+	flags := ""
+	if pc.CaseInsensitive {
+		flags += "i"
+	}
+	if pc.Multiline {
+		flags += "m"
+	}
+	if flags != "" {
+		expanded = "(?" + flags + ")" + expanded
+	}
+	return regexp.Compile(expanded)
+}
@@ -0,0 +1,82 @@
+package alerting
+
+import (
+	"regexp"
+	"testing"
+)
+
+func mustCompile(t *testing.T, pattern string) *regexp.Regexp {
+	t.Helper()
+	re, err := CompilePattern(PatternConfig{Pattern: pattern}, nil)
+	if err != nil {
+		t.Fatalf("CompilePattern(%q): %v", pattern, err)
+	}
+	return re
+}
+
+func TestCompilePatternGrok(t *testing.T) {
+	re := mustCompile(t, `bad batch %{INT:batch} from %{HOSTPORT:peer} to %{ETH_ADDRESS}`)
+
+	line := "bad batch 42 from 10.0.0.1:30303 to 0x00000000000000000000000000000000000000aa"
+	captures := RegexCaptures(re, line)
+	if captures["batch"] != "42" || captures["peer"] != "10.0.0.1:30303" {
+		t.Fatalf("captures = %v", captures)
+	}
+	if re.MatchString("bad batch 42 from 10.0.0.1:30303 to 0x1234") {
+		t.Error("ETH_ADDRESS should require 40 hex digits")
+	}
+}
+
+func TestExpandGrokErrors(t *testing.T) {
+	if _, err := ExpandGrok("%{NOPE}", nil); err == nil {
+		t.Error("unknown pattern should fail")
+	}
+	if _, err := ExpandGrok("%{LOOP}", map[string]string{"LOOP": "%{LOOP}"}); err == nil {
+		t.Error("cyclic pattern should fail")
+	}
+	got, err := ExpandGrok("%{MINE}", map[string]string{"MINE": "abc"})
+	if err != nil || got != "(?:abc)" {
+		t.Errorf("custom pattern = %q, %v", got, err)
+	}
+}
+
+func TestCompilePatternOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		pc      PatternConfig
+		match   string
+		noMatch string
+	}{
+		{"case insensitive", PatternConfig{Pattern: "bad batch", CaseInsensitive: true}, "BAD BATCH", "good batch"},
+		{"whole word", PatternConfig{Pattern: "err", WholeWord: true}, "got err here", "stderr"},
+		{"literal", PatternConfig{Pattern: "a.b(c)", Literal: true}, "x a.b(c) y", "axb(c)"},
+		{"multiline", PatternConfig{Pattern: "^panic", Multiline: true}, "foo\npanic: x", "no panic"},
+	}
+	for _, tt := range tests {
+		re, err := CompilePattern(tt.pc, nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !re.MatchString(tt.match) {
+			t.Errorf("%s: should match %q", tt.name, tt.match)
+		}
+		if re.MatchString(tt.noMatch) {
+			t.Errorf("%s: should not match %q", tt.name, tt.noMatch)
+		}
+	}
+}
+
+func TestCompilePatternRejectsInvalidOptions(t *testing.T) {
+	invalid := []PatternConfig{
+		{Pattern: "%{INT}", Literal: true},
+		{Pattern: "panic", Multiline: true},
+		{Pattern: "^panic", Multiline: true, Literal: true},
+		{Pattern: "(?i)panic", CaseInsensitive: true},
+	}
+	for _, pc := range invalid {
+		if _, err := CompilePattern(pc, nil); err == nil {
+			t.Errorf("CompilePattern(%+v) should fail", pc)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	HistoryAlert = "alert"
+	HistoryAck   = "ack"
+)
+
+// HistoryEvent is one entry in the alert history file.
+type HistoryEvent struct {
+	Time     time.Time  `json:"time"`
+	Type     string     `json:"type"`
+	Service  string     `json:"service,omitempty"`
+	Pattern  string     `json:"pattern"`
+	Hostname string     `json:"hostname,omitempty"`
+	Severity string     `json:"severity,omitempty"`
+	Log      string     `json:"log,omitempty"`
+	By       string     `json:"by,omitempty"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// History appends alert and acknowledgement events to a JSON lines file. A nil
+// History discards events.
+type History struct {
+	file *os.File
+	enc  *json.Encoder
+	mu   sync.Mutex
+}
+
+func NewHistory(path string) (*History, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file %s: %w", path, err)
+	}
+	return &History{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func (h *History) Record(event HistoryEvent) {
+	if h == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.enc.Encode(event); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing history: %v\n", err)
+	}
+}
+
+func (h *History) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.file.Close()
+}
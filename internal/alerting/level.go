@@ -0,0 +1,63 @@
+package alerting
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Level is a log severity, ordered from least to most severe.
+type Level int
+
+const (
+	LevelUnknown Level = iota
+	LevelTrace
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelCrit
+)
+
+var levelNames = map[string]Level{
+	"TRACE":    LevelTrace,
+	"TRCE":     LevelTrace,
+	"DEBUG":    LevelDebug,
+	"DBUG":     LevelDebug,
+	"INFO":     LevelInfo,
+	"WARN":     LevelWarn,
+	"WARNING":  LevelWarn,
+	"ERROR":    LevelError,
+	"EROR":     LevelError,
+	"ERR":      LevelError,
+	"CRIT":     LevelCrit,
+	"CRITICAL": LevelCrit,
+	"FATAL":    LevelCrit,
+}
+
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelUnknown, nil
+	}
+	level, ok := levelNames[strings.ToUpper(name)]
+	if !ok {
+		return LevelUnknown, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+var levelTokenRegex = regexp.MustCompile(`\b(TRACE|TRCE|DEBUG|DBUG|INFO|WARN|WARNING|ERROR|EROR|CRIT|CRITICAL|FATAL)\b|\b(?:lvl|level)=(\w+)`)
+
+// ExtractLevel returns the level of the first level token found in a line,
+// covering erigon's "[EROR]" style as well as "level=error" key/values.
+func ExtractLevel(log string) Level {
+	m := levelTokenRegex.FindStringSubmatch(log)
+	if m == nil {
+		return LevelUnknown
+	}
+	token := m[1]
+	if token == "" {
+		token = m[2]
+	}
+	return levelNames[strings.ToUpper(token)]
+}
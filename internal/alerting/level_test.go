@@ -0,0 +1,49 @@
+package alerting
+
+import "testing"
+
+func TestExtractLevel(t *testing.T) {
+	tests := []struct {
+		log  string
+		want Level
+	}{
+		{"[EROR] [06-04|12:34:56.789] bad batch", LevelError},
+		{"[WARN] [06-04|12:34:56.789] slow", LevelWarn},
+		{"[INFO] [06-04|12:34:56.789] an ERROR in the message", LevelInfo},
+		{"t=2024-06-04 lvl=dbug msg=hello", LevelDebug},
+		{"level=crit something broke", LevelCrit},
+		{"panic: runtime error", LevelUnknown},
+	}
+	for _, tt := range tests {
+		if got := ExtractLevel(tt.log); got != tt.want {
+			t.Errorf("ExtractLevel(%q) = %d, want %d", tt.log, got, tt.want)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("warn"); err != nil || level != LevelWarn {
+		t.Errorf("ParseLevel(warn) = %d, %v", level, err)
+	}
+	if level, err := ParseLevel(""); err != nil || level != LevelUnknown {
+		t.Errorf("ParseLevel(\"\") = %d, %v", level, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("ParseLevel(loud) should fail")
+	}
+}
+
+func TestSearchLogMinLevel(t *testing.T) {
+	rules := []Rule{
+		{Pattern: "timeout", Regex: mustCompile(t, "timeout"), MinLevel: LevelError},
+	}
+	if match, _ := SearchLog("[WARN] [06-04|12:00:00.000] timeout", rules); match {
+		t.Error("WARN line should be filtered by an ERROR minimum")
+	}
+	if match, _ := SearchLog("[EROR] [06-04|12:00:00.000] timeout", rules); !match {
+		t.Error("EROR line should match")
+	}
+	if match, _ := SearchLog("timeout without a level", rules); !match {
+		t.Error("lines without a level should not be filtered")
+	}
+}
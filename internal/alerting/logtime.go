@@ -0,0 +1,39 @@
+package alerting
+
+import (
+	"regexp"
+	"time"
+)
+
+var (
+	isoTimeRegex    = regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`)
+	erigonTimeRegex = regexp.MustCompile(`(\d{2}-\d{2})\|(\d{2}:\d{2}:\d{2}(\.\d+)?)`)
+)
+
+var isoTimeLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// ParseLogTime extracts the timestamp of a log line. Erigon timestamps
+// (01-02|15:04:05.000) carry no year or zone, so those are taken from ref.
+func ParseLogTime(log string, ref time.Time) (time.Time, bool) {
+	if m := isoTimeRegex.FindString(log); m != "" {
+		if m[10] == ' ' {
+			m = m[:10] + "T" + m[11:]
+		}
+		for _, layout := range isoTimeLayouts {
+			if t, err := time.ParseInLocation(layout, m, ref.Location()); err == nil {
+				return t, true
+			}
+		}
+	}
+	if m := erigonTimeRegex.FindStringSubmatch(log); m != nil {
+		t, err := time.ParseInLocation("01-02 15:04:05.999999999", m[1]+" "+m[2], ref.Location())
+		if err == nil {
+			return t.AddDate(ref.Year(), 0, 0), true
+		}
+	}
+	return time.Time{}, false
+}
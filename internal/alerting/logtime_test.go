@@ -0,0 +1,45 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogTime(t *testing.T) {
+	ref := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		log  string
+		want time.Time
+		ok   bool
+	}{
+		{
+			log:  "[EROR] [06-04|12:34:56.789] bad batch",
+			want: time.Date(2024, 6, 4, 12, 34, 56, 789000000, time.UTC),
+			ok:   true,
+		},
+		{
+			log:  "2024-06-04T12:34:56Z failed",
+			want: time.Date(2024, 6, 4, 12, 34, 56, 0, time.UTC),
+			ok:   true,
+		},
+		{
+			log:  "2024-06-04 12:34:56.5+02:00 failed",
+			want: time.Date(2024, 6, 4, 10, 34, 56, 500000000, time.UTC),
+			ok:   true,
+		},
+		{
+			log:  "2024-06-04T12:34:56 no zone",
+			want: time.Date(2024, 6, 4, 12, 34, 56, 0, time.UTC),
+			ok:   true,
+		},
+		{
+			log: "no timestamp here",
+		},
+	}
+	for _, tt := range tests {
+		got, ok := ParseLogTime(tt.log, ref)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("ParseLogTime(%q) = %v, %v, want %v, %v", tt.log, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package alerting
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type AlertManager struct {
+	sentAlerts        map[string]time.Time
+	suppressionCounts map[string]int
+	keyPatterns       map[string]string
+	silencedUntil     map[string]time.Time
+	events            map[string]bool
+	now               func() time.Time
+	store             CooldownStore
+	mu                sync.Mutex
+	defaultCooldown   time.Duration
+	patternCooldowns  map[string]time.Duration
+}
+
+func NewAlertManager(defaultCooldown time.Duration, patternCooldowns map[string]time.Duration) *AlertManager {
+	return &AlertManager{
+		sentAlerts:        make(map[string]time.Time),
+		suppressionCounts: make(map[string]int),
+		keyPatterns:       make(map[string]string),
+		silencedUntil:     make(map[string]time.Time),
+		events:            make(map[string]bool),
+		now:               time.Now,
+		defaultCooldown:   defaultCooldown,
+		patternCooldowns:  patternCooldowns,
+	}
+}
+
+func (am *AlertManager) cooldown(pattern string) time.Duration {
+	cooldown, exists := am.patternCooldowns[pattern]
+	if !exists {
+		cooldown = am.defaultCooldown
+	}
+	return cooldown
+}
+
+// ShouldSendAlert reports whether an alert for pattern should be sent. Cooldowns
+// are looked up by pattern but tracked per key, so a pattern can be deduplicated
+// either as a whole (key == pattern) or per line fingerprint.
+func (am *AlertManager) ShouldSendAlert(pattern, key string) (bool, int) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	am.keyPatterns[key] = pattern
+	if suppressed, count := am.suppress(pattern, key); suppressed {
+		return false, count
+	}
+
+	if am.store != nil {
+		// The shared store is a network round trip, which must not hold up
+		// the lines of every other pattern.
+		cooldown := am.cooldown(pattern)
+		am.mu.Unlock()
+		claimed, err := am.store.Claim(key, cooldown)
+		am.mu.Lock()
+		if err != nil {
+			// Rather alert twice than not at all.
+			fmt.Fprintf(os.Stderr, "Error checking shared cooldown: %v\n", err)
+		} else if !claimed {
+			am.suppressionCounts[key]++
+			return false, am.suppressionCounts[key]
+		}
+		// Another line of key may have been alerted on meanwhile.
+		if suppressed, count := am.suppress(pattern, key); suppressed {
+			return false, count
+		}
+	}
+
+	suppressionCount := am.suppressionCounts[key]
+	am.sentAlerts[key] = am.now()
+	am.suppressionCounts[key] = 0
+	return true, suppressionCount
+}
+
+// suppress counts and reports a suppression of key when pattern is silenced
+// or key is in its cooldown. It must be called with am.mu held.
+func (am *AlertManager) suppress(pattern, key string) (bool, int) {
+	now := am.now()
+	if until, silenced := am.silencedUntil[pattern]; silenced {
+		if now.Before(until) {
+			am.suppressionCounts[key]++
+			return true, am.suppressionCounts[key]
+		}
+		delete(am.silencedUntil, pattern)
+	}
+	if lastSent, exists := am.sentAlerts[key]; exists && now.Sub(lastSent) < am.cooldown(pattern) {
+		am.suppressionCounts[key]++
+		return true, am.suppressionCounts[key]
+	}
+	return false, 0
+}
+
+// ShouldSendEvent reports whether an event alert should be sent. Events aren't
+// subject to cooldowns but can be silenced like patterns once they occurred.
+func (am *AlertManager) ShouldSendEvent(name string) bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.events[name] = true
+	until, silenced := am.silencedUntil[name]
+	return !silenced || !am.now().Before(until)
+}
+
+func (am *AlertManager) GetSuppressionCount(key string) int {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return am.suppressionCounts[key]
+}
+
+// CooldownState describes a key that is currently suppressing alerts.
+type CooldownState struct {
+	Pattern    string    `json:"pattern"`
+	Key        string    `json:"key"`
+	LastSent   time.Time `json:"lastSent"`
+	Until      time.Time `json:"until"`
+	Suppressed int       `json:"suppressed"`
+}
+
+// Cooldowns lists the keys whose cooldown hasn't expired yet.
+func (am *AlertManager) Cooldowns() []CooldownState {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	now := am.now()
+	var states []CooldownState
+	for key, lastSent := range am.sentAlerts {
+		pattern := am.keyPatterns[key]
+		until := lastSent.Add(am.cooldown(pattern))
+		if now.After(until) {
+			continue
+		}
+		states = append(states, CooldownState{
+			Pattern:    pattern,
+			Key:        key,
+			LastSent:   lastSent,
+			Until:      until,
+			Suppressed: am.suppressionCounts[key],
+		})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Key < states[j].Key })
+	return states
+}
+
+// ResetCooldown forgets every key of pattern so the next match alerts
+// immediately. It returns the number of keys that were reset.
+func (am *AlertManager) ResetCooldown(pattern string) int {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	reset := 0
+	for key, keyPattern := range am.keyPatterns {
+		if keyPattern != pattern {
+			continue
+		}
+		delete(am.sentAlerts, key)
+		delete(am.suppressionCounts, key)
+		delete(am.keyPatterns, key)
+		if am.store != nil {
+			if err := am.store.Release(key); err != nil {
+				fmt.Fprintf(os.Stderr, "Error resetting shared cooldown: %v\n", err)
+			}
+		}
+		reset++
+	}
+	return reset
+}
+
+// Silence suppresses every alert for pattern for d, regardless of cooldowns.
+func (am *AlertManager) Silence(pattern string, d time.Duration) time.Time {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	until := am.now().Add(d)
+	am.silencedUntil[pattern] = until
+	return until
+}
+
+func (am *AlertManager) Unsilence(pattern string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	delete(am.silencedUntil, pattern)
+}
+
+// Silences returns the patterns that are currently silenced and until when.
+func (am *AlertManager) Silences() map[string]time.Time {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	now := am.now()
+	silences := make(map[string]time.Time)
+	for pattern, until := range am.silencedUntil {
+		if now.Before(until) {
+			silences[pattern] = until
+		}
+	}
+	return silences
+}
+
+// KnowsPattern reports whether pattern has a configured cooldown or is an
+// event that occurred.
+func (am *AlertManager) KnowsPattern(pattern string) bool {
+	if _, exists := am.patternCooldowns[pattern]; exists {
+		return true
+	}
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return am.events[pattern]
+}
+
+// DedupKey returns the key cooldowns are tracked under for a match of pattern.
+func DedupKey(pattern, log string, byFingerprint bool) string {
+	if !byFingerprint {
+		return pattern
+	}
+	return pattern + "|" + Fingerprint(log)
+}
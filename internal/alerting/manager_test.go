@@ -0,0 +1,181 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertManagerSuppressesWithinCooldown(t *testing.T) {
+	am := NewAlertManager(time.Hour, nil)
+
+	if send, count := am.ShouldSendAlert("p", "p"); !send || count != 0 {
+		t.Fatalf("first alert: got send=%v count=%d, want true 0", send, count)
+	}
+	for i := 1; i <= 3; i++ {
+		if send, count := am.ShouldSendAlert("p", "p"); send || count != i {
+			t.Fatalf("duplicate %d: got send=%v count=%d, want false %d", i, send, count, i)
+		}
+	}
+	if got := am.GetSuppressionCount("p"); got != 3 {
+		t.Fatalf("GetSuppressionCount = %d, want 3", got)
+	}
+}
+
+func TestAlertManagerPatternCooldownOverridesDefault(t *testing.T) {
+	am := NewAlertManager(time.Hour, map[string]time.Duration{"p": 0})
+
+	am.ShouldSendAlert("p", "p")
+	if send, count := am.ShouldSendAlert("p", "p"); !send || count != 0 {
+		t.Fatalf("zero cooldown: got send=%v count=%d, want true 0", send, count)
+	}
+}
+
+func TestAlertManagerTracksKeysSeparately(t *testing.T) {
+	am := NewAlertManager(time.Hour, nil)
+
+	am.ShouldSendAlert("p", "p|a")
+	if send, _ := am.ShouldSendAlert("p", "p|b"); !send {
+		t.Fatal("a different key of the same pattern should not be suppressed")
+	}
+	if send, _ := am.ShouldSendAlert("p", "p|a"); send {
+		t.Fatal("a repeated key should be suppressed")
+	}
+}
+
+func TestDedupKey(t *testing.T) {
+	if got := DedupKey("p", "block 1", false); got != "p" {
+		t.Errorf("DedupKey without fingerprint = %q, want %q", got, "p")
+	}
+	a := DedupKey("p", "bad block 100 hash=0xabc", true)
+	b := DedupKey("p", "bad block 200 hash=0xdef", true)
+	if a != b {
+		t.Errorf("fingerprint keys differ: %q vs %q", a, b)
+	}
+}
+
+func TestAlertManagerSilence(t *testing.T) {
+	am := NewAlertManager(0, map[string]time.Duration{"p": 0})
+
+	am.Silence("p", time.Hour)
+	if send, count := am.ShouldSendAlert("p", "p"); send || count != 1 {
+		t.Fatalf("silenced alert: got send=%v count=%d, want false 1", send, count)
+	}
+	if _, silenced := am.Silences()["p"]; !silenced {
+		t.Error("Silences should list p")
+	}
+
+	am.Unsilence("p")
+	if send, count := am.ShouldSendAlert("p", "p"); !send || count != 1 {
+		t.Fatalf("after unsilence: got send=%v count=%d, want true 1", send, count)
+	}
+}
+
+func TestAlertManagerResetCooldown(t *testing.T) {
+	am := NewAlertManager(time.Hour, map[string]time.Duration{"p": time.Hour, "q": time.Hour})
+
+	am.ShouldSendAlert("p", "p|a")
+	am.ShouldSendAlert("p", "p|b")
+	am.ShouldSendAlert("q", "q")
+	if got := len(am.Cooldowns()); got != 3 {
+		t.Fatalf("Cooldowns has %d entries, want 3", got)
+	}
+
+	if reset := am.ResetCooldown("p"); reset != 2 {
+		t.Errorf("ResetCooldown reset %d keys, want 2", reset)
+	}
+	if send, _ := am.ShouldSendAlert("p", "p|a"); !send {
+		t.Error("reset pattern should alert again")
+	}
+	if send, _ := am.ShouldSendAlert("q", "q"); send {
+		t.Error("other patterns should stay in cooldown")
+	}
+}
+
+// memoryStore is a CooldownStore shared by managers in the same test.
+type memoryStore struct {
+	claimed map[string]bool
+}
+
+func (s *memoryStore) Claim(key string, cooldown time.Duration) (bool, error) {
+	if s.claimed[key] {
+		return false, nil
+	}
+	s.claimed[key] = true
+	return true, nil
+}
+
+func (s *memoryStore) Release(key string) error {
+	delete(s.claimed, key)
+	return nil
+}
+
+func TestAlertManagerSharedStore(t *testing.T) {
+	store := &memoryStore{claimed: make(map[string]bool)}
+	host1 := NewAlertManager(time.Hour, nil)
+	host1.store = store
+	host2 := NewAlertManager(time.Hour, nil)
+	host2.store = store
+
+	if send, _ := host1.ShouldSendAlert("p", "p"); !send {
+		t.Fatal("first host should alert")
+	}
+	if send, count := host2.ShouldSendAlert("p", "p"); send || count != 1 {
+		t.Fatalf("second host: got send=%v count=%d, want false 1", send, count)
+	}
+
+	host1.ResetCooldown("p")
+	if send, count := host2.ShouldSendAlert("p", "p"); !send || count != 1 {
+		t.Fatalf("after reset: got send=%v count=%d, want true 1", send, count)
+	}
+}
+
+// blockingStore is a CooldownStore whose claims wait for release.
+type blockingStore struct {
+	claiming chan string
+	release  chan struct{}
+}
+
+func (s *blockingStore) Claim(key string, cooldown time.Duration) (bool, error) {
+	s.claiming <- key
+	<-s.release
+	return true, nil
+}
+
+func (s *blockingStore) Release(key string) error {
+	return nil
+}
+
+func TestAlertManagerClaimDoesNotBlock(t *testing.T) {
+	store := &blockingStore{claiming: make(chan string, 2), release: make(chan struct{})}
+	am := NewAlertManager(time.Hour, nil)
+	am.store = store
+
+	sent := make(chan bool, 2)
+	go func() {
+		send, _ := am.ShouldSendAlert("p", "p")
+		sent <- send
+	}()
+	<-store.claiming
+
+	// A slow claim leaves the other patterns, and the cooldowns, usable.
+	done := make(chan struct{})
+	go func() {
+		am.Cooldowns()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Cooldowns blocked on a pending claim")
+	}
+
+	go func() {
+		send, _ := am.ShouldSendAlert("p", "p")
+		sent <- send
+	}()
+	<-store.claiming
+	close(store.release)
+	if first, second := <-sent, <-sent; first == second {
+		t.Errorf("concurrent claims of the same key sent %v and %v, want exactly one alert", first, second)
+	}
+}
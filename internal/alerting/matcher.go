@@ -0,0 +1,199 @@
+package alerting
+
+import (
+	"regexp/syntax"
+	"strings"
+)
+
+// Matcher finds the first rule matching a line, like SearchLog, but scales to
+// large rule sets. For every rule it derives literals of which any match must
+// contain at least one, finds all of them in a single pass over the line, and
+// only runs the regexes of rules whose literals occurred. Rules without such a
+// literal always run.
+type Matcher struct {
+	rules      []Rule
+	always     []uint64
+	literals   *literalSet
+	levelRules bool
+}
+
+func NewMatcher(rules []Rule) *Matcher {
+	m := &Matcher{
+		rules:    rules,
+		always:   make([]uint64, (len(rules)+63)/64),
+		literals: newLiteralSet(),
+	}
+	for i, rule := range rules {
+		if rule.MinLevel != LevelUnknown {
+			m.levelRules = true
+		}
+		literals := requiredLiterals(rule.Regex.String())
+		if literals == nil {
+			m.always[i/64] |= 1 << (i % 64)
+			continue
+		}
+		for _, literal := range literals {
+			m.literals.add(literal, i)
+		}
+	}
+	m.literals.build()
+	return m
+}
+
+// Match returns the first rule matching log.
+func (m *Matcher) Match(log string) (bool, string) {
+	candidates := make([]uint64, len(m.always))
+	copy(candidates, m.always)
+	if !m.literals.scan(log, candidates) {
+		// The prefilter folds ASCII only, so lines with other characters
+		// could match case-insensitive rules it missed.
+		for i := range candidates {
+			candidates[i] = ^uint64(0)
+		}
+	}
+
+	level := LevelUnknown
+	if m.levelRules {
+		level = ExtractLevel(log)
+	}
+	line := &lazyLine{log: log}
+	for i, rule := range m.rules {
+		if candidates[i/64]&(1<<(i%64)) == 0 {
+			continue
+		}
+		if level != LevelUnknown && level < rule.MinLevel {
+			continue
+		}
+		if rule.Regex.MatchString(log) && rule.matchesLine(line) {
+			return true, rule.Pattern
+		}
+	}
+	return false, ""
+}
+
+// requiredLiterals returns lowercased literals of which every match of expr
+// contains at least one, or nil if there are none to filter on.
+func requiredLiterals(expr string) []string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	return literalsOf(re.Simplify())
+}
+
+func literalsOf(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{strings.ToLower(string(re.Rune))}
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalsOf(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return literalsOf(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Any sub-expression's literals will do; prefer the most selective.
+		var best []string
+		for _, sub := range re.Sub {
+			if literals := literalsOf(sub); literals != nil && shortest(literals) > shortest(best) {
+				best = literals
+			}
+		}
+		return best
+	case syntax.OpAlternate:
+		var all []string
+		for _, sub := range re.Sub {
+			literals := literalsOf(sub)
+			if literals == nil {
+				return nil
+			}
+			all = append(all, literals...)
+		}
+		return all
+	}
+	return nil
+}
+
+func shortest(literals []string) int {
+	if len(literals) == 0 {
+		return 0
+	}
+	n := len(literals[0])
+	for _, literal := range literals[1:] {
+		if len(literal) < n {
+			n = len(literal)
+		}
+	}
+	return n
+}
+
+// literalSet is an Aho-Corasick automaton over ASCII-lowercased bytes that
+// marks the rules whose literals occur in a line.
+type literalSet struct {
+	next    [][256]int32
+	outputs [][]int
+}
+
+func newLiteralSet() *literalSet {
+	return &literalSet{next: make([][256]int32, 1), outputs: make([][]int, 1)}
+}
+
+func (s *literalSet) add(literal string, rule int) {
+	state := int32(0)
+	for i := 0; i < len(literal); i++ {
+		b := literal[i]
+		if s.next[state][b] == 0 {
+			s.next = append(s.next, [256]int32{})
+			s.outputs = append(s.outputs, nil)
+			s.next[state][b] = int32(len(s.next) - 1)
+		}
+		state = s.next[state][b]
+	}
+	s.outputs[state] = append(s.outputs[state], rule)
+}
+
+// build turns the trie into a DFA by filling in failure transitions, breadth
+// first so every state's failure state is complete before it is used.
+func (s *literalSet) build() {
+	fail := make([]int32, len(s.next))
+	var queue []int32
+	for b := 0; b < 256; b++ {
+		if child := s.next[0][b]; child != 0 {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		s.outputs[state] = append(s.outputs[state], s.outputs[fail[state]]...)
+		for b := 0; b < 256; b++ {
+			child := s.next[state][b]
+			if child == 0 {
+				s.next[state][b] = s.next[fail[state]][b]
+				continue
+			}
+			fail[child] = s.next[fail[state]][b]
+			queue = append(queue, child)
+		}
+	}
+}
+
+// scan marks the rules whose literals occur in log. It reports false if log
+// contains non-ASCII bytes, in which case the result is incomplete.
+func (s *literalSet) scan(log string, candidates []uint64) bool {
+	state := int32(0)
+	for i := 0; i < len(log); i++ {
+		b := log[i]
+		if b >= 0x80 {
+			return false
+		}
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		state = s.next[state][b]
+		for _, rule := range s.outputs[state] {
+			candidates[rule/64] |= 1 << (rule % 64)
+		}
+	}
+	return true
+}
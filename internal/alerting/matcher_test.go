@@ -0,0 +1,92 @@
+package alerting
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{`bad batch \d+`, []string{"bad batch "}},
+		{`(?i)Timeout`, []string{"timeout"}},
+		{`^\d+ (failed|panic): .*unwind`, []string{"unwind"}},
+		{`peer (dropped|lost)`, []string{"peer "}},
+		{`(dropped|lost)`, []string{"dropped", "lost"}},
+		{`(dropped|\d+)`, nil},
+		{`\d+`, nil},
+		{`x*`, nil},
+	}
+	for _, tt := range tests {
+		if got := requiredLiterals(tt.expr); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("requiredLiterals(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatcherAgreesWithSearchLog(t *testing.T) {
+	var rules []Rule
+	for _, pattern := range []string{
+		`bad batch %{INT}`,
+		`(?i)TIMEOUT`,
+		`\d{3} retries`,
+		`(panic|fatal): %{WORD}`,
+		`^\[EROR\]`,
+		`stage (headers|bodies) stalled`,
+	} {
+		rules = append(rules, Rule{Pattern: pattern, Regex: mustCompile(t, pattern)})
+	}
+	rules = append(rules, Rule{Pattern: "sync", Regex: mustCompile(t, "sync"), MinLevel: LevelError})
+
+	for _, log := range []string{
+		"[EROR] bad batch 12",
+		"request Timeout after 5s",
+		"request tImEoUt after 5s",
+		"gave up after 100 retries",
+		"panic: unreachable",
+		"FATAL: nope",
+		"[EROR] something else",
+		"stage bodies stalled",
+		"stage senders stalled",
+		"[INFO] sync progress",
+		"[EROR] sync failed",
+		"TİMEOUT with a non-ASCII letter",
+		"request Kelvin timeout",
+		"nothing to see",
+	} {
+		wantMatch, wantPattern := SearchLog(log, rules)
+		gotMatch, gotPattern := NewMatcher(rules).Match(log)
+		if gotMatch != wantMatch || gotPattern != wantPattern {
+			t.Errorf("Match(%q) = %v, %q, want %v, %q", log, gotMatch, gotPattern, wantMatch, wantPattern)
+		}
+	}
+}
+
+func benchmarkRules(n int) []Rule {
+	rules := make([]Rule, n)
+	for i := range rules {
+		pattern := fmt.Sprintf(`component%d (failed|errored) with code \d+`, i)
+		rules[i] = Rule{Pattern: pattern, Regex: regexp.MustCompile(pattern)}
+	}
+	return rules
+}
+
+var benchmarkLine = "[INFO] [06-04|12:34:56.789] [5/15 Execution] Executed blocks number=1234567 blk/s=250.1 tx/s=3000.5 Mgas/s=120.3"
+
+func BenchmarkSearchLog(b *testing.B) {
+	rules := benchmarkRules(200)
+	for i := 0; i < b.N; i++ {
+		SearchLog(benchmarkLine, rules)
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	m := NewMatcher(benchmarkRules(200))
+	for i := 0; i < b.N; i++ {
+		m.Match(benchmarkLine)
+	}
+}
@@ -0,0 +1,473 @@
+package alerting
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// neverRepeat is the cooldown for patterns without a timeout: alert once.
+const neverRepeat = 24 * time.Hour * 365 * 100
+
+// Streams a line can be read from, for inputs that tell them apart.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// StderrPattern is the pattern of alerts on stderr lines no pattern matched,
+// raised when alertOnStderr is set. Without a logLevelThreshold it takes
+// lines at ERROR and above, or without a level.
+const StderrPattern = "stderr"
+
+// Options holds the per-process settings that don't come from the config file.
+type Options struct {
+	Hostname string
+	Prefix   string
+	Service  string
+	Emitter  *JSONEmitter
+
+	// DryRun logs alerts to stderr instead of sending them to the webhook
+	// and running exec actions; they still reach the emitter and OnAlert.
+	DryRun bool
+	// OnAlert, when set, is called with every alert that fires.
+	OnAlert func(Alert)
+	// Clock replaces the wall clock for cooldowns and batch windows, e.g.
+	// with timestamps taken from replayed log lines.
+	Clock func() time.Time
+}
+
+// Pipeline runs log lines through matching, sampling, batching and
+// suppression, and sends the resulting alerts to the configured sinks.
+type Pipeline struct {
+	config       *Config
+	opts         Options
+	client       *http.Client
+	matcher      *Matcher
+	patterns     map[string]PatternConfig
+	regexes      map[string]*regexp.Regexp
+	manager      *AlertManager
+	sampler      *MatchSampler
+	batcher      *AlertBatcher
+	metadata     map[string]string
+	metadataMu   sync.RWMutex
+	logFile      io.Writer
+	patternFiles map[string]io.Writer
+	files        []*RotatingFile
+	actions      sync.WaitGroup
+	control      *http.Server
+	history      *History
+	shared       *RedisStore
+	thresholds   []threshold
+
+	globalMinLevel Level
+}
+
+func NewPipeline(config *Config, opts Options) (*Pipeline, error) {
+	client, err := NewHTTPClient(config.HTTPClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure HTTP client: %w", err)
+	}
+
+	globalMinLevel, err := ParseLevel(config.LogLevelThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logLevelThreshold: %w", err)
+	}
+
+	p := &Pipeline{
+		config:       config,
+		opts:         opts,
+		client:       client,
+		patterns:     make(map[string]PatternConfig),
+		regexes:      make(map[string]*regexp.Regexp),
+		metadata:     CollectMetadata(config.Enrichment),
+		patternFiles: make(map[string]io.Writer),
+	}
+
+	rules := make([]Rule, len(config.Patterns))
+	patternCooldowns := make(map[string]time.Duration)
+	sampleRates := make(map[string]int)
+	for i, patternConfig := range config.Patterns {
+		minLevel, err := ParseLevel(patternConfig.MinLevel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minLevel for pattern %s: %w", patternConfig.Pattern, err)
+		}
+		if minLevel == LevelUnknown {
+			minLevel = globalMinLevel
+		}
+		regex, err := CompilePattern(patternConfig, config.GrokPatterns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pattern %s: %w", patternConfig.Pattern, err)
+		}
+		module, fields, err := compileFieldFilters(patternConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pattern %s: %w", patternConfig.Pattern, err)
+		}
+		if err := ValidateActions(patternConfig.Actions); err != nil {
+			return nil, fmt.Errorf("invalid actions for pattern %s: %w", patternConfig.Pattern, err)
+		}
+		if patternConfig.SampleRate < 0 {
+			return nil, fmt.Errorf("invalid sampleRate for pattern %s: must not be negative", patternConfig.Pattern)
+		}
+		rules[i] = Rule{
+			Pattern:  patternConfig.Pattern,
+			Regex:    regex,
+			MinLevel: minLevel,
+			Module:   module,
+			Fields:   fields,
+		}
+		p.regexes[patternConfig.Pattern] = regex
+		sampleRates[patternConfig.Pattern] = patternConfig.SampleRate
+		if patternConfig.Severity == "" {
+			patternConfig.Severity = DefaultSeverity
+		}
+		p.patterns[patternConfig.Pattern] = patternConfig
+		if patternConfig.TimeoutMinutes == 0 {
+			patternCooldowns[patternConfig.Pattern] = neverRepeat
+		} else {
+			patternCooldowns[patternConfig.Pattern] = time.Duration(patternConfig.TimeoutMinutes) * time.Minute
+		}
+	}
+
+	for _, tc := range config.Thresholds {
+		t, err := compileThreshold(tc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold on %s: %w", tc.Field, err)
+		}
+		if t.Severity == "" {
+			t.Severity = DefaultSeverity
+		}
+		p.thresholds = append(p.thresholds, t)
+		p.patterns[t.name] = PatternConfig{Pattern: t.name, Severity: t.Severity}
+		patternCooldowns[t.name] = t.cooldown()
+	}
+
+	p.matcher = NewMatcher(rules)
+	if config.AlertOnStderr {
+		// erigon logs everything to stderr, so without a threshold only
+		// errors are alerted on, and like the patterns without a timeout
+		// only once.
+		p.globalMinLevel = globalMinLevel
+		if p.globalMinLevel == LevelUnknown {
+			p.globalMinLevel = LevelError
+		}
+		p.patterns[StderrPattern] = PatternConfig{Pattern: StderrPattern, Severity: DefaultSeverity}
+		patternCooldowns[StderrPattern] = neverRepeat
+		if config.DefaultTimeoutMinutes > 0 {
+			patternCooldowns[StderrPattern] = time.Duration(config.DefaultTimeoutMinutes) * time.Minute
+		}
+	}
+
+	defaultCooldown := time.Duration(config.DefaultTimeoutMinutes) * time.Minute
+	p.manager = NewAlertManager(defaultCooldown, patternCooldowns)
+	p.sampler = NewMatchSampler(sampleRates)
+	if config.BatchWindowSeconds > 0 {
+		p.batcher = NewAlertBatcher(time.Duration(config.BatchWindowSeconds)*time.Second, p.alert)
+		p.batcher.clock = opts.Clock
+	}
+	if opts.Clock != nil {
+		p.manager.now = opts.Clock
+	}
+	// A dry run must not claim cooldowns the live hosts would respect.
+	if config.SharedState.RedisAddr != "" && !opts.DryRun {
+		p.shared, err = NewRedisStore(config.SharedState, opts.Service, opts.Hostname)
+		if err != nil {
+			return nil, err
+		}
+		p.manager.store = p.shared
+	}
+
+	if config.LogFile != "" {
+		rf, err := NewRotatingFile(config.LogFile, config.LogRotation)
+		if err != nil {
+			p.closeResources()
+			return nil, err
+		}
+		p.files = append(p.files, rf)
+		p.logFile = rf
+	}
+
+	// Patterns sharing an output file share a single writer.
+	openFiles := make(map[string]*RotatingFile)
+	for _, patternConfig := range config.Patterns {
+		if patternConfig.OutputFile == "" {
+			continue
+		}
+		rf, exists := openFiles[patternConfig.OutputFile]
+		if !exists {
+			rf, err = NewRotatingFile(patternConfig.OutputFile, config.LogRotation)
+			if err != nil {
+				p.closeResources()
+				return nil, fmt.Errorf("failed to open output file for pattern %s: %w", patternConfig.Pattern, err)
+			}
+			p.files = append(p.files, rf)
+			openFiles[patternConfig.OutputFile] = rf
+		}
+		p.patternFiles[patternConfig.Pattern] = rf
+	}
+
+	if config.HistoryFile != "" {
+		p.history, err = NewHistory(config.HistoryFile)
+		if err != nil {
+			p.closeResources()
+			return nil, err
+		}
+	}
+
+	if config.ControlAddr != "" {
+		if err := checkControlAddr(config.ControlAddr, config.ControlToken); err != nil {
+			p.closeResources()
+			return nil, err
+		}
+		listener, err := net.Listen("tcp", config.ControlAddr)
+		if err != nil {
+			p.closeResources()
+			return nil, fmt.Errorf("failed to start control API: %w", err)
+		}
+		ackSilence := DefaultAckSilence
+		if config.AckSilenceMinutes > 0 {
+			ackSilence = time.Duration(config.AckSilenceMinutes) * time.Minute
+		}
+		p.control = &http.Server{Handler: ControlHandler(p.manager, p.history, ackSilence, config.ControlToken)}
+		go func() {
+			if err := p.control.Serve(listener); err != nil && err != http.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "Control API stopped: %v\n", err)
+			}
+		}()
+	}
+
+	return p, nil
+}
+
+// Process logs a single line and alerts on it if it matches a pattern.
+func (p *Pipeline) Process(log string) {
+	p.ProcessStream(log, "")
+}
+
+// ProcessStream is Process for a line read from stream, which tags the line in
+// the log files and the alerts it raises. It reports whether the line matched,
+// even if sampling or suppression kept it from alerting.
+func (p *Pipeline) ProcessStream(log, stream string) bool {
+	if p.batcher != nil && p.opts.Clock != nil {
+		p.batcher.flushExpired(p.opts.Clock())
+	}
+	prefix := p.opts.Prefix
+	if stream != "" {
+		prefix += " [" + stream + "]"
+	}
+	LogToFile(p.logFile, log, prefix)
+	if len(p.thresholds) > 0 {
+		p.checkThresholds(log, stream)
+	}
+	match, pattern := p.matcher.Match(log)
+	if !match && stream == StreamStderr && p.config.AlertOnStderr {
+		// Lines below the level threshold stay quiet, as erigon logs
+		// everything to stderr.
+		level := ExtractLevel(log)
+		match = level == LevelUnknown || level >= p.globalMinLevel
+		pattern = StderrPattern
+	}
+	if !match {
+		return false
+	}
+	LogToFile(p.patternFiles[pattern], log, prefix)
+	if !p.sampler.Sample(pattern) {
+		return true
+	}
+	if p.batcher != nil {
+		p.batcher.Add(pattern, stream, log)
+	} else {
+		p.alert(pattern, stream, []string{log})
+	}
+	return true
+}
+
+func (p *Pipeline) alert(pattern, stream string, logs []string) {
+	key := DedupKey(pattern, logs[0], p.config.DedupByFingerprint)
+	shouldSend, suppressionCount := p.manager.ShouldSendAlert(pattern, key)
+	if !shouldSend {
+		return
+	}
+
+	patternConfig := p.patterns[pattern]
+	a := Alert{
+		Time:             p.manager.now().UTC(),
+		Hostname:         p.opts.Hostname,
+		Prefix:           p.opts.Prefix,
+		Service:          p.opts.Service,
+		Pattern:          pattern,
+		Stream:           stream,
+		Severity:         patternConfig.Severity,
+		Log:              CombineLogs(logs),
+		RunbookURL:       patternConfig.RunbookURL,
+		SuppressionCount: suppressionCount,
+		TotalMatches:     p.sampler.Count(pattern),
+		Metadata:         p.currentMetadata(),
+	}
+	if line, ok := ParseLogLine(logs[0]); ok {
+		a.Module = line.Module
+		a.Fields = line.Fields
+	}
+	if p.config.ThreadByPattern {
+		a.ThreadKey = p.threadKey(pattern)
+	}
+	if p.config.AckURL != "" {
+		a.AckURL = AckLink(p.config.AckURL, pattern)
+	}
+	p.deliver(a, patternConfig.WebhookURL)
+
+	if p.opts.DryRun || len(patternConfig.Actions) == 0 {
+		return
+	}
+	captures := RegexCaptures(p.regexes[pattern], logs[0])
+	for _, action := range patternConfig.Actions {
+		p.actions.Add(1)
+		go func(action ActionConfig) {
+			defer p.actions.Done()
+			RunExecAction(action, a, captures)
+		}(action)
+	}
+}
+
+// Event alerts on something that isn't a log line, such as the supervised
+// process restarting. Events skip matching and cooldowns but can be silenced.
+// The events section of the config can override severity.
+func (p *Pipeline) Event(name, severity, message string) {
+	if !p.manager.ShouldSendEvent(name) {
+		return
+	}
+	event := p.config.Events[name]
+	if event.Severity != "" {
+		severity = event.Severity
+	}
+	a := Alert{
+		Time:     p.manager.now().UTC(),
+		Hostname: p.opts.Hostname,
+		Prefix:   p.opts.Prefix,
+		Service:  p.opts.Service,
+		Pattern:  name,
+		Severity: severity,
+		Log:      message,
+		Metadata: p.currentMetadata(),
+	}
+	if p.config.ThreadByPattern {
+		a.ThreadKey = p.threadKey(name)
+	}
+	if p.config.AckURL != "" {
+		a.AckURL = AckLink(p.config.AckURL, name)
+	}
+	p.deliver(a, event.WebhookURL)
+}
+
+// SetMetadata attaches key with value to every following alert, or stops
+// attaching key if value is empty, e.g. to report the version of a process
+// that changes while the pipeline runs.
+func (p *Pipeline) SetMetadata(key, value string) {
+	p.metadataMu.Lock()
+	defer p.metadataMu.Unlock()
+	// Sent alerts keep referencing the old map, so it is replaced instead of
+	// modified.
+	metadata := make(map[string]string, len(p.metadata)+1)
+	for k, v := range p.metadata {
+		metadata[k] = v
+	}
+	if value == "" {
+		delete(metadata, key)
+	} else {
+		metadata[key] = value
+	}
+	p.metadata = metadata
+}
+
+func (p *Pipeline) currentMetadata() map[string]string {
+	p.metadataMu.RLock()
+	defer p.metadataMu.RUnlock()
+	return p.metadata
+}
+
+func (p *Pipeline) threadKey(pattern string) string {
+	if p.opts.Service != "" {
+		pattern = p.opts.Service + "|" + pattern
+	}
+	return ThreadKey(pattern)
+}
+
+// deliver sends a to the webhook, history and emitter. The webhook is
+// webhookURL if set, else the one of a's severity or the config's.
+func (p *Pipeline) deliver(a Alert, webhookURL string) {
+	if webhookURL == "" {
+		webhookURL = p.config.SeverityWebhooks[a.Severity]
+	}
+	if webhookURL == "" {
+		webhookURL = p.config.WebhookURL
+	}
+	if p.opts.DryRun {
+		p.logDryRun(a)
+	} else {
+		SendGoogleChatAlert(p.client, webhookURL, a)
+	}
+	if p.opts.OnAlert != nil {
+		p.opts.OnAlert(a)
+	}
+	p.history.Record(HistoryEvent{
+		Time:     a.Time,
+		Type:     HistoryAlert,
+		Service:  p.opts.Service,
+		Pattern:  a.Pattern,
+		Hostname: a.Hostname,
+		Severity: a.Severity,
+		Log:      a.Log,
+	})
+	if p.opts.Emitter != nil {
+		p.opts.Emitter.Emit(a)
+	}
+}
+
+func (p *Pipeline) logDryRun(a Alert) {
+	service := ""
+	if a.Service != "" {
+		service = "[" + a.Service + "] "
+	}
+	stream := ""
+	if a.Stream != "" {
+		stream = " on " + a.Stream
+	}
+	fmt.Fprintf(os.Stderr, "%s %sDry run, not sending %s alert for pattern %s%s (suppressed %d, total %d):\n%s\n",
+		a.Time.Format(time.RFC3339), service, a.Severity, a.Pattern, stream, a.SuppressionCount, a.TotalMatches, a.Log)
+	for _, action := range p.patterns[a.Pattern].Actions {
+		fmt.Fprintf(os.Stderr, "%sDry run, not running %s action %q\n", service, action.Type, action.Command)
+	}
+}
+
+// Counts returns the total number of matches per pattern so far.
+func (p *Pipeline) Counts() map[string]int64 {
+	return p.sampler.Counts()
+}
+
+// Close flushes pending batches, waits for running actions and closes the
+// log files.
+func (p *Pipeline) Close() {
+	if p.batcher != nil {
+		p.batcher.Flush()
+	}
+	p.actions.Wait()
+	if p.control != nil {
+		p.control.Close()
+	}
+	p.closeResources()
+}
+
+func (p *Pipeline) closeResources() {
+	for _, rf := range p.files {
+		rf.Close()
+	}
+	p.history.Close()
+	if p.shared != nil {
+		p.shared.Close()
+	}
+}
@@ -0,0 +1,325 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer server.Close()
+
+	var emitted bytes.Buffer
+	config := &Config{
+		WebhookURL: server.URL,
+		Patterns: []PatternConfig{
+			{Pattern: "bad batch %{INT}", TimeoutMinutes: 60},
+			{Pattern: "noisy", SampleRate: 10, TimeoutMinutes: 60},
+		},
+		DedupByFingerprint: true,
+	}
+	p, err := NewPipeline(config, Options{Hostname: "node-1", Emitter: NewJSONEmitter(&emitted)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Process("[EROR] bad batch 1")
+	p.Process("[EROR] bad batch 2")
+	p.Process("[EROR] different bad batch 3")
+	for i := 0; i < 5; i++ {
+		p.Process("noisy")
+	}
+	p.Process("nothing to see")
+	p.Close()
+
+	if got := atomic.LoadInt32(&posts); got != 3 {
+		t.Errorf("sent %d alerts, want 3", got)
+	}
+	counts := p.Counts()
+	if counts["bad batch %{INT}"] != 3 || counts["noisy"] != 5 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	var first Alert
+	if err := json.NewDecoder(&emitted).Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Hostname != "node-1" || first.Severity != DefaultSeverity || first.Log != "[EROR] bad batch 1" {
+		t.Errorf("emitted alert = %+v", first)
+	}
+}
+
+func TestNewPipelineRejectsInvalidConfig(t *testing.T) {
+	invalid := []*Config{
+		{LogLevelThreshold: "loud"},
+		{Patterns: []PatternConfig{{Pattern: "("}}},
+		{Patterns: []PatternConfig{{Pattern: "x", MinLevel: "loud"}}},
+		{Patterns: []PatternConfig{{Pattern: "x", SampleRate: -1}}},
+		{Patterns: []PatternConfig{{Pattern: "x", Module: "("}}},
+		{Patterns: []PatternConfig{{Pattern: "x", Fields: map[string]string{"err": "("}}}},
+		{Patterns: []PatternConfig{{Pattern: "x", Actions: []ActionConfig{{Type: "page"}}}}},
+	}
+	for _, config := range invalid {
+		if _, err := NewPipeline(config, Options{}); err == nil {
+			t.Errorf("NewPipeline(%+v) should fail", config)
+		}
+	}
+}
+
+func TestPipelineDryRunWithClock(t *testing.T) {
+	var posts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&posts, 1)
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+	var alerts []Alert
+	config := &Config{
+		WebhookURL:         server.URL,
+		Patterns:           []PatternConfig{{Pattern: "bad batch", TimeoutMinutes: 10}},
+		BatchWindowSeconds: 30,
+	}
+	p, err := NewPipeline(config, Options{
+		DryRun:  true,
+		Clock:   func() time.Time { return now },
+		OnAlert: func(a Alert) { alerts = append(alerts, a) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Process("bad batch 1")
+	now = now.Add(10 * time.Second)
+	p.Process("bad batch 2")
+	// The window has elapsed in clock time, so the next line flushes the
+	// first batch and starts a new one.
+	now = now.Add(time.Minute)
+	p.Process("bad batch 3")
+	// This batch expires within the cooldown and is suppressed.
+	now = now.Add(time.Minute)
+	p.Process("all good")
+	// Past the cooldown, the final batch is sent on Close.
+	now = now.Add(10 * time.Minute)
+	p.Process("bad batch 4")
+	p.Close()
+
+	if got := atomic.LoadInt32(&posts); got != 0 {
+		t.Errorf("dry run sent %d webhooks", got)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("fired %d alerts, want 2: %+v", len(alerts), alerts)
+	}
+	if alerts[0].Log != "2 matches:\nbad batch 1\nbad batch 2" || !alerts[0].Time.Equal(time.Date(2024, 6, 4, 12, 1, 10, 0, time.UTC)) {
+		t.Errorf("first alert = %+v", alerts[0])
+	}
+	if alerts[1].Log != "bad batch 4" || alerts[1].SuppressionCount != 1 || !alerts[1].Time.Equal(time.Date(2024, 6, 4, 12, 12, 10, 0, time.UTC)) {
+		t.Errorf("second alert = %+v", alerts[1])
+	}
+}
+
+func TestPipelineEvent(t *testing.T) {
+	var alerts []Alert
+	p, err := NewPipeline(&Config{}, Options{
+		DryRun:  true,
+		OnAlert: func(a Alert) { alerts = append(alerts, a) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Event("restart", "WARNING", "restarted once")
+	p.Event("restart", "WARNING", "restarted twice")
+	if len(alerts) != 2 || alerts[1].Pattern != "restart" || alerts[1].Log != "restarted twice" {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+
+	if !p.manager.KnowsPattern("restart") {
+		t.Fatal("events that occurred should be known to the control API")
+	}
+	p.manager.Silence("restart", time.Hour)
+	p.Event("restart", "WARNING", "silenced")
+	if len(alerts) != 2 {
+		t.Errorf("silenced event was sent: %+v", alerts[2])
+	}
+}
+
+func TestPipelineRouting(t *testing.T) {
+	var mu sync.Mutex
+	posts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posts[r.URL.Path]++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	config := &Config{
+		WebhookURL: server.URL + "/default",
+		Patterns: []PatternConfig{
+			{Pattern: "bad batch", Severity: "CRITICAL", WebhookURL: server.URL + "/batches"},
+			{Pattern: "oom", Severity: "CRITICAL"},
+			{Pattern: "slow"},
+		},
+		SeverityWebhooks: map[string]string{"CRITICAL": server.URL + "/pager"},
+		Events: map[string]EventConfig{
+			"restart": {Severity: "INFO", WebhookURL: server.URL + "/events"},
+			"crash":   {Severity: "CRITICAL"},
+		},
+	}
+	var alerts []Alert
+	p, err := NewPipeline(config, Options{OnAlert: func(a Alert) { alerts = append(alerts, a) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Process("bad batch")
+	p.Process("oom")
+	p.Process("slow")
+	p.Event("restart", "WARNING", "restarted")
+	p.Event("crash", "WARNING", "crashed")
+	p.Event("stall", "WARNING", "stalled")
+	p.Close()
+
+	want := map[string]int{"/batches": 1, "/pager": 2, "/default": 2, "/events": 1}
+	mu.Lock()
+	defer mu.Unlock()
+	for path, n := range want {
+		if posts[path] != n {
+			t.Errorf("posts = %v, want %v", posts, want)
+			break
+		}
+	}
+	if alerts[3].Severity != "INFO" || alerts[4].Severity != "CRITICAL" || alerts[5].Severity != "WARNING" {
+		t.Errorf("event severities = %s, %s, %s", alerts[3].Severity, alerts[4].Severity, alerts[5].Severity)
+	}
+}
+
+func TestPipelineSetMetadata(t *testing.T) {
+	var alerts []Alert
+	config := &Config{Enrichment: EnrichmentConfig{Environment: "prod"}}
+	p, err := NewPipeline(config, Options{
+		DryRun:  true,
+		OnAlert: func(a Alert) { alerts = append(alerts, a) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.SetMetadata("version", "v1")
+	p.Event("restart", "WARNING", "first")
+	p.SetMetadata("version", "v2")
+	p.Event("restart", "WARNING", "second")
+	p.SetMetadata("version", "")
+	p.Event("restart", "WARNING", "third")
+	if len(alerts) != 3 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if alerts[0].Metadata["version"] != "v1" || alerts[0].Metadata["environment"] != "prod" {
+		t.Errorf("first metadata = %v", alerts[0].Metadata)
+	}
+	if alerts[1].Metadata["version"] != "v2" {
+		t.Errorf("second metadata = %v", alerts[1].Metadata)
+	}
+	if _, ok := alerts[2].Metadata["version"]; ok || alerts[2].Metadata["environment"] != "prod" {
+		t.Errorf("third metadata = %v", alerts[2].Metadata)
+	}
+}
+
+func TestPipelineStructuredFields(t *testing.T) {
+	var alerts []Alert
+	config := &Config{Patterns: []PatternConfig{
+		{Pattern: "Failed to add tx", Module: "txpool", Fields: map[string]string{"err": "nonce"}},
+	}}
+	p, err := NewPipeline(config, Options{
+		DryRun:  true,
+		OnAlert: func(a Alert) { alerts = append(alerts, a) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Process(`[EROR] [06-04|12:00:00.000] [txpool] Failed to add tx err="underpriced"`)
+	p.Process(`[EROR] [06-04|12:00:00.000] [txpool] Failed to add tx err="nonce too low" nonce=7`)
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if alerts[0].Module != "txpool" || alerts[0].Fields["nonce"] != "7" || alerts[0].Fields["err"] != "nonce too low" {
+		t.Errorf("alert = %+v", alerts[0])
+	}
+}
+
+func TestPipelineAlertOnStderr(t *testing.T) {
+	var alerts []Alert
+	config := &Config{
+		Patterns:          []PatternConfig{{Pattern: "bad batch"}},
+		LogLevelThreshold: "WARN",
+		AlertOnStderr:     true,
+	}
+	p, err := NewPipeline(config, Options{
+		DryRun:  true,
+		OnAlert: func(a Alert) { alerts = append(alerts, a) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if p.ProcessStream("panic: runtime error", StreamStdout) {
+		t.Error("unmatched stdout line reported as matched")
+	}
+	if p.ProcessStream("[INFO] [06-04|12:00:00.000] all good", StreamStderr) {
+		t.Error("stderr line below the threshold reported as matched")
+	}
+	if !p.ProcessStream("[EROR] [06-04|12:00:00.000] bad batch", StreamStderr) {
+		t.Error("matching line not reported as matched")
+	}
+	p.ProcessStream("panic: runtime error", StreamStderr)
+	if len(alerts) != 2 {
+		t.Fatalf("alerts = %+v", alerts)
+	}
+	if alerts[0].Pattern != "bad batch" || alerts[0].Stream != StreamStderr {
+		t.Errorf("first alert = %+v", alerts[0])
+	}
+	if alerts[1].Pattern != StderrPattern || alerts[1].Log != "panic: runtime error" {
+		t.Errorf("second alert = %+v", alerts[1])
+	}
+}
+
+func TestPipelineAlertOnStderrDefaults(t *testing.T) {
+	var alerts []Alert
+	config := &Config{AlertOnStderr: true}
+	p, err := NewPipeline(config, Options{
+		DryRun:  true,
+		OnAlert: func(a Alert) { alerts = append(alerts, a) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	for _, log := range []string{
+		"[INFO] [06-04|12:00:00.000] all good",
+		"[WARN] [06-04|12:00:00.000] slow peer",
+	} {
+		if p.ProcessStream(log, StreamStderr) {
+			t.Errorf("stderr line below ERROR reported as matched: %s", log)
+		}
+	}
+	p.ProcessStream("[EROR] [06-04|12:00:00.000] bad batch", StreamStderr)
+	p.ProcessStream("[EROR] [06-04|12:00:01.000] bad batch", StreamStderr)
+	if len(alerts) != 1 {
+		t.Fatalf("alerts = %+v, want one without a timeout", alerts)
+	}
+}
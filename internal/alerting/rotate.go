@@ -0,0 +1,243 @@
+package alerting
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an append-only log file that is rotated once it exceeds a
+// size or age limit. Rotated files are timestamped, optionally gzipped, and
+// pruned down to MaxBackups.
+type RotatingFile struct {
+	path     string
+	cfg      LogRotationConfig
+	shared   bool
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	mu       sync.Mutex
+}
+
+func NewRotatingFile(path string, cfg LogRotationConfig) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, cfg: cfg}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// NewSharedRotatingFile opens a RotatingFile that another process, e.g. a
+// child given File as its output, writes through its own descriptor. It is
+// rotated by copying and truncating it, so that process keeps writing to the
+// live file, and only by Rotate and Check as its writes go unnoticed.
+func NewSharedRotatingFile(path string, cfg LogRotationConfig) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, cfg: cfg, shared: true}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", rf.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", rf.path, err)
+	}
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	return nil
+}
+
+func (rf *RotatingFile) shouldRotate(next int) bool {
+	if rf.size == 0 {
+		return false
+	}
+	if rf.cfg.MaxSizeMB > 0 && rf.size+int64(next) > int64(rf.cfg.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	if rf.cfg.IntervalHours > 0 && time.Since(rf.openedAt) >= time.Duration(rf.cfg.IntervalHours)*time.Hour {
+		return true
+	}
+	return false
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.shouldRotate(len(p)) {
+		if err := rf.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rotating log file: %v\n", err)
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// File returns the file currently written.
+func (rf *RotatingFile) File() *os.File {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file
+}
+
+// Rotate rotates the file now unless it is empty.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if err := rf.stat(); err != nil || rf.size == 0 {
+		return err
+	}
+	return rf.rotate()
+}
+
+// Check rotates the file once it exceeds its limits, taking writes made
+// through other descriptors into account.
+func (rf *RotatingFile) Check() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if err := rf.stat(); err != nil || !rf.shouldRotate(0) {
+		return err
+	}
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) stat() error {
+	info, err := rf.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat log file %s: %w", rf.path, err)
+	}
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *RotatingFile) rotate() error {
+	if rf.shared {
+		return rf.copyTruncate()
+	}
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	backup := fmt.Sprintf("%s.%s", rf.path, time.Now().Format("20060102-150405.000000"))
+	if err := os.Rename(rf.path, backup); err != nil {
+		// Keep writing to the unrotated file rather than a closed one.
+		if openErr := rf.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return err
+	}
+	rf.backedUp(backup)
+	return rf.open()
+}
+
+// copyTruncate rotates a shared file in place. Lines written between the
+// copy and the truncation are lost.
+func (rf *RotatingFile) copyTruncate() error {
+	backup := fmt.Sprintf("%s.%s", rf.path, time.Now().Format("20060102-150405.000000"))
+	in, err := os.Open(rf.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(backup)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := rf.file.Truncate(0); err != nil {
+		return err
+	}
+	rf.size = 0
+	rf.openedAt = time.Now()
+	rf.backedUp(backup)
+	return nil
+}
+
+func (rf *RotatingFile) backedUp(backup string) {
+	if rf.cfg.Compress {
+		if err := gzipFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "Error compressing %s: %v\n", backup, err)
+		}
+	}
+	rf.pruneBackups()
+}
+
+// pruneBackups removes the oldest rotated files beyond MaxBackups. Backup
+// names sort chronologically thanks to their timestamp suffix.
+func (rf *RotatingFile) pruneBackups() {
+	if rf.cfg.MaxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return
+	}
+	sort.Strings(backups)
+	for len(backups) > rf.cfg.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing old log file: %v\n", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func LogToFile(out io.Writer, log, msgPrefix string) {
+	if out == nil {
+		return
+	}
+	l := fmt.Sprintf("%s %s \n", msgPrefix, log)
+	if _, err := io.WriteString(out, l); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing to file: %v\n", err)
+	}
+}
@@ -0,0 +1,150 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFileRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "alerts.log")
+	rf, err := NewRotatingFile(path, LogRotationConfig{MaxSizeMB: 1, MaxBackups: 2, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	line := []byte(strings.Repeat("x", 400*1024) + "\n")
+	for i := 0; i < 10; i++ {
+		if _, err := rf.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want 2 after pruning", backups)
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, ".gz") {
+			t.Errorf("backup %s is not compressed", backup)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1024*1024 {
+		t.Errorf("current file is %d bytes, want at most 1MB", info.Size())
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	rf, err := NewRotatingFile(path, LogRotationConfig{MaxSizeMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	line := []byte(strings.Repeat("x", 600*1024) + "\n")
+	if _, err := rf.Write(line); err != nil {
+		t.Fatal(err)
+	}
+	// Without the file the rename of the rotation fails.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := rf.Write(line); err != nil {
+			t.Fatalf("write %d after the failed rotation: %v", i, err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(line)) {
+		t.Errorf("reopened file is %d bytes, want %d", info.Size(), len(line))
+	}
+}
+
+func TestSharedRotatingFileCopiesAndTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdout.log")
+	if err := os.WriteFile(path, []byte("previous run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf, err := NewSharedRotatingFile(path, LogRotationConfig{MaxSizeMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if err := rf.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is written through another descriptor, as by a child.
+	writer, err := os.OpenFile(rf.File().Name(), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	line := strings.Repeat("x", 600*1024) + "\n"
+	for i := 0; i < 2; i++ {
+		if _, err := writer.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.WriteString("after\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "after\n" {
+		t.Errorf("live file has %d bytes, want only the line written after the rotation", len(content))
+	}
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("backups = %v, want the previous run and the rotated one", backups)
+	}
+	previous, err := os.ReadFile(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(previous) != "previous run\n" {
+		t.Errorf("first backup = %q, want the previous run", previous)
+	}
+}
+
+func TestLogToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	rf, err := NewRotatingFile(path, LogRotationConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	LogToFile(rf, "bad batch", "node-1")
+	LogToFile(nil, "ignored", "node-1")
+	rf.Close()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "node-1 bad batch \n" {
+		t.Errorf("content = %q", content)
+	}
+}
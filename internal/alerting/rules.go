@@ -0,0 +1,73 @@
+package alerting
+
+import "regexp"
+
+// Rule is a compiled pattern together with its matching options.
+type Rule struct {
+	Pattern  string
+	Regex    *regexp.Regexp
+	MinLevel Level
+	// Module and Fields, when set, must match the module and the named
+	// key=val fields of a parsed erigon line.
+	Module *regexp.Regexp
+	Fields map[string]*regexp.Regexp
+}
+
+func (r Rule) structured() bool {
+	return r.Module != nil || len(r.Fields) > 0
+}
+
+// matchesLine checks the module and field filters against the parsed line.
+// Lines not in erigon's format don't match rules with filters.
+func (r Rule) matchesLine(lazy *lazyLine) bool {
+	if !r.structured() {
+		return true
+	}
+	line := lazy.get()
+	if line == nil {
+		return false
+	}
+	if r.Module != nil && !r.Module.MatchString(line.Module) {
+		return false
+	}
+	for key, regex := range r.Fields {
+		value, ok := line.Fields[key]
+		if !ok || !regex.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// lazyLine parses a log line on first use.
+type lazyLine struct {
+	log    string
+	parsed bool
+	line   *LogLine
+}
+
+func (l *lazyLine) get() *LogLine {
+	if !l.parsed {
+		l.parsed = true
+		if line, ok := ParseLogLine(l.log); ok {
+			l.line = &line
+		}
+	}
+	return l.line
+}
+
+// SearchLog returns the first rule matching log. Rules with a minimum level
+// skip lines whose level is known to be lower, before running the regex.
+func SearchLog(log string, rules []Rule) (bool, string) {
+	level := ExtractLevel(log)
+	line := &lazyLine{log: log}
+	for _, rule := range rules {
+		if level != LevelUnknown && level < rule.MinLevel {
+			continue
+		}
+		if rule.Regex.MatchString(log) && rule.matchesLine(line) {
+			return true, rule.Pattern
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,48 @@
+package alerting
+
+import "sync"
+
+// MatchSampler counts every match per pattern and lets only every Nth match of
+// a pattern through to alerting.
+type MatchSampler struct {
+	rates  map[string]int
+	counts map[string]int64
+	mu     sync.Mutex
+}
+
+func NewMatchSampler(rates map[string]int) *MatchSampler {
+	return &MatchSampler{
+		rates:  rates,
+		counts: make(map[string]int64),
+	}
+}
+
+// Sample records a match and reports whether it should be alerted on. The
+// first match of a pattern always passes.
+func (s *MatchSampler) Sample(pattern string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[pattern]++
+	rate := s.rates[pattern]
+	if rate <= 1 {
+		return true
+	}
+	return (s.counts[pattern]-1)%int64(rate) == 0
+}
+
+func (s *MatchSampler) Count(pattern string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[pattern]
+}
+
+// Counts returns a snapshot of the total matches per pattern.
+func (s *MatchSampler) Counts() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int64, len(s.counts))
+	for pattern, count := range s.counts {
+		counts[pattern] = count
+	}
+	return counts
+}
@@ -0,0 +1,33 @@
+package alerting
+
+import "testing"
+
+func TestMatchSampler(t *testing.T) {
+	s := NewMatchSampler(map[string]int{"noisy": 3})
+
+	var passed []int
+	for i := 1; i <= 7; i++ {
+		if s.Sample("noisy") {
+			passed = append(passed, i)
+		}
+	}
+	want := []int{1, 4, 7}
+	if len(passed) != len(want) {
+		t.Fatalf("passed matches %v, want %v", passed, want)
+	}
+	for i := range want {
+		if passed[i] != want[i] {
+			t.Fatalf("passed matches %v, want %v", passed, want)
+		}
+	}
+
+	if !s.Sample("quiet") || !s.Sample("quiet") {
+		t.Error("patterns without a sample rate should always pass")
+	}
+	if got := s.Count("noisy"); got != 7 {
+		t.Errorf("Count(noisy) = %d, want 7", got)
+	}
+	if got := s.Counts()["quiet"]; got != 2 {
+		t.Errorf("Counts()[quiet] = %d, want 2", got)
+	}
+}
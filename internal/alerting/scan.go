@@ -0,0 +1,61 @@
+package alerting
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// DefaultMaxLineBytes is the longest line read in full when maxLineBytes is
+// not configured; it matches bufio.Scanner's own default.
+const DefaultMaxLineBytes = bufio.MaxScanTokenSize
+
+// TruncatedMarker is appended to lines cut off at the maximum line length.
+const TruncatedMarker = " ...[truncated]"
+
+// NewLineScanner returns a scanner over the lines of r that truncates lines
+// longer than maxLine bytes (DefaultMaxLineBytes if not positive) and marks
+// them with TruncatedMarker, where a plain bufio.Scanner would stop with
+// bufio.ErrTooLong.
+func NewLineScanner(r io.Reader, maxLine int) *bufio.Scanner {
+	if maxLine <= 0 {
+		maxLine = DefaultMaxLineBytes
+	}
+	scanner := bufio.NewScanner(r)
+	initial := 4096
+	if maxLine < initial {
+		initial = maxLine
+	}
+	// One extra byte so a line of exactly maxLine fits with its newline.
+	scanner.Buffer(make([]byte, initial), maxLine+1)
+
+	// After returning a truncated line, the rest of it is discarded.
+	skipping := false
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		i := bytes.IndexByte(data, '\n')
+		if skipping {
+			if i >= 0 {
+				skipping = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+		if i >= 0 && i <= maxLine {
+			return bufio.ScanLines(data, atEOF)
+		}
+		if len(data) > maxLine {
+			skipping = true
+			line := append(dropCR(data[:maxLine:maxLine]), TruncatedMarker...)
+			return maxLine, line, nil
+		}
+		return bufio.ScanLines(data, atEOF)
+	})
+	return scanner
+}
+
+func dropCR(data []byte) []byte {
+	if len(data) > 0 && data[len(data)-1] == '\r' {
+		return data[:len(data)-1]
+	}
+	return data
+}
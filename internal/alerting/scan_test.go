@@ -0,0 +1,29 @@
+package alerting
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineScannerTruncatesLongLines(t *testing.T) {
+	input := "short\n" + strings.Repeat("z", 16) + "\n" + strings.Repeat("x", 100) + "\nafter\r\n" + strings.Repeat("y", 20)
+	scanner := NewLineScanner(strings.NewReader(input), 16)
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"short",
+		strings.Repeat("z", 16),
+		strings.Repeat("x", 16) + TruncatedMarker,
+		"after",
+		strings.Repeat("y", 16) + TruncatedMarker,
+	}
+	if strings.Join(lines, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
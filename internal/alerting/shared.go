@@ -0,0 +1,86 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultSharedKeyPrefix = "alerting:"
+	defaultSharedTimeout   = 2 * time.Second
+)
+
+// CooldownStore shares cooldowns between AlertManagers on different hosts.
+// Silences stay local to each host.
+type CooldownStore interface {
+	// Claim reports whether the caller may alert on key, in which case no
+	// other caller can claim it until cooldown has passed.
+	Claim(key string, cooldown time.Duration) (bool, error)
+	// Release ends the cooldown of key early.
+	Release(key string) error
+}
+
+// RedisStore is a CooldownStore keeping each claim as a Redis key that
+// expires with its cooldown.
+type RedisStore struct {
+	client  *redis.Client
+	prefix  string
+	owner   string
+	timeout time.Duration
+}
+
+// NewRedisStore connects to the configured Redis server. Keys are namespaced
+// by the configured prefix and service, and claims are tagged with owner.
+func NewRedisStore(cfg SharedStateConfig, service, owner string) (*RedisStore, error) {
+	timeout := defaultSharedTimeout
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	prefix := cfg.KeyPrefix
+	if prefix == "" {
+		prefix = defaultSharedKeyPrefix
+	}
+	if service != "" {
+		prefix += service + ":"
+	}
+
+	s := &RedisStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:         cfg.RedisAddr,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			DialTimeout:  timeout,
+			ReadTimeout:  timeout,
+			WriteTimeout: timeout,
+		}),
+		prefix:  prefix,
+		owner:   owner,
+		timeout: timeout,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		s.client.Close()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", cfg.RedisAddr, err)
+	}
+	return s, nil
+}
+
+func (s *RedisStore) Claim(key string, cooldown time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.client.SetNX(ctx, s.prefix+key, s.owner, cooldown).Result()
+}
+
+func (s *RedisStore) Release(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
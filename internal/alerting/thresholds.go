@@ -0,0 +1,124 @@
+package alerting
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultThresholdPercent is the share of a limit thresholds alert at unless
+// configured otherwise.
+const DefaultThresholdPercent = 90
+
+// ThresholdConfig alerts when a numeric field of erigon log lines, such as a
+// zk counter or the gas of a batch, comes close to its limit, ahead of the
+// overflow error.
+type ThresholdConfig struct {
+	// Field is the key of the key=val field holding the value. A value
+	// like "1500/1596" carries its own limit, and one like
+	// "SHA: 1500/1596 A: 10/20" holds several named counters.
+	Field string `json:"field"`
+	// Module is a regex the module of the line must match.
+	Module string `json:"module"`
+	// Limit is the limit of plain numbers.
+	Limit float64 `json:"limit"`
+	// Percent is the share of the limit that alerts, DefaultThresholdPercent
+	// if 0.
+	Percent        float64 `json:"percent"`
+	Severity       string  `json:"severity"`
+	TimeoutMinutes int     `json:"timeoutMinutes"`
+}
+
+// threshold is a compiled ThresholdConfig.
+type threshold struct {
+	ThresholdConfig
+	name   string
+	module *regexp.Regexp
+}
+
+// counterRegex matches the "name: used/limit" counters of a field value.
+var counterRegex = regexp.MustCompile(`(?:([A-Za-z][\w-]*)\s*[:=]\s*)?(\d+(?:\.\d+)?)\s*/\s*(\d+(?:\.\d+)?)`)
+
+// ThresholdName is the pattern name the alerts of a threshold on field carry.
+func ThresholdName(field string) string {
+	return "threshold:" + field
+}
+
+func compileThreshold(tc ThresholdConfig) (threshold, error) {
+	if tc.Field == "" {
+		return threshold{}, fmt.Errorf("field is missing")
+	}
+	if tc.Percent < 0 || tc.Percent > 100 {
+		return threshold{}, fmt.Errorf("percent %g is outside 0-100", tc.Percent)
+	}
+	if tc.Percent == 0 {
+		tc.Percent = DefaultThresholdPercent
+	}
+	t := threshold{ThresholdConfig: tc, name: ThresholdName(tc.Field)}
+	if tc.Module != "" {
+		var err error
+		if t.module, err = regexp.Compile(tc.Module); err != nil {
+			return threshold{}, fmt.Errorf("invalid module: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// cooldown follows the patterns: without a timeout, a threshold alerts once.
+func (t threshold) cooldown() time.Duration {
+	if t.TimeoutMinutes == 0 {
+		return neverRepeat
+	}
+	return time.Duration(t.TimeoutMinutes) * time.Minute
+}
+
+// check returns the counters of line that reached the threshold, formatted
+// like "SHA 1500/1596 (94%)".
+func (t threshold) check(line LogLine) []string {
+	value, ok := line.Fields[t.Field]
+	if !ok || (t.module != nil && !t.module.MatchString(line.Module)) {
+		return nil
+	}
+	var reached []string
+	counters := counterRegex.FindAllStringSubmatch(value, -1)
+	if len(counters) == 0 && t.Limit > 0 {
+		// A plain number is measured against the configured limit.
+		counters = [][]string{{value, "", strings.TrimSpace(value), strconv.FormatFloat(t.Limit, 'f', -1, 64)}}
+	}
+	for _, c := range counters {
+		used, err := strconv.ParseFloat(strings.ReplaceAll(c[2], ",", ""), 64)
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseFloat(c[3], 64)
+		if err != nil || limit <= 0 {
+			continue
+		}
+		if percent := used / limit * 100; percent >= t.Percent {
+			counter := fmt.Sprintf("%s/%s (%.0f%%)", c[2], c[3], percent)
+			if c[1] != "" {
+				counter = c[1] + " " + counter
+			}
+			reached = append(reached, counter)
+		}
+	}
+	return reached
+}
+
+// checkThresholds alerts on the thresholds an erigon log line reaches.
+func (p *Pipeline) checkThresholds(log, stream string) {
+	line, ok := ParseLogLine(log)
+	if !ok || len(line.Fields) == 0 {
+		return
+	}
+	for _, t := range p.thresholds {
+		reached := t.check(line)
+		if len(reached) == 0 {
+			continue
+		}
+		message := fmt.Sprintf("%s reached %g%% of its limit: %s\n%s", t.Field, t.Percent, strings.Join(reached, ", "), log)
+		p.alert(t.name, stream, []string{message})
+	}
+}
@@ -0,0 +1,86 @@
+package alerting
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThresholdCheck(t *testing.T) {
+	counters, err := compileThreshold(ThresholdConfig{Field: "counters", Module: "Execution"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gas, err := compileThreshold(ThresholdConfig{Field: "gas", Limit: 30000000, Percent: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		threshold threshold
+		line      string
+		want      []string
+	}{
+		{counters, `[INFO] [06-04|12:00:00.000] [5/13 Execution] batch done counters="SHA: 1500/1596 A: 10/20 K: 90/100"`, []string{"SHA 1500/1596 (94%)", "K 90/100 (90%)"}},
+		{counters, `[INFO] [06-04|12:00:00.000] [5/13 Execution] batch done counters="SHA: 15/1596"`, nil},
+		{counters, `[INFO] [06-04|12:00:00.000] [Sequencer] batch done counters="SHA: 1500/1596"`, nil},
+		{counters, `[INFO] [06-04|12:00:00.000] [5/13 Execution] batch done counters=1500/1596`, []string{"1500/1596 (94%)"}},
+		{gas, `[INFO] [06-04|12:00:00.000] batch closed gas=25000000`, []string{"25000000/30000000 (83%)"}},
+		{gas, `[INFO] [06-04|12:00:00.000] batch closed gas=2000000`, nil},
+		{gas, `[INFO] [06-04|12:00:00.000] batch closed gas=lots`, nil},
+	}
+	for _, tt := range tests {
+		line, ok := ParseLogLine(tt.line)
+		if !ok {
+			t.Fatalf("ParseLogLine(%q) failed", tt.line)
+		}
+		got := tt.threshold.check(line)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("check(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	for _, tc := range []ThresholdConfig{{}, {Field: "gas", Percent: 120}, {Field: "gas", Module: "("}} {
+		if _, err := compileThreshold(tc); err == nil {
+			t.Errorf("compileThreshold(%+v) succeeded", tc)
+		}
+	}
+}
+
+func TestPipelineThresholds(t *testing.T) {
+	now := time.Date(2024, 6, 4, 12, 0, 0, 0, time.UTC)
+	var alerts []Alert
+	config := &Config{
+		Patterns:   []PatternConfig{{Pattern: "overflow", Severity: "CRITICAL"}},
+		Thresholds: []ThresholdConfig{{Field: "counters", Severity: "WARNING", TimeoutMinutes: 10}},
+	}
+	p, err := NewPipeline(config, Options{
+		DryRun:  true,
+		Clock:   func() time.Time { return now },
+		OnAlert: func(a Alert) { alerts = append(alerts, a) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	p.Process(`[INFO] [06-04|12:00:00.000] batch done counters="S: 95/100"`)
+	// Within the cooldown.
+	now = now.Add(time.Minute)
+	p.Process(`[INFO] [06-04|12:01:00.000] batch done counters="S: 99/100"`)
+	now = now.Add(10 * time.Minute)
+	p.Process(`[EROR] [06-04|12:11:00.000] counters overflow counters="S: 101/100"`)
+
+	if len(alerts) != 3 {
+		t.Fatalf("fired %d alerts, want 3: %+v", len(alerts), alerts)
+	}
+	if alerts[0].Pattern != ThresholdName("counters") || alerts[0].Severity != "WARNING" || !strings.HasPrefix(alerts[0].Log, "counters reached 90% of its limit: S 95/100 (95%)") {
+		t.Errorf("first alert = %+v", alerts[0])
+	}
+	if alerts[1].Pattern != ThresholdName("counters") || alerts[1].SuppressionCount != 1 {
+		t.Errorf("second alert = %+v", alerts[1])
+	}
+	if alerts[2].Pattern != "overflow" {
+		t.Errorf("third alert = %+v", alerts[2])
+	}
+}
@@ -0,0 +1,66 @@
+// Package systemd installs the commands as systemd services.
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// ExecStart returns the ExecStart line running exe with args.
+func ExecStart(exe string, args []string) string {
+	execStart := []string{Quote(exe)}
+	for _, arg := range args {
+		execStart = append(execStart, Quote(arg))
+	}
+	return strings.Join(execStart, " ")
+}
+
+// Quote quotes an ExecStart argument when it contains characters systemd
+// would otherwise split on or interpret.
+func Quote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%;") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// WriteUnit renders unit with data into name.service in unitDir, and
+// returns its path.
+func WriteUnit(unitDir, name string, unit *template.Template, data interface{}) (string, error) {
+	unitPath := filepath.Join(unitDir, name+".service")
+	file, err := os.Create(unitPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create unit file: %w", err)
+	}
+	err = unit.Execute(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write unit file: %w", err)
+	}
+	return unitPath, nil
+}
+
+// Enable reloads systemd and enables and starts the service name, or only
+// tells how to when start is false.
+func Enable(name string, start bool) error {
+	if !start {
+		fmt.Printf("Run `systemctl daemon-reload && systemctl enable --now %s` to start it\n", name)
+		return nil
+	}
+	for _, args := range [][]string{{"daemon-reload"}, {"enable", "--now", name}} {
+		cmd := exec.Command("systemctl", args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("systemctl %s failed: %w", strings.Join(args, " "), err)
+		}
+	}
+	return nil
+}
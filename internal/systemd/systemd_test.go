@@ -0,0 +1,47 @@
+package systemd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"-config=config.json", "-config=config.json"},
+		{"", `""`},
+		{"/opt/my dir/config.json", `"/opt/my dir/config.json"`},
+		{`-prefix=$HOST "a"`, `"-prefix=$$HOST \"a\""`},
+		{"100%", `"100%%"`},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.arg); got != tt.want {
+			t.Errorf("Quote(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestWriteUnit(t *testing.T) {
+	unit := template.Must(template.New("unit").Parse("ExecStart={{.ExecStart}}\n"))
+	path, err := WriteUnit(t.TempDir(), "alerts", unit, map[string]string{
+		"ExecStart": ExecStart("/usr/bin/output_alerts", []string{"-config=a b.json"}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "alerts.service" {
+		t.Errorf("path = %s", path)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `ExecStart=/usr/bin/output_alerts "-config=a b.json"`; strings.TrimSpace(string(content)) != want {
+		t.Errorf("unit = %q, want %q", content, want)
+	}
+}